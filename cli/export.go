@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const exportDateFormat = "2006-01-02"
+
+// runExport downloads an export from the web module, requesting the range a
+// few days at a time so no single response has to hold all of it.
+func runExport(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("export")
+	baseURL := fs.String("url", os.Getenv("DMARC_URL"), "base URL of the web module, defaults to $DMARC_URL")
+	token := fs.String("token", os.Getenv("DMARC_TOKEN"), "API token for the web module, defaults to $DMARC_TOKEN")
+	format := fs.String("format", "csv", "csv or ndjson")
+	fromArg := fs.String("from", "", "first date to export, 2006-01-02")
+	toArg := fs.String("to", "", "last date to export, defaults to yesterday")
+	days := fs.Int("days", 7, "number of days to export when -from is not given")
+	domain := fs.String("domain", "", "only export reports for this domain")
+	org := fs.String("org", "", "only export reports from this reporting organization")
+	source := fs.String("source", "", "only export records for this source IP")
+	tz := fs.String("tz", "", "IANA time zone the dates are days in, defaults to the web module's")
+	chunk := fs.Int("chunk", 7, "number of days to request at a time")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || (fs.Arg(0) != "records" && fs.Arg(0) != "summary") {
+		fs.Usage()
+		return fmt.Errorf("expected records or summary")
+	}
+	if *baseURL == "" {
+		return fmt.Errorf("-url or DMARC_URL is required")
+	}
+	if *format != "csv" && *format != "ndjson" {
+		return fmt.Errorf("-format must be csv or ndjson")
+	}
+	if *days < 1 || *chunk < 1 {
+		return fmt.Errorf("-days and -chunk must be at least 1")
+	}
+
+	to := time.Now().UTC().AddDate(0, 0, -1)
+	if *toArg != "" {
+		var err error
+		if to, err = time.Parse(exportDateFormat, *toArg); err != nil {
+			return fmt.Errorf("invalid -to date %v", *toArg)
+		}
+	}
+	from := to.AddDate(0, 0, 1-*days)
+	if *fromArg != "" {
+		var err error
+		if from, err = time.Parse(exportDateFormat, *fromArg); err != nil {
+			return fmt.Errorf("invalid -from date %v", *fromArg)
+		}
+	}
+	if from.After(to) {
+		return fmt.Errorf("-from must not be after -to")
+	}
+
+	q := url.Values{}
+	q.Set("format", *format)
+	if *domain != "" {
+		q.Set("domain", *domain)
+	}
+	if *org != "" {
+		q.Set("org", *org)
+	}
+	if *source != "" {
+		q.Set("source", *source)
+	}
+	if *tz != "" {
+		q.Set("tz", *tz)
+	}
+
+	endpoint := strings.TrimSuffix(*baseURL, "/") + "/export/" + fs.Arg(0)
+	for start := from; !start.After(to); start = start.AddDate(0, 0, *chunk) {
+		end := start.AddDate(0, 0, *chunk-1)
+		if end.After(to) {
+			end = to
+		}
+		q.Set("from", start.Format(exportDateFormat))
+		q.Set("to", end.Format(exportDateFormat))
+		if err := exportChunk(ctx, endpoint+"?"+q.Encode(), *token, out); err != nil {
+			return err
+		}
+		// Only the first chunk has the CSV header.
+		q.Set("header", "false")
+	}
+	return nil
+}
+
+// exportChunk copies the export at u to out as it arrives.
+func exportChunk(ctx context.Context, u, token string, out io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%v: %v %v", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	// An export that fails part way through ends with an error line for
+	// NDJSON, or an error trailer for CSV.
+	br := bufio.NewReader(resp.Body)
+	for {
+		line, err := br.ReadBytes('\n')
+		if bytes.HasPrefix(line, []byte(`{"error":`)) {
+			var e struct {
+				Error struct{ Message string }
+			}
+			json.Unmarshal(line, &e)
+			return fmt.Errorf("%v: export incomplete, %v", u, e.Error.Message)
+		}
+		if _, werr := out.Write(line); werr != nil {
+			return werr
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if msg := resp.Trailer.Get("X-Export-Error"); msg != "" {
+		return fmt.Errorf("%v: export incomplete, %v", u, msg)
+	}
+	return nil
+}
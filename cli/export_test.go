@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	var requests []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.String())
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Expected the bearer token but got %q", r.Header.Get("Authorization"))
+		}
+		q := r.URL.Query()
+		if q.Get("header") != "false" {
+			fmt.Fprintln(w, "date,domain")
+		}
+		fmt.Fprintf(w, "%v,%v\n", q.Get("from"), q.Get("domain"))
+	}))
+	defer s.Close()
+
+	var out bytes.Buffer
+	err := runExport(context.Background(), []string{"-url", s.URL + "/", "-token", "secret", "-from", "2024-01-01", "-to", "2024-01-10",
+		"-chunk", "4", "-domain", "example.com", "summary"}, &out)
+	if err != nil {
+		t.Errorf("Expected no error but got %v", err)
+	}
+
+	expected := "date,domain\n2024-01-01,example.com\n2024-01-05,example.com\n2024-01-09,example.com\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q but got %q", expected, out.String())
+	}
+
+	expectedRequests := []string{
+		"/export/summary?domain=example.com&format=csv&from=2024-01-01&to=2024-01-04",
+		"/export/summary?domain=example.com&format=csv&from=2024-01-05&header=false&to=2024-01-08",
+		"/export/summary?domain=example.com&format=csv&from=2024-01-09&header=false&to=2024-01-10",
+	}
+	if fmt.Sprint(requests) != fmt.Sprint(expectedRequests) {
+		t.Errorf("Expected requests %v but got %v", expectedRequests, requests)
+	}
+
+	err = runExport(context.Background(), []string{"-url", s.URL, "-format", "xml", "records"}, &out)
+	if err == nil {
+		t.Errorf("Expected an error for an unknown format but got none")
+	}
+}
+
+func TestExportIncomplete(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") == "ndjson" {
+			fmt.Fprintln(w, `{"date":"2024-01-01"}`)
+			fmt.Fprintln(w, `{"error":{"status":502,"message":"store unavailable"}}`)
+			return
+		}
+		w.Header().Set("Trailer", "X-Export-Error")
+		fmt.Fprintln(w, "date,domain")
+		w.(http.Flusher).Flush()
+		w.Header().Set("X-Export-Error", "store unavailable")
+	}))
+	defer s.Close()
+
+	for _, format := range []string{"csv", "ndjson"} {
+		var out bytes.Buffer
+		err := runExport(context.Background(), []string{"-url", s.URL, "-format", format, "-from", "2024-01-01", "-to", "2024-01-01", "summary"}, &out)
+		if err == nil || !strings.Contains(err.Error(), "store unavailable") {
+			t.Errorf("Expected an incomplete export error for %v but got %v", format, err)
+		}
+		if strings.Contains(out.String(), "error") {
+			t.Errorf("Expected the error line to be left out but got %q", out.String())
+		}
+	}
+}
@@ -0,0 +1,20 @@
+module github.com/ericdaugherty/dmarc/cli
+
+go 1.18
+
+require (
+	github.com/ericdaugherty/dmarc/dmarcrecord v0.0.0-00010101000000-000000000000
+	github.com/ericdaugherty/dmarc/dnscheck v0.0.0-00010101000000-000000000000
+	github.com/ericdaugherty/dmarc/report v0.0.0-00010101000000-000000000000
+)
+
+require (
+	github.com/DusanKasan/parsemail v1.2.0 // indirect
+	golang.org/x/net v0.7.0 // indirect
+)
+
+replace (
+	github.com/ericdaugherty/dmarc/dmarcrecord => ../dmarcrecord
+	github.com/ericdaugherty/dmarc/dnscheck => ../dnscheck
+	github.com/ericdaugherty/dmarc/report => ../report
+)
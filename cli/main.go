@@ -0,0 +1,104 @@
+// Command dmarc is a command line tool for working with DMARC records and
+// reports.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(ctx context.Context, args []string, out io.Writer) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"record", "[-json] [-server addr] <domain | record>", "Parse and lint a DMARC record", runRecord},
+		{"spf", "[-json] [-server addr] [-ip addr] [-flatten] <domain>", "Expand and evaluate an SPF record", runSPF},
+		{"export", "[-url url] [-token t] [-format csv|ndjson] [-from date] [-to date] [-days n] [-domain d] [-org o] [-source ip] [-chunk n] <records | summary>", "Download records or daily summaries from the web module", runExport},
+		{"parse", "[-json] [file ...]", "Summarize report emails, zip, gzip or XML files, or standard input", runParse},
+	}
+}
+
+// errIssues is returned by commands that completed but found errors, so the
+// process exits non-zero without printing anything further.
+var errIssues = errors.New("issues found")
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, c := range commands {
+		if c.name != os.Args[1] {
+			continue
+		}
+		err := c.run(context.Background(), os.Args[2:], os.Stdout)
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		if errors.Is(err, errIssues) {
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dmarc %v: %v\n", c.name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: dmarc <command> [arguments]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8v %v\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'dmarc <command> -h' for details.\n")
+}
+
+// newFlagSet returns a FlagSet whose usage message describes the named
+// command.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		for _, c := range commands {
+			if c.name == name {
+				fmt.Fprintf(fs.Output(), "Usage: dmarc %v %v\n\n%v.\n\n", c.name, c.args, c.summary)
+			}
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// newResolver returns a resolver that sends queries to server, or the
+// system resolver if server is empty.
+func newResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ericdaugherty/dmarc/report"
+)
+
+type parsedReport struct {
+	File     string         `json:"file"`
+	OrgName  string         `json:"orgName"`
+	Email    string         `json:"email,omitempty"`
+	ReportID string         `json:"reportId"`
+	Domain   string         `json:"domain"`
+	Policy   string         `json:"policy"`
+	Begin    time.Time      `json:"begin"`
+	End      time.Time      `json:"end"`
+	Messages int            `json:"messages"`
+	Pass     int            `json:"pass"`
+	Fail     int            `json:"fail"`
+	Records  []parsedRecord `json:"records"`
+}
+
+type parsedRecord struct {
+	SourceIP     string   `json:"sourceIp"`
+	Count        int      `json:"count"`
+	Disposition  string   `json:"disposition"`
+	DKIM         string   `json:"dkim"`
+	SPF          string   `json:"spf"`
+	HeaderFrom   string   `json:"headerFrom"`
+	EnvelopeFrom string   `json:"envelopeFrom,omitempty"`
+	Reasons      []string `json:"reasons,omitempty"`
+	DKIMResults  []string `json:"dkimResults,omitempty"`
+	SPFResults   []string `json:"spfResults,omitempty"`
+}
+
+func runParse(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("parse")
+	asJSON := fs.Bool("json", false, "print the reports as a JSON array")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	var reps []parsedReport
+	for _, name := range files {
+		rep, err := parseFile(name)
+		if err != nil {
+			return fmt.Errorf("%v: %v", name, err)
+		}
+		reps = append(reps, rep)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(reps)
+	}
+	for i, rep := range reps {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		printParsed(out, rep)
+	}
+	return nil
+}
+
+// parseFile reads the report in the named email, zip, gzip or XML file, or
+// standard input for "-".
+func parseFile(name string) (rep parsedReport, err error) {
+	var data []byte
+	if name == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return
+	}
+
+	xml, err := report.Extract(data)
+	if err != nil {
+		return
+	}
+	f, err := report.Parse(xml)
+	if err != nil {
+		return rep, fmt.Errorf("unable to decode XML. %v", err)
+	}
+	if name == "-" {
+		name = "stdin"
+	}
+	return summarize(name, f), nil
+}
+
+func summarize(name string, f report.Feedback) (rep parsedReport) {
+	md := f.ReportMetadata
+	rep = parsedReport{
+		File:     name,
+		OrgName:  md.OrgName,
+		Email:    md.Email,
+		ReportID: md.ReportID,
+		Domain:   f.PolicyPublished.Domain,
+		Policy:   f.PolicyPublished.Tags(),
+		Begin:    unixTime(md.DateRange.Begin),
+		End:      unixTime(md.DateRange.End),
+		Records:  []parsedRecord{},
+	}
+
+	for _, r := range f.Record {
+		pe := r.Row.PolicyEvaluated
+		count, err := strconv.Atoi(r.Row.Count)
+		if err != nil {
+			count = 1
+		}
+		rec := parsedRecord{
+			SourceIP:     r.Row.SourceIP,
+			Count:        count,
+			Disposition:  pe.Disposition,
+			DKIM:         pe.Dkim,
+			SPF:          pe.Spf,
+			HeaderFrom:   r.Identifiers.HeaderFrom,
+			EnvelopeFrom: r.Identifiers.EnvelopeFrom,
+		}
+		for _, reason := range pe.Reason {
+			rec.Reasons = append(rec.Reasons, reason.Type)
+		}
+		for _, d := range r.AuthResults.Dkim {
+			rec.DKIMResults = append(rec.DKIMResults, d.Domain+"="+d.Result)
+		}
+		for _, s := range r.AuthResults.Spf {
+			rec.SPFResults = append(rec.SPFResults, s.Domain+"="+s.Result)
+		}
+
+		rep.Messages += count
+		if pe.Dkim == "pass" || pe.Spf == "pass" {
+			rep.Pass += count
+		} else {
+			rep.Fail += count
+		}
+		rep.Records = append(rep.Records, rec)
+	}
+	return
+}
+
+func unixTime(s string) time.Time {
+	t, _ := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	return time.Unix(t, 0).UTC()
+}
+
+func printParsed(out io.Writer, rep parsedReport) {
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "File:\t%v\n", rep.File)
+	fmt.Fprintf(tw, "Reporter:\t%v\n", rep.OrgName)
+	if rep.Email != "" {
+		fmt.Fprintf(tw, "Email:\t%v\n", rep.Email)
+	}
+	fmt.Fprintf(tw, "Report ID:\t%v\n", rep.ReportID)
+	fmt.Fprintf(tw, "Domain:\t%v\n", rep.Domain)
+	fmt.Fprintf(tw, "Policy:\t%v\n", rep.Policy)
+	fmt.Fprintf(tw, "Period:\t%v to %v\n", rep.Begin.Format("2006-01-02 15:04 MST"), rep.End.Format("2006-01-02 15:04 MST"))
+	fmt.Fprintf(tw, "Messages:\t%v (%v pass, %v fail)\n", rep.Messages, rep.Pass, rep.Fail)
+	tw.Flush()
+
+	if len(rep.Records) == 0 {
+		return
+	}
+	fmt.Fprintln(out)
+	tw = tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE IP\tCOUNT\tDISPOSITION\tDKIM\tSPF\tHEADER FROM\tENVELOPE FROM\tDKIM RESULTS\tSPF RESULTS\tREASONS")
+	for _, r := range rep.Records {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", r.SourceIP, r.Count, r.Disposition, r.DKIM, r.SPF,
+			r.HeaderFrom, orDash(r.EnvelopeFrom), orDash(strings.Join(r.DKIMResults, " ")), orDash(strings.Join(r.SPFResults, " ")), orDash(strings.Join(r.Reasons, " ")))
+	}
+	tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const parseTestXML = `<?xml version="1.0" encoding="UTF-8" ?>
+<feedback>
+  <report_metadata>
+    <org_name>example.net</org_name>
+    <email>dmarc@example.net</email>
+    <report_id>1234</report_id>
+    <date_range><begin>1587081600</begin><end>1587167999</end></date_range>
+  </report_metadata>
+  <policy_published><domain>example.com</domain><p>reject</p><pct>100</pct></policy_published>
+  <record>
+    <row>
+      <source_ip>192.0.2.1</source_ip>
+      <count>3</count>
+      <policy_evaluated><disposition>none</disposition><dkim>pass</dkim><spf>fail</spf></policy_evaluated>
+    </row>
+    <identifiers><header_from>example.com</header_from></identifiers>
+    <auth_results><dkim><domain>example.com</domain><result>pass</result></dkim></auth_results>
+  </record>
+  <record>
+    <row>
+      <source_ip>198.51.100.7</source_ip>
+      <count>2</count>
+      <policy_evaluated><disposition>reject</disposition><dkim>fail</dkim><spf>fail</spf></policy_evaluated>
+    </row>
+    <identifiers><header_from>example.com</header_from></identifiers>
+    <auth_results><spf><domain>spammer.example</domain><result>fail</result></spf></auth_results>
+  </record>
+</feedback>`
+
+func TestParse(t *testing.T) {
+	dir := t.TempDir()
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(parseTestXML))
+	zw.Close()
+	name := filepath.Join(dir, "report.xml.gz")
+	if err := os.WriteFile(name, gz.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runParse(context.Background(), []string{"-json", name}, &out); err != nil {
+		t.Fatalf("Error parsing report: %v", err)
+	}
+	var reps []parsedReport
+	if err := json.Unmarshal(out.Bytes(), &reps); err != nil {
+		t.Fatalf("Error decoding output: %v", err)
+	}
+	if len(reps) != 1 {
+		t.Fatalf("Expected %v but got %v", 1, len(reps))
+	}
+	rep := reps[0]
+	if rep.Messages != 5 || rep.Pass != 3 || rep.Fail != 2 {
+		t.Errorf("Expected 5 messages, 3 pass and 2 fail but got %v, %v and %v", rep.Messages, rep.Pass, rep.Fail)
+	}
+	expected := "p=reject; pct=100"
+	if rep.Policy != expected {
+		t.Errorf("Expected %v but got %v", expected, rep.Policy)
+	}
+	if len(rep.Records) != 2 || rep.Records[1].SPFResults[0] != "spammer.example=fail" {
+		t.Errorf("Expected the SPF result of the second record but got %v", rep.Records)
+	}
+
+	out.Reset()
+	if err := runParse(context.Background(), []string{name}, &out); err != nil {
+		t.Fatalf("Error parsing report: %v", err)
+	}
+	if !strings.Contains(out.String(), "198.51.100.7  2      reject") {
+		t.Errorf("Expected a row for 198.51.100.7 but got %v", out.String())
+	}
+}
+
+func TestParseEmailWithoutContentType(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "report.eml")
+	email := "From: dmarc@example.net\r\nSubject: Report domain: example.com\r\n\r\nNo report here.\r\n"
+	if err := os.WriteFile(name, []byte(email), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err := runParse(context.Background(), []string{name}, &out)
+	if err == nil || !strings.Contains(err.Error(), "no content type") {
+		t.Errorf("Expected a missing content type error but got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ericdaugherty/dmarc/dmarcrecord"
+	"github.com/ericdaugherty/dmarc/dnscheck"
+)
+
+type recordReport struct {
+	Name     string              `json:"name,omitempty"`
+	Record   string              `json:"record"`
+	Parsed   *dmarcrecord.Record `json:"parsed,omitempty"`
+	Errors   []string            `json:"errors,omitempty"`
+	Warnings []string            `json:"warnings,omitempty"`
+}
+
+func runRecord(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("record")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	server := fs.String("server", "", "DNS server to query, host[:port]")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected a domain or record")
+	}
+
+	var rep recordReport
+	arg := fs.Arg(0)
+	if strings.HasPrefix(strings.ToLower(arg), "v=") {
+		rep = lintRecord(arg)
+	} else {
+		res := dnscheck.New(newResolver(*server)).CheckDMARC(ctx, arg)
+		rep = recordReport{Name: res.Name, Record: res.Record, Parsed: res.Parsed, Errors: res.Errors, Warnings: res.Warnings}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			return err
+		}
+	} else {
+		printRecord(out, rep)
+	}
+
+	if len(rep.Errors) > 0 {
+		return errIssues
+	}
+	return nil
+}
+
+func lintRecord(txt string) (rep recordReport) {
+	rep.Record = txt
+
+	r, err := dmarcrecord.Parse(txt)
+	if err != nil {
+		rep.Errors = append(rep.Errors, err.Error())
+		return
+	}
+	rep.Parsed = r
+
+	for _, i := range r.Lint() {
+		if i.Severity == dmarcrecord.Error {
+			rep.Errors = append(rep.Errors, i.Tag+": "+i.Message)
+		} else {
+			rep.Warnings = append(rep.Warnings, i.Tag+": "+i.Message)
+		}
+	}
+	return
+}
+
+func printRecord(out io.Writer, rep recordReport) {
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	if rep.Name != "" {
+		fmt.Fprintf(tw, "Name:\t%v\n", rep.Name)
+	}
+	fmt.Fprintf(tw, "Record:\t%v\n", rep.Record)
+
+	if r := rep.Parsed; r != nil {
+		fmt.Fprintf(tw, "Normalized:\t%v\n", r)
+		fmt.Fprintf(tw, "Policy:\t%v\n", r.Policy)
+		fmt.Fprintf(tw, "Subdomain Policy:\t%v\n", r.EffectiveSubdomainPolicy())
+		if r.NonexistentPolicy != "" {
+			fmt.Fprintf(tw, "Non-existent Policy:\t%v\n", r.NonexistentPolicy)
+		}
+		fmt.Fprintf(tw, "Percent:\t%v\n", r.EffectivePercent())
+		fmt.Fprintf(tw, "DKIM Alignment:\t%v\n", r.EffectiveDKIMAlignment())
+		fmt.Fprintf(tw, "SPF Alignment:\t%v\n", r.EffectiveSPFAlignment())
+		for _, u := range r.AggregateURIs {
+			fmt.Fprintf(tw, "Aggregate Reports:\t%v\n", u)
+		}
+		for _, u := range r.FailureURIs {
+			fmt.Fprintf(tw, "Failure Reports:\t%v\n", u)
+		}
+		fmt.Fprintf(tw, "Failure Options:\t%v\n", r.EffectiveFailureOptions())
+		fmt.Fprintf(tw, "Report Interval:\t%vs\n", r.EffectiveReportInterval())
+	}
+	tw.Flush()
+
+	for _, e := range rep.Errors {
+		fmt.Fprintf(out, "error: %v\n", e)
+	}
+	for _, w := range rep.Warnings {
+		fmt.Fprintf(out, "warning: %v\n", w)
+	}
+}
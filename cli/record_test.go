@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ericdaugherty/dmarc/dnscheck/dnstest"
+)
+
+func TestRecordText(t *testing.T) {
+	var out bytes.Buffer
+	err := runRecord(context.Background(), []string{"v=DMARC1; p=none; pct=50"}, &out)
+	if err != nil {
+		t.Errorf("Expected no error but got %v", err)
+	}
+
+	expected := "warning: rua: no aggregate report destination, no reports will be sent\nwarning: pct: has no effect with p=none\n"
+	if !strings.HasSuffix(out.String(), expected) {
+		t.Errorf("Expected output ending in \n%v\n but got: \n%v\n", expected, out.String())
+	}
+	if !regexp.MustCompile(`(?m)^Percent:\s+50$`).MatchString(out.String()) {
+		t.Errorf("Expected percent in output but got: \n%v\n", out.String())
+	}
+}
+
+func TestRecordInvalid(t *testing.T) {
+	var out bytes.Buffer
+	err := runRecord(context.Background(), []string{"v=DMARC1; p=block"}, &out)
+	if err != errIssues {
+		t.Errorf("Expected %v but got %v", errIssues, err)
+	}
+
+	expected := "Record:  v=DMARC1; p=block\nerror: dmarc: p: invalid policy \"block\"\n"
+	if out.String() != expected {
+		t.Errorf("Expected \n%v\n but got: \n%v\n", expected, out.String())
+	}
+}
+
+func TestRecordDomain(t *testing.T) {
+	s, err := dnstest.NewServer(dnstest.Zone{
+		"_dmarc.example.com": {TXT: []string{"v=DMARC1; p=reject; rua=mailto:dmarc@example.com"}},
+	})
+	if err != nil {
+		t.Fatalf("Error starting DNS server. %v", err)
+	}
+	defer s.Close()
+
+	var out bytes.Buffer
+	err = runRecord(context.Background(), []string{"-json", "-server", s.Addr, "example.com"}, &out)
+	if err != nil {
+		t.Errorf("Expected no error but got %v", err)
+	}
+
+	var rep recordReport
+	if err = json.Unmarshal(out.Bytes(), &rep); err != nil {
+		t.Fatalf("Error decoding JSON output. %v", err)
+	}
+	if rep.Name != "_dmarc.example.com" || rep.Parsed == nil || rep.Parsed.Policy != "reject" {
+		t.Errorf("Unexpected result %v", out.String())
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/ericdaugherty/dmarc/dnscheck"
+)
+
+// spfMaxRecordLength is the longest flattened record that comfortably fits
+// in a single UDP DNS response alongside the rest of the answer.
+const spfMaxRecordLength = 450
+
+type spfReport struct {
+	Domain      string               `json:"domain"`
+	Record      string               `json:"record,omitempty"`
+	Lookups     int                  `json:"lookups"`
+	VoidLookups int                  `json:"voidLookups"`
+	Networks    []string             `json:"networks,omitempty"`
+	Verdict     *dnscheck.SPFVerdict `json:"verdict,omitempty"`
+	Flattened   string               `json:"flattened,omitempty"`
+	Errors      []string             `json:"errors,omitempty"`
+	Warnings    []string             `json:"warnings,omitempty"`
+}
+
+func runSPF(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("spf")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	server := fs.String("server", "", "DNS server to query, host[:port]")
+	ipArg := fs.String("ip", "", "evaluate the record for this source IP")
+	flatten := fs.Bool("flatten", false, "print a flattened record using only ip4 and ip6 mechanisms")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected a domain")
+	}
+
+	var ip net.IP
+	if *ipArg != "" {
+		if ip = net.ParseIP(*ipArg); ip == nil {
+			return fmt.Errorf("invalid IP address %v", *ipArg)
+		}
+	}
+
+	exp := dnscheck.New(newResolver(*server)).ExpandSPF(ctx, fs.Arg(0))
+	rep := spfReport{Domain: exp.Domain, Lookups: exp.Lookups, VoidLookups: exp.VoidLookups, Errors: exp.Errors, Warnings: exp.Warnings}
+	if exp.Tree != nil {
+		rep.Record = exp.Tree.Record
+	}
+	for _, n := range exp.Networks() {
+		rep.Networks = append(rep.Networks, n.String())
+	}
+	if ip != nil {
+		v := exp.Evaluate(ip)
+		rep.Verdict = &v
+	}
+	if *flatten && exp.Tree != nil {
+		flat, err := exp.Flatten()
+		if err != nil {
+			rep.Warnings = append(rep.Warnings, fmt.Sprintf("unable to flatten record, %v", err))
+		}
+		rep.Flattened = flat
+		if len(rep.Flattened) > spfMaxRecordLength {
+			rep.Warnings = append(rep.Warnings, fmt.Sprintf("flattened record is %v bytes, more than %v", len(rep.Flattened), spfMaxRecordLength))
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			return err
+		}
+	} else {
+		printSPF(out, exp.Tree, rep)
+	}
+
+	if len(rep.Errors) > 0 {
+		return errIssues
+	}
+	return nil
+}
+
+func printSPF(out io.Writer, tree *dnscheck.SPFTree, rep spfReport) {
+	printSPFTree(out, tree, "")
+	fmt.Fprintf(out, "\nDNS lookups: %v (void: %v)\n", rep.Lookups, rep.VoidLookups)
+	fmt.Fprintf(out, "Networks: %v\n", len(rep.Networks))
+	for _, n := range rep.Networks {
+		fmt.Fprintf(out, "  %v\n", n)
+	}
+
+	if v := rep.Verdict; v != nil {
+		fmt.Fprintf(out, "\n%v: %v", v.IP, v.Result)
+		if v.Term != "" {
+			fmt.Fprintf(out, " (%v in %v)", v.Term, v.Domain)
+		}
+		fmt.Fprintln(out)
+		for _, s := range v.Skipped {
+			fmt.Fprintf(out, "  not evaluated: %v\n", s)
+		}
+	}
+
+	if rep.Flattened != "" {
+		fmt.Fprintf(out, "\nFlattened (%v bytes):\n%v\n", len(rep.Flattened), rep.Flattened)
+	}
+
+	for _, e := range rep.Errors {
+		fmt.Fprintf(out, "error: %v\n", e)
+	}
+	for _, w := range rep.Warnings {
+		fmt.Fprintf(out, "warning: %v\n", w)
+	}
+}
+
+// printSPFTree prints the record for each domain in the expansion, indenting
+// included records under the mechanism that includes them.
+func printSPFTree(out io.Writer, tree *dnscheck.SPFTree, indent string) {
+	if tree == nil {
+		return
+	}
+	fmt.Fprintf(out, "%v%v: %v\n", indent, tree.Domain, tree.Record)
+	for _, t := range tree.Terms {
+		if t.Include != nil {
+			printSPFTree(out, t.Include, indent+"  ")
+		} else if len(t.Nets) > 0 && t.Mechanism != "ip4" && t.Mechanism != "ip6" {
+			nets := make([]string, len(t.Nets))
+			for i, n := range t.Nets {
+				nets[i] = n.String()
+			}
+			fmt.Fprintf(out, "%v  %v -> %v\n", indent, t, strings.Join(nets, " "))
+		}
+	}
+	if tree.Redirect != nil {
+		printSPFTree(out, tree.Redirect, indent+"  ")
+	}
+}
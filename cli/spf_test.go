@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ericdaugherty/dmarc/dnscheck/dnstest"
+)
+
+func TestSPF(t *testing.T) {
+	s, err := dnstest.NewServer(dnstest.Zone{
+		"example.com":      {TXT: []string{"v=spf1 ip4:192.0.2.0/24 include:_spf.example.net -all"}},
+		"_spf.example.net": {TXT: []string{"v=spf1 ip6:2001:db8::/32 ~all"}},
+	})
+	if err != nil {
+		t.Fatalf("Error starting DNS server. %v", err)
+	}
+	defer s.Close()
+
+	var out bytes.Buffer
+	err = runSPF(context.Background(), []string{"-server", s.Addr, "-ip", "2001:db8::1", "-flatten", "example.com"}, &out)
+	if err != nil {
+		t.Errorf("Expected no error but got %v", err)
+	}
+
+	for _, expected := range []string{
+		"  _spf.example.net: v=spf1 ip6:2001:db8::/32 ~all\n",
+		"DNS lookups: 1 (void: 0)\n",
+		"2001:db8::1: pass (ip6:2001:db8::/32 in _spf.example.net)\n",
+		"v=spf1 ip4:192.0.2.0/24 ip6:2001:db8::/32 -all\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected output containing \n%v\n but got: \n%v\n", expected, out.String())
+		}
+	}
+
+	out.Reset()
+	err = runSPF(context.Background(), []string{"-json", "-server", s.Addr, "-ip", "198.51.100.1", "example.com"}, &out)
+	if err != nil {
+		t.Errorf("Expected no error but got %v", err)
+	}
+
+	var rep spfReport
+	if err = json.Unmarshal(out.Bytes(), &rep); err != nil {
+		t.Fatalf("Error decoding JSON output. %v", err)
+	}
+	if rep.Verdict == nil || rep.Verdict.Result != "fail" {
+		t.Errorf("Expected a fail verdict but got %v", rep.Verdict)
+	}
+	if len(rep.Networks) != 2 {
+		t.Errorf("Expected %v but got %v", 2, len(rep.Networks))
+	}
+}
@@ -0,0 +1,350 @@
+// Package dmarcrecord parses, serializes and lints DMARC DNS TXT records as
+// described in RFC 7489, including the np, t and psd tags from DMARCbis.
+package dmarcrecord
+
+import (
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Version is the only supported value of the v tag.
+const Version = "DMARC1"
+
+// Policy is a requested handling policy for the p, sp and np tags.
+type Policy string
+
+// Policies defined by RFC 7489.
+const (
+	PolicyNone       Policy = "none"
+	PolicyQuarantine Policy = "quarantine"
+	PolicyReject     Policy = "reject"
+)
+
+// Alignment is an identifier alignment mode for the adkim and aspf tags.
+type Alignment string
+
+// Alignment modes defined by RFC 7489.
+const (
+	AlignmentRelaxed Alignment = "r"
+	AlignmentStrict  Alignment = "s"
+)
+
+// Record is a parsed DMARC record. Optional tags that were not present hold
+// their zero value; use the accessor methods for values with defaults applied.
+type Record struct {
+	Policy            Policy    `json:"p"`
+	SubdomainPolicy   Policy    `json:"sp,omitempty"`
+	NonexistentPolicy Policy    `json:"np,omitempty"`
+	Percent           *int      `json:"pct,omitempty"`
+	AggregateURIs     []URI     `json:"rua,omitempty"`
+	FailureURIs       []URI     `json:"ruf,omitempty"`
+	DKIMAlignment     Alignment `json:"adkim,omitempty"`
+	SPFAlignment      Alignment `json:"aspf,omitempty"`
+	FailureOptions    string    `json:"fo,omitempty"`
+	ReportInterval    *int      `json:"ri,omitempty"`
+	ReportFormat      string    `json:"rf,omitempty"`
+	Testing           string    `json:"t,omitempty"`
+	PSD               string    `json:"psd,omitempty"`
+	Unknown           []Tag     `json:"unknown,omitempty"` // tags this package does not know, in order
+	Ignored           []Ignored `json:"ignored,omitempty"` // invalid tags left out, in order
+}
+
+// Ignored is a tag that was left out of a record because it is malformed,
+// repeated or has an invalid value. Receivers ignore such tags and use the
+// default, as RFC 7489 section 6.3 requires.
+type Ignored struct {
+	Tag
+	Reason string `json:"reason"`
+}
+
+// Tag is a single tag=value pair.
+type Tag struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// URI is a reporting URI from the rua or ruf tags with its optional size
+// limit, for example mailto:dmarc@example.com!10m.
+type URI struct {
+	Address string `json:"address"`
+	MaxSize string `json:"maxSize,omitempty"`
+}
+
+func (u URI) String() string {
+	if u.MaxSize == "" {
+		return u.Address
+	}
+	return u.Address + "!" + u.MaxSize
+}
+
+// Mailbox returns the email address of a mailto URI, or "" if the URI is not
+// a valid mailto URI.
+func (u URI) Mailbox() string {
+	parsed, err := url.Parse(u.Address)
+	if err != nil || !strings.EqualFold(parsed.Scheme, "mailto") {
+		return ""
+	}
+	a, err := mail.ParseAddress(parsed.Opaque)
+	if err != nil {
+		return ""
+	}
+	return a.Address
+}
+
+// ParseError describes why a record could not be parsed.
+type ParseError struct {
+	Tag string
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	if e.Tag == "" {
+		return "dmarc: " + e.Msg
+	}
+	return "dmarc: " + e.Tag + ": " + e.Msg
+}
+
+// Parse parses a DMARC TXT record. It only fails if the record does not
+// start with v=DMARC1 or lacks a valid p tag. Other malformed, repeated or
+// invalid tags are left out and recorded in Ignored, so the defaults apply
+// as they do for receivers; Lint reports them along with other problems that
+// do not prevent the record from being used.
+func Parse(txt string) (*Record, error) {
+	parts := strings.Split(txt, ";")
+	name, value, _ := strings.Cut(parts[0], "=")
+	if strings.TrimSpace(name) != "v" || strings.TrimSpace(value) != Version {
+		return nil, &ParseError{Tag: "v", Msg: "record must start with v=" + Version}
+	}
+
+	r := &Record{}
+	seen := map[string]bool{}
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if !ok {
+			r.ignore(name, value, fmt.Sprintf("malformed tag %q", part))
+			continue
+		}
+
+		if seen[name] {
+			if name == "p" {
+				return nil, &ParseError{Tag: name, Msg: "tag appears more than once"}
+			}
+			r.ignore(name, value, "tag appears more than once")
+			continue
+		}
+		seen[name] = true
+
+		if err := r.set(name, value); err != nil {
+			if name == "p" {
+				return nil, err
+			}
+			r.ignore(name, value, err.(*ParseError).Msg)
+		}
+	}
+
+	if !seen["p"] {
+		return nil, &ParseError{Tag: "p", Msg: "required tag is missing"}
+	}
+	return r, nil
+}
+
+func (r *Record) ignore(name, value, reason string) {
+	r.Ignored = append(r.Ignored, Ignored{Tag: Tag{Name: name, Value: value}, Reason: reason})
+}
+
+func (r *Record) set(name, value string) (err error) {
+	switch name {
+	case "v":
+		return &ParseError{Tag: name, Msg: "must be the first tag"}
+	case "p":
+		r.Policy, err = parsePolicy(name, value)
+	case "sp":
+		r.SubdomainPolicy, err = parsePolicy(name, value)
+	case "np":
+		r.NonexistentPolicy, err = parsePolicy(name, value)
+	case "pct":
+		r.Percent, err = parseInt(name, value, 0, 100)
+	case "ri":
+		r.ReportInterval, err = parseInt(name, value, 0, math.MaxInt32)
+	case "rua":
+		r.AggregateURIs = parseURIs(value)
+	case "ruf":
+		r.FailureURIs = parseURIs(value)
+	case "adkim":
+		r.DKIMAlignment, err = parseAlignment(name, value)
+	case "aspf":
+		r.SPFAlignment, err = parseAlignment(name, value)
+	case "fo":
+		for _, o := range strings.Split(value, ":") {
+			switch strings.TrimSpace(o) {
+			case "0", "1", "d", "s":
+			default:
+				return &ParseError{Tag: name, Msg: fmt.Sprintf("invalid failure option %q", o)}
+			}
+		}
+		r.FailureOptions = value
+	case "rf":
+		r.ReportFormat = value
+	case "t":
+		r.Testing, err = parseFlag(name, value, "y", "n")
+	case "psd":
+		r.PSD, err = parseFlag(name, value, "y", "n", "u")
+	default:
+		r.Unknown = append(r.Unknown, Tag{Name: name, Value: value})
+	}
+	return
+}
+
+func parsePolicy(name, value string) (Policy, error) {
+	p := Policy(strings.ToLower(value))
+	switch p {
+	case PolicyNone, PolicyQuarantine, PolicyReject:
+		return p, nil
+	}
+	return "", &ParseError{Tag: name, Msg: fmt.Sprintf("invalid policy %q", value)}
+}
+
+func parseAlignment(name, value string) (Alignment, error) {
+	a := Alignment(strings.ToLower(value))
+	switch a {
+	case AlignmentRelaxed, AlignmentStrict:
+		return a, nil
+	}
+	return "", &ParseError{Tag: name, Msg: fmt.Sprintf("invalid alignment %q", value)}
+}
+
+func parseInt(name, value string, min, max int) (*int, error) {
+	v, err := strconv.Atoi(value)
+	if err != nil || v < min || v > max {
+		return nil, &ParseError{Tag: name, Msg: fmt.Sprintf("invalid value %q", value)}
+	}
+	return &v, nil
+}
+
+func parseFlag(name, value string, allowed ...string) (string, error) {
+	v := strings.ToLower(value)
+	for _, a := range allowed {
+		if v == a {
+			return v, nil
+		}
+	}
+	return "", &ParseError{Tag: name, Msg: fmt.Sprintf("invalid value %q", value)}
+}
+
+// parseURIs splits a comma separated URI list. Invalid URIs are kept so Lint
+// can report them.
+func parseURIs(value string) (uris []URI) {
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		u := URI{Address: s}
+		if i := strings.LastIndex(s, "!"); i >= 0 {
+			u.Address, u.MaxSize = s[:i], s[i+1:]
+		}
+		uris = append(uris, u)
+	}
+	return
+}
+
+// EffectiveSubdomainPolicy returns sp, or p if sp is not set.
+func (r *Record) EffectiveSubdomainPolicy() Policy {
+	if r.SubdomainPolicy != "" {
+		return r.SubdomainPolicy
+	}
+	return r.Policy
+}
+
+// EffectivePercent returns pct, or 100 if pct is not set.
+func (r *Record) EffectivePercent() int {
+	if r.Percent != nil {
+		return *r.Percent
+	}
+	return 100
+}
+
+// EffectiveDKIMAlignment returns adkim, or relaxed if adkim is not set.
+func (r *Record) EffectiveDKIMAlignment() Alignment {
+	if r.DKIMAlignment != "" {
+		return r.DKIMAlignment
+	}
+	return AlignmentRelaxed
+}
+
+// EffectiveSPFAlignment returns aspf, or relaxed if aspf is not set.
+func (r *Record) EffectiveSPFAlignment() Alignment {
+	if r.SPFAlignment != "" {
+		return r.SPFAlignment
+	}
+	return AlignmentRelaxed
+}
+
+// EffectiveFailureOptions returns fo, or 0 if fo is not set.
+func (r *Record) EffectiveFailureOptions() string {
+	if r.FailureOptions != "" {
+		return r.FailureOptions
+	}
+	return "0"
+}
+
+// EffectiveReportInterval returns ri, or 86400 seconds if ri is not set.
+func (r *Record) EffectiveReportInterval() int {
+	if r.ReportInterval != nil {
+		return *r.ReportInterval
+	}
+	return 86400
+}
+
+// String serializes the record with its tags in a canonical order, followed
+// by any unknown tags.
+func (r *Record) String() string {
+	tags := []string{"v=" + Version}
+	add := func(name, value string) {
+		if value != "" {
+			tags = append(tags, name+"="+value)
+		}
+	}
+
+	add("p", string(r.Policy))
+	add("sp", string(r.SubdomainPolicy))
+	add("np", string(r.NonexistentPolicy))
+	if r.Percent != nil {
+		add("pct", strconv.Itoa(*r.Percent))
+	}
+	add("rua", joinURIs(r.AggregateURIs))
+	add("ruf", joinURIs(r.FailureURIs))
+	add("adkim", string(r.DKIMAlignment))
+	add("aspf", string(r.SPFAlignment))
+	add("fo", r.FailureOptions)
+	if r.ReportInterval != nil {
+		add("ri", strconv.Itoa(*r.ReportInterval))
+	}
+	add("rf", r.ReportFormat)
+	add("t", r.Testing)
+	add("psd", r.PSD)
+	for _, t := range r.Unknown {
+		add(t.Name, t.Value)
+	}
+
+	return strings.Join(tags, "; ")
+}
+
+func joinURIs(uris []URI) string {
+	s := make([]string, len(uris))
+	for i, u := range uris {
+		s[i] = u.String()
+	}
+	return strings.Join(s, ",")
+}
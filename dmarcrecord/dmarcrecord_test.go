@@ -0,0 +1,167 @@
+package dmarcrecord
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	r, err := Parse("v=DMARC1; p=reject; sp=quarantine; np=reject; pct=50; rua=mailto:dmarc@example.com!10m,mailto:dmarc@example.net; " +
+		"ruf=mailto:ruf@example.com; adkim=s; aspf=r; fo=1:d; ri=3600; rf=afrf; t=n; psd=u;")
+	if err != nil {
+		t.Fatalf("Error parsing record. %v", err)
+	}
+
+	if r.Policy != PolicyReject {
+		t.Errorf("Expected %v but got %v", PolicyReject, r.Policy)
+	}
+	if r.SubdomainPolicy != PolicyQuarantine {
+		t.Errorf("Expected %v but got %v", PolicyQuarantine, r.SubdomainPolicy)
+	}
+	if r.NonexistentPolicy != PolicyReject {
+		t.Errorf("Expected %v but got %v", PolicyReject, r.NonexistentPolicy)
+	}
+	if r.EffectivePercent() != 50 {
+		t.Errorf("Expected %v but got %v", 50, r.EffectivePercent())
+	}
+	if len(r.AggregateURIs) != 2 || r.AggregateURIs[0].Address != "mailto:dmarc@example.com" || r.AggregateURIs[0].MaxSize != "10m" {
+		t.Errorf("Unexpected rua %v", r.AggregateURIs)
+	}
+	if r.AggregateURIs[1].Mailbox() != "dmarc@example.net" {
+		t.Errorf("Expected %v but got %v", "dmarc@example.net", r.AggregateURIs[1].Mailbox())
+	}
+	if r.DKIMAlignment != AlignmentStrict || r.SPFAlignment != AlignmentRelaxed {
+		t.Errorf("Unexpected alignment %v %v", r.DKIMAlignment, r.SPFAlignment)
+	}
+	if r.FailureOptions != "1:d" || r.EffectiveReportInterval() != 3600 {
+		t.Errorf("Unexpected fo %v or ri %v", r.FailureOptions, r.EffectiveReportInterval())
+	}
+	if r.Testing != "n" || r.PSD != "u" {
+		t.Errorf("Unexpected t %v or psd %v", r.Testing, r.PSD)
+	}
+	if issues := r.Lint(); len(issues) != 0 {
+		t.Errorf("Expected no issues but got %v", issues)
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	r, err := Parse("v=DMARC1;p=none")
+	if err != nil {
+		t.Fatalf("Error parsing record. %v", err)
+	}
+
+	if r.EffectiveSubdomainPolicy() != PolicyNone {
+		t.Errorf("Expected %v but got %v", PolicyNone, r.EffectiveSubdomainPolicy())
+	}
+	if r.EffectivePercent() != 100 {
+		t.Errorf("Expected %v but got %v", 100, r.EffectivePercent())
+	}
+	if r.EffectiveDKIMAlignment() != AlignmentRelaxed || r.EffectiveSPFAlignment() != AlignmentRelaxed {
+		t.Errorf("Expected relaxed alignment")
+	}
+	if r.EffectiveFailureOptions() != "0" || r.EffectiveReportInterval() != 86400 {
+		t.Errorf("Unexpected defaults %v %v", r.EffectiveFailureOptions(), r.EffectiveReportInterval())
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"":                             "v",
+		"p=none; v=DMARC1":             "v",
+		"v=DMARC2; p=none":             "v",
+		"v=DMARC1; rua=mailto:a@b.com": "p",
+		"v=DMARC1; p=block":            "p",
+		"v=DMARC1; p=none; p=reject":   "p",
+	}
+
+	for txt, tag := range tests {
+		_, err := Parse(txt)
+		pe, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("Expected ParseError for %q but got %v", txt, err)
+			continue
+		}
+		if pe.Tag != tag {
+			t.Errorf("Expected error for tag %q in %q but got %v", tag, txt, pe)
+		}
+	}
+}
+
+func TestParseIgnoresInvalidTags(t *testing.T) {
+	tests := map[string]string{
+		"v=DMARC1; p=reject; pct=101":       "pct",
+		"v=DMARC1; p=reject; adkim=x":       "adkim",
+		"v=DMARC1; p=reject; fo=2":          "fo",
+		"v=DMARC1; p=reject; psd=x":         "psd",
+		"v=DMARC1; p=reject; v=DMARC1":      "v",
+		"v=DMARC1; p=reject; rua":           "rua",
+		"v=DMARC1; p=reject; ri=not-number": "ri",
+		"v=DMARC1; p=reject; sp=none; sp=x": "sp",
+	}
+
+	for txt, tag := range tests {
+		r, err := Parse(txt)
+		if err != nil {
+			t.Errorf("Expected %q to parse but got %v", txt, err)
+			continue
+		}
+		if len(r.Ignored) != 1 || r.Ignored[0].Name != tag {
+			t.Errorf("Expected %q to ignore tag %v but got %v", txt, tag, r.Ignored)
+		}
+		if r.Policy != PolicyReject || r.EffectivePercent() != 100 || r.EffectiveDKIMAlignment() != AlignmentRelaxed {
+			t.Errorf("Expected the defaults for %q but got %v", txt, r)
+		}
+	}
+
+	r, _ := Parse("v=DMARC1; p=reject; pct=101; rua=mailto:a@example.com")
+	issues := r.Lint()
+	expected := `warning: pct: invalid value "101", receivers will ignore it and use the default`
+	if len(issues) != 1 || issues[0].String() != expected {
+		t.Errorf("Expected %v but got %v", expected, issues)
+	}
+	expected = "v=DMARC1; p=reject; rua=mailto:a@example.com"
+	if r.String() != expected {
+		t.Errorf("Expected %v but got %v", expected, r.String())
+	}
+}
+
+func TestLint(t *testing.T) {
+	r, err := Parse("v=DMARC1; p=none; pct=20; ruf=https://example.com/ruf,mailto:bad!10x; fo=1; foo=bar")
+	if err != nil {
+		t.Fatalf("Error parsing record. %v", err)
+	}
+
+	var messages []string
+	for _, i := range r.Lint() {
+		messages = append(messages, i.String())
+	}
+
+	expected := []string{
+		"warning: foo: unknown tag, receivers will ignore it",
+		"warning: rua: no aggregate report destination, no reports will be sent",
+		"warning: ruf: https://example.com/ruf uses the https scheme, most receivers only support mailto",
+		"error: ruf: invalid size limit \"10x\" in mailto:bad!10x",
+		"error: ruf: invalid email address in mailto:bad",
+		"warning: pct: has no effect with p=none",
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected \n%v\n but got: \n%v\n", strings.Join(expected, "\n"), strings.Join(messages, "\n"))
+	}
+}
+
+func TestString(t *testing.T) {
+	txt := "v=DMARC1; p=quarantine; pct=25; rua=mailto:a@example.com!10m,mailto:b@example.com; adkim=s; fo=1; x=y"
+	r, err := Parse("v=DMARC1;fo=1; adkim=s;  rua=mailto:a@example.com!10m, mailto:b@example.com ;p=quarantine;pct=25;x=y")
+	if err != nil {
+		t.Fatalf("Error parsing record. %v", err)
+	}
+
+	if r.String() != txt {
+		t.Errorf("Expected \n%v\n but got: \n%v\n", txt, r.String())
+	}
+
+	r2, err := Parse(r.String())
+	if err != nil || r2.String() != txt {
+		t.Errorf("Record did not round trip. %v %v", r2, err)
+	}
+}
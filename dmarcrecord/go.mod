@@ -0,0 +1,3 @@
+module github.com/ericdaugherty/dmarc/dmarcrecord
+
+go 1.18
@@ -0,0 +1,109 @@
+package dmarcrecord
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Severity classifies an Issue.
+type Severity int
+
+// Issue severities.
+const (
+	Warning Severity = iota
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
+
+// Issue is a problem found by Lint.
+type Issue struct {
+	Severity Severity
+	Tag      string
+	Message  string
+}
+
+func (i Issue) String() string {
+	if i.Tag == "" {
+		return fmt.Sprintf("%v: %v", i.Severity, i.Message)
+	}
+	return fmt.Sprintf("%v: %v: %v", i.Severity, i.Tag, i.Message)
+}
+
+// Lint returns problems with a parsed record that receivers tolerate but
+// that are likely mistakes.
+func (r *Record) Lint() (issues []Issue) {
+	add := func(s Severity, tag, format string, a ...interface{}) {
+		issues = append(issues, Issue{Severity: s, Tag: tag, Message: fmt.Sprintf(format, a...)})
+	}
+
+	for _, t := range r.Unknown {
+		add(Warning, t.Name, "unknown tag, receivers will ignore it")
+	}
+	for _, t := range r.Ignored {
+		add(Warning, t.Name, "%v, receivers will ignore it and use the default", t.Reason)
+	}
+
+	if len(r.AggregateURIs) == 0 {
+		add(Warning, "rua", "no aggregate report destination, no reports will be sent")
+	}
+	for _, u := range r.AggregateURIs {
+		lintURI(u, "rua", add)
+	}
+	for _, u := range r.FailureURIs {
+		lintURI(u, "ruf", add)
+	}
+
+	if r.Percent != nil && r.Policy == PolicyNone {
+		add(Warning, "pct", "has no effect with p=none")
+	}
+	if r.FailureOptions != "" && len(r.FailureURIs) == 0 {
+		add(Warning, "fo", "has no effect without a ruf destination")
+	}
+	if r.ReportFormat != "" && !strings.EqualFold(r.ReportFormat, "afrf") {
+		add(Warning, "rf", "unknown report format %q, only afrf is defined", r.ReportFormat)
+	}
+
+	return
+}
+
+func lintURI(u URI, tag string, add func(Severity, string, string, ...interface{})) {
+	if u.MaxSize != "" && !validSize(u.MaxSize) {
+		add(Error, tag, "invalid size limit %q in %v", u.MaxSize, u)
+	}
+
+	parsed, err := url.Parse(u.Address)
+	if err != nil || parsed.Scheme == "" {
+		add(Error, tag, "invalid URI %q", u.Address)
+		return
+	}
+	if !strings.EqualFold(parsed.Scheme, "mailto") {
+		add(Warning, tag, "%v uses the %v scheme, most receivers only support mailto", u.Address, parsed.Scheme)
+		return
+	}
+	if u.Mailbox() == "" {
+		add(Error, tag, "invalid email address in %v", u.Address)
+	}
+}
+
+func validSize(s string) bool {
+	s = strings.ToLower(s)
+	if n := len(s); n > 0 && strings.ContainsRune("kmgt", rune(s[n-1])) {
+		s = s[:n-1]
+	}
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package dnscheck
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ericdaugherty/dmarc/dmarcrecord"
+	"golang.org/x/net/publicsuffix"
+)
+
+// AuthorizationResult is the outcome of checking that an external report
+// destination has agreed to receive reports for a domain (RFC 7489 §7.1).
+type AuthorizationResult struct {
+	Domain      string
+	Address     string
+	Destination string
+	Name        string
+	Authorized  bool
+	Issues
+}
+
+// CheckReportAuthorization checks every rua and ruf address of record that is
+// outside the organizational domain of domain. Receivers only send reports to
+// such an address if the destination publishes a record at
+// <domain>._report._dmarc.<destination>.
+func (c *Checker) CheckReportAuthorization(ctx context.Context, domain string, record *dmarcrecord.Record) (results []AuthorizationResult) {
+	uris := append([]dmarcrecord.URI{}, record.AggregateURIs...)
+	uris = append(uris, record.FailureURIs...)
+
+	seen := map[string]bool{}
+	for _, u := range uris {
+		addr := u.Mailbox()
+		if addr == "" {
+			continue
+		}
+		dest := strings.ToLower(addr[strings.LastIndex(addr, "@")+1:])
+		if seen[dest] || !IsExternal(domain, dest) {
+			continue
+		}
+		seen[dest] = true
+
+		res := AuthorizationResult{
+			Domain:      domain,
+			Address:     addr,
+			Destination: dest,
+			Name:        domain + "._report._dmarc." + dest,
+		}
+		records, err := c.lookupTXT(ctx, res.Name, "v=DMARC1")
+		switch {
+		case err != nil:
+			res.errorf("lookup of %v failed: %v", res.Name, err)
+		case len(records) == 0:
+			res.errorf("%v does not publish %v, receivers will not send reports for %v to %v", dest, res.Name, domain, addr)
+		default:
+			res.Authorized = true
+		}
+		results = append(results, res)
+	}
+	return
+}
+
+// IsExternal reports whether dest is outside the organizational domain of
+// domain.
+func IsExternal(domain, dest string) bool {
+	return !strings.EqualFold(OrganizationalDomain(domain), OrganizationalDomain(dest))
+}
+
+// OrganizationalDomain returns the registered domain for name using the
+// public suffix list, or name itself if it has none.
+func OrganizationalDomain(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	org, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil {
+		return name
+	}
+	return org
+}
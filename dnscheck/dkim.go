@@ -0,0 +1,137 @@
+package dnscheck
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+)
+
+// Minimum RSA key sizes. RFC 8301 requires verifiers to reject keys shorter
+// than 1024 bits and recommends signers use at least 2048.
+const (
+	dkimMinKeyBits         = 1024
+	dkimRecommendedKeyBits = 2048
+)
+
+// DKIMResult is the outcome of checking one DKIM selector record.
+type DKIMResult struct {
+	Domain   string
+	Selector string
+	Record   string
+	KeyType  string
+	KeyBits  int
+	Issues
+}
+
+// CheckDKIM fetches and validates the key record for selector at domain.
+func (c *Checker) CheckDKIM(ctx context.Context, domain, selector string) (res DKIMResult) {
+	res.Domain = domain
+	res.Selector = selector
+	name := selector + "._domainkey." + domain
+
+	txts, err := c.resolver.LookupTXT(ctx, name)
+	if err != nil {
+		if isNotFound(err) {
+			res.errorf("no DKIM key found at %v", name)
+		} else {
+			res.errorf("lookup of %v failed: %v", name, err)
+		}
+		return
+	}
+	switch len(txts) {
+	case 0:
+		res.errorf("no DKIM key found at %v", name)
+		return
+	case 1:
+	default:
+		res.errorf("%v TXT records found at %v", len(txts), name)
+		return
+	}
+
+	res.Record = txts[0]
+	tags := parseTags(res.Record)
+
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		res.errorf("v tag must be DKIM1, found %q", v)
+	}
+	if strings.Contains(tags["t"], "y") {
+		res.warnf("key is in testing mode (t=y)")
+	}
+
+	res.KeyType = strings.ToLower(tags["k"])
+	if res.KeyType == "" {
+		res.KeyType = "rsa"
+	}
+
+	p, ok := tags["p"]
+	if !ok {
+		res.errorf("required p tag is missing")
+		return
+	}
+	p = strings.Join(strings.Fields(p), "")
+	if p == "" {
+		res.warnf("key has been revoked (empty p tag)")
+		return
+	}
+
+	der, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		res.errorf("p tag is not valid base64: %v", err)
+		return
+	}
+
+	switch res.KeyType {
+	case "rsa":
+		res.KeyBits, err = rsaKeyBits(der)
+		if err != nil {
+			res.errorf("unable to parse RSA key: %v", err)
+			return
+		}
+		if res.KeyBits < dkimMinKeyBits {
+			res.errorf("RSA key is %v bits, receivers reject keys under %v bits", res.KeyBits, dkimMinKeyBits)
+		} else if res.KeyBits < dkimRecommendedKeyBits {
+			res.warnf("RSA key is %v bits, %v bits is recommended", res.KeyBits, dkimRecommendedKeyBits)
+		}
+	case "ed25519":
+		res.KeyBits = len(der) * 8
+		if len(der) != 32 {
+			res.errorf("ed25519 key is %v bytes, expected 32", len(der))
+		}
+	default:
+		res.errorf("unknown key type %q", res.KeyType)
+	}
+
+	return
+}
+
+// rsaKeyBits returns the modulus size of a SubjectPublicKeyInfo or PKCS #1
+// encoded RSA public key.
+func rsaKeyBits(der []byte) (int, error) {
+	if pub, err := x509.ParsePKIXPublicKey(der); err == nil {
+		if k, ok := pub.(*rsa.PublicKey); ok {
+			return k.N.BitLen(), nil
+		}
+	}
+
+	k, err := x509.ParsePKCS1PublicKey(der)
+	if err != nil {
+		return 0, err
+	}
+	return k.N.BitLen(), nil
+}
+
+// parseTags splits a DKIM tag=value; list into a map keyed by lower case tag.
+func parseTags(record string) map[string]string {
+	tags := map[string]string{}
+	for _, part := range strings.Split(record, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(part, "=")
+		tags[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+	}
+	return tags
+}
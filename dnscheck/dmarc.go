@@ -0,0 +1,94 @@
+package dnscheck
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ericdaugherty/dmarc/dmarcrecord"
+)
+
+// DMARCResult is the outcome of checking a domain's _dmarc record.
+type DMARCResult struct {
+	Name   string
+	Record string
+	Parsed *dmarcrecord.Record
+	Issues
+}
+
+// CheckDMARC fetches and validates the DMARC record for domain.
+func (c *Checker) CheckDMARC(ctx context.Context, domain string) (res DMARCResult) {
+	res.Name = "_dmarc." + domain
+
+	records, err := c.lookupTXT(ctx, res.Name, "v=DMARC1")
+	if err != nil {
+		res.errorf("lookup of %v failed: %v", res.Name, err)
+		return
+	}
+	switch len(records) {
+	case 0:
+		res.errorf("no DMARC record found at %v", res.Name)
+		return
+	case 1:
+	default:
+		res.errorf("%v DMARC records found at %v, receivers will ignore all of them", len(records), res.Name)
+		return
+	}
+
+	res.Record = records[0]
+	res.Parsed, err = dmarcrecord.Parse(res.Record)
+	if err != nil {
+		res.errorf("%v", err)
+		return
+	}
+
+	for _, i := range res.Parsed.Lint() {
+		if i.Severity == dmarcrecord.Error {
+			res.errorf("%v: %v", i.Tag, i.Message)
+		} else {
+			res.warnf("%v: %v", i.Tag, i.Message)
+		}
+	}
+
+	return
+}
+
+// PolicyDiff is a tag whose published value in a report differs from the
+// live DMARC record.
+type PolicyDiff struct {
+	Domain    string
+	Tag       string
+	Published string
+	Live      string
+}
+
+func (d PolicyDiff) String() string {
+	return fmt.Sprintf("DMARC policy for %v: reporter saw %v=%v but the live record has %v=%v", d.Domain, d.Tag, d.Published, d.Tag, d.Live)
+}
+
+// ComparePolicy compares a reported policy_published with the live DMARC
+// record. Tags the reporter left empty are not compared.
+func ComparePolicy(published Policy, live *dmarcrecord.Record) (diffs []PolicyDiff) {
+	values := []struct {
+		tag       string
+		published string
+		live      string
+	}{
+		{"p", published.P, string(live.Policy)},
+		{"sp", published.Sp, string(live.EffectiveSubdomainPolicy())},
+		{"adkim", published.Adkim, string(live.EffectiveDKIMAlignment())},
+		{"aspf", published.Aspf, string(live.EffectiveSPFAlignment())},
+		{"pct", published.Pct, strconv.Itoa(live.EffectivePercent())},
+		{"fo", published.Fo, live.EffectiveFailureOptions()},
+	}
+
+	for _, v := range values {
+		pub := strings.ToLower(strings.TrimSpace(v.published))
+		if pub == "" || pub == v.live {
+			continue
+		}
+		diffs = append(diffs, PolicyDiff{Domain: published.Domain, Tag: v.tag, Published: pub, Live: v.live})
+	}
+	return
+}
@@ -0,0 +1,183 @@
+// Package dnscheck fetches and validates the DMARC, SPF and DKIM records that
+// DMARC reports refer to.
+package dnscheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Resolver performs the DNS lookups used by the checks. *net.Resolver
+// satisfies it, so tests can point one at a local fake server.
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// Checker runs DNS checks using a Resolver.
+type Checker struct {
+	resolver Resolver
+}
+
+// New returns a Checker using r, or net.DefaultResolver if r is nil.
+func New(r Resolver) *Checker {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	return &Checker{resolver: r}
+}
+
+// Input describes what the reports told us about a domain.
+type Input struct {
+	Domain    string
+	Selectors []Selector
+	Published *Policy
+}
+
+// Selector identifies a DKIM key seen in a report's auth_results.
+type Selector struct {
+	Domain   string
+	Selector string
+}
+
+// Policy is the DMARC policy a reporter saw, as echoed in policy_published.
+type Policy struct {
+	Domain string
+	Adkim  string
+	Aspf   string
+	P      string
+	Sp     string
+	Pct    string
+	Fo     string
+}
+
+// Issues collects the problems found with a single record.
+type Issues struct {
+	Errors   []string
+	Warnings []string
+}
+
+func (i *Issues) errorf(format string, a ...interface{}) {
+	i.Errors = append(i.Errors, fmt.Sprintf(format, a...))
+}
+
+func (i *Issues) warnf(format string, a ...interface{}) {
+	i.Warnings = append(i.Warnings, fmt.Sprintf(format, a...))
+}
+
+// HasIssues reports whether any errors or warnings were recorded.
+func (i Issues) HasIssues() bool {
+	return len(i.Errors) > 0 || len(i.Warnings) > 0
+}
+
+// Result holds the outcome of checking one domain.
+type Result struct {
+	Domain         string
+	DMARC          DMARCResult
+	Authorizations []AuthorizationResult
+	SPF            SPFResult
+	DKIM           []DKIMResult
+	PolicyDiffs    []PolicyDiff
+}
+
+// HasIssues reports whether any check found a problem.
+func (r Result) HasIssues() bool {
+	if r.DMARC.HasIssues() || r.SPF.HasIssues() || len(r.PolicyDiffs) > 0 {
+		return true
+	}
+	for _, a := range r.Authorizations {
+		if a.HasIssues() {
+			return true
+		}
+	}
+	for _, d := range r.DKIM {
+		if d.HasIssues() {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary returns one human readable line per problem found.
+func (r Result) Summary() (lines []string) {
+	add := func(name string, i Issues) {
+		for _, e := range i.Errors {
+			lines = append(lines, fmt.Sprintf("%v error: %v", name, e))
+		}
+		for _, w := range i.Warnings {
+			lines = append(lines, fmt.Sprintf("%v warning: %v", name, w))
+		}
+	}
+
+	add("DMARC", r.DMARC.Issues)
+	for _, a := range r.Authorizations {
+		add("Report authorization", a.Issues)
+	}
+	add("SPF", r.SPF.Issues)
+	for _, d := range r.DKIM {
+		add(fmt.Sprintf("DKIM %v._domainkey.%v", d.Selector, d.Domain), d.Issues)
+	}
+	for _, d := range r.PolicyDiffs {
+		lines = append(lines, d.String())
+	}
+	return
+}
+
+// Check runs every check for the input domain.
+func (c *Checker) Check(ctx context.Context, in Input) (res Result) {
+	res.Domain = in.Domain
+	res.DMARC = c.CheckDMARC(ctx, in.Domain)
+	if res.DMARC.Parsed != nil {
+		res.Authorizations = c.CheckReportAuthorization(ctx, in.Domain, res.DMARC.Parsed)
+	}
+	res.SPF = c.CheckSPF(ctx, in.Domain)
+
+	seen := map[Selector]bool{}
+	for _, s := range in.Selectors {
+		s.Domain = strings.ToLower(s.Domain)
+		if s.Selector == "" || seen[s] {
+			continue
+		}
+		seen[s] = true
+		res.DKIM = append(res.DKIM, c.CheckDKIM(ctx, s.Domain, s.Selector))
+	}
+
+	if in.Published != nil && res.DMARC.Parsed != nil {
+		res.PolicyDiffs = ComparePolicy(*in.Published, res.DMARC.Parsed)
+	}
+
+	return
+}
+
+// lookupTXT returns the TXT records at name that start with prefix, matched
+// case-insensitively. A missing name is not an error.
+func (c *Checker) lookupTXT(ctx context.Context, name, prefix string) (records []string, err error) {
+	txts, err := c.resolver.LookupTXT(ctx, name)
+	if err != nil {
+		if isNotFound(err) {
+			err = nil
+		}
+		return
+	}
+
+	for _, txt := range txts {
+		if hasPrefixFold(txt, prefix) {
+			records = append(records, txt)
+		}
+	}
+	return
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
@@ -0,0 +1,378 @@
+package dnscheck
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ericdaugherty/dmarc/dnscheck/dnstest"
+)
+
+func newTestChecker(t *testing.T, zone dnstest.Zone) *Checker {
+	s, err := dnstest.NewServer(zone)
+	if err != nil {
+		t.Fatalf("Error starting DNS server. %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return New(s.Resolver())
+}
+
+func TestCheckDMARC(t *testing.T) {
+	c := newTestChecker(t, dnstest.Zone{
+		"_dmarc.example.com": {TXT: []string{"v=DMARC1; p=quarantine; pct=50; rua=mailto:dmarc@example.com", "unrelated"}},
+		"_dmarc.norua.com":   {TXT: []string{"v=DMARC1; p=none"}},
+		"_dmarc.bad.com":     {TXT: []string{"v=DMARC1; p=block; adkim=x"}},
+		"_dmarc.double.com":  {TXT: []string{"v=DMARC1; p=none", "v=DMARC1; p=reject"}},
+	})
+	ctx := context.Background()
+
+	res := c.CheckDMARC(ctx, "example.com")
+	if res.HasIssues() {
+		t.Errorf("Expected no issues but got %v", res.Issues)
+	}
+	if res.Parsed.EffectivePercent() != 50 {
+		t.Errorf("Expected %v but got %v", 50, res.Parsed.EffectivePercent())
+	}
+
+	res = c.CheckDMARC(ctx, "norua.com")
+	if len(res.Errors) != 0 || len(res.Warnings) != 1 {
+		t.Errorf("Expected a single warning but got %v", res.Issues)
+	}
+
+	res = c.CheckDMARC(ctx, "bad.com")
+	if len(res.Errors) != 1 || res.Parsed != nil {
+		t.Errorf("Expected a single error but got %v", res.Errors)
+	}
+
+	res = c.CheckDMARC(ctx, "double.com")
+	if len(res.Errors) != 1 || res.Record != "" {
+		t.Errorf("Expected a single error but got %v", res.Errors)
+	}
+
+	res = c.CheckDMARC(ctx, "missing.com")
+	if len(res.Errors) != 1 {
+		t.Errorf("Expected a single error but got %v", res.Errors)
+	}
+}
+
+func TestCheckSPFLookupLimit(t *testing.T) {
+	zone := dnstest.Zone{
+		"example.com":      {TXT: []string{"v=spf1 ip4:192.0.2.0/24 include:_spf.example.net mx a:mail.example.com ~all"}, MX: []dnstest.MX{{Host: "mail.example.com", Pref: 10}}},
+		"mail.example.com": {IP: []string{"192.0.2.25"}},
+		"_spf.example.net": {TXT: []string{"v=spf1 include:a.example.net include:b.example.net -all"}},
+		"a.example.net":    {TXT: []string{"v=spf1 ip4:198.51.100.0/24 -all"}},
+		"b.example.net":    {TXT: []string{"v=spf1 ip6:2001:db8::/32 -all"}},
+		"big.com":          {TXT: []string{"v=spf1 include:_spf.example.net include:a.example.net include:b.example.net a mx ptr exists:%{i}.x.big.com a:mail.example.com mx:example.com -all"}},
+	}
+	c := newTestChecker(t, zone)
+	ctx := context.Background()
+
+	res := c.CheckSPF(ctx, "example.com")
+	if len(res.Errors) != 0 {
+		t.Errorf("Expected no errors but got %v", res.Errors)
+	}
+	expected := 5
+	if res.Lookups != expected {
+		t.Errorf("Expected %v but got %v", expected, res.Lookups)
+	}
+
+	res = c.CheckSPF(ctx, "big.com")
+	expected = 11
+	if res.Lookups != expected {
+		t.Errorf("Expected %v but got %v", expected, res.Lookups)
+	}
+	if len(res.Errors) != 1 || !strings.Contains(res.Errors[0], "limit of 10") {
+		t.Errorf("Expected lookup limit error but got %v", res.Errors)
+	}
+	if len(res.Warnings) != 1 {
+		t.Errorf("Expected ptr warning but got %v", res.Warnings)
+	}
+}
+
+func TestCheckSPFVoidLookups(t *testing.T) {
+	c := newTestChecker(t, dnstest.Zone{
+		"void.com": {TXT: []string{"v=spf1 a:gone1.void.com a:gone2.void.com mx:gone3.void.com -all"}},
+		"loop.com": {TXT: []string{"v=spf1 include:loop.com -all"}},
+	})
+	ctx := context.Background()
+
+	res := c.CheckSPF(ctx, "void.com")
+	if res.VoidLookups != 3 {
+		t.Errorf("Expected %v but got %v", 3, res.VoidLookups)
+	}
+	if len(res.Errors) != 1 || !strings.Contains(res.Errors[0], "void lookups") {
+		t.Errorf("Expected void lookup error but got %v", res.Errors)
+	}
+
+	res = c.CheckSPF(ctx, "loop.com")
+	if len(res.Errors) != 1 || !strings.Contains(res.Errors[0], "includes itself") {
+		t.Errorf("Expected loop error but got %v", res.Errors)
+	}
+
+	res = c.CheckSPF(ctx, "missing.com")
+	if len(res.Errors) != 0 || len(res.Warnings) != 1 {
+		t.Errorf("Expected a single warning but got %v", res.Issues)
+	}
+}
+
+func TestCheckDKIMKeyLength(t *testing.T) {
+	c := newTestChecker(t, dnstest.Zone{
+		"s1024._domainkey.example.com": {TXT: []string{"v=DKIM1; k=rsa; p=" + testRSAKey(t, 1024)}},
+		"s2048._domainkey.example.com": {TXT: []string{"v=DKIM1; k=rsa; p=" + testRSAKey(t, 2048)}},
+		"s512._domainkey.example.com":  {TXT: []string{"v=DKIM1; p=" + testRSAKey(t, 512)}},
+		"gone._domainkey.example.com":  {TXT: []string{"v=DKIM1; p="}},
+	})
+	ctx := context.Background()
+
+	res := c.CheckDKIM(ctx, "example.com", "s2048")
+	if res.HasIssues() || res.KeyBits != 2048 {
+		t.Errorf("Expected clean 2048 bit key but got %v bits, %v", res.KeyBits, res.Issues)
+	}
+
+	res = c.CheckDKIM(ctx, "example.com", "s1024")
+	if len(res.Errors) != 0 || len(res.Warnings) != 1 || res.KeyBits != 1024 {
+		t.Errorf("Expected a warning for 1024 bit key but got %v bits, %v", res.KeyBits, res.Issues)
+	}
+
+	res = c.CheckDKIM(ctx, "example.com", "s512")
+	if len(res.Errors) != 1 {
+		t.Errorf("Expected an error for 512 bit key but got %v", res.Issues)
+	}
+
+	res = c.CheckDKIM(ctx, "example.com", "gone")
+	if len(res.Warnings) != 1 || !strings.Contains(res.Warnings[0], "revoked") {
+		t.Errorf("Expected revoked warning but got %v", res.Issues)
+	}
+
+	res = c.CheckDKIM(ctx, "example.com", "missing")
+	if len(res.Errors) != 1 {
+		t.Errorf("Expected an error for missing key but got %v", res.Issues)
+	}
+}
+
+func TestCheckPolicyDiffs(t *testing.T) {
+	c := newTestChecker(t, dnstest.Zone{
+		"_dmarc.example.com": {TXT: []string{"v=DMARC1; p=reject; rua=mailto:dmarc@example.com"}},
+		"example.com":        {TXT: []string{"v=spf1 -all"}},
+	})
+
+	res := c.Check(context.Background(), Input{
+		Domain:    "example.com",
+		Published: &Policy{Domain: "example.com", P: "none", Sp: "reject", Adkim: "r", Pct: "100"},
+	})
+
+	if len(res.PolicyDiffs) != 1 {
+		t.Fatalf("Expected %v but got %v", 1, res.PolicyDiffs)
+	}
+	d := res.PolicyDiffs[0]
+	if d.Tag != "p" || d.Published != "none" || d.Live != "reject" {
+		t.Errorf("Unexpected policy diff %v", d)
+	}
+	if !res.HasIssues() || len(res.Summary()) != 1 {
+		t.Errorf("Expected a single summary line but got %v", res.Summary())
+	}
+}
+
+// testRSAKey returns a base64 public key with a modulus of the given size.
+// The modulus is random rather than generated so undersized keys can be built.
+func testRSAKey(t *testing.T, bits int) string {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), uint(bits)))
+	if err != nil {
+		t.Fatalf("Error generating key. %v", err)
+	}
+	n.SetBit(n, bits-1, 1).SetBit(n, 0, 1)
+
+	der, err := x509.MarshalPKIXPublicKey(&rsa.PublicKey{N: n, E: 65537})
+	if err != nil {
+		t.Fatalf("Error marshaling key. %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+func TestCheckReportAuthorization(t *testing.T) {
+	c := newTestChecker(t, dnstest.Zone{
+		"_dmarc.example.com":                           {TXT: []string{"v=DMARC1; p=none; rua=mailto:dmarc@example.com,mailto:reports@dmarc.example.net,mailto:other@example.org; ruf=mailto:ruf@sub.example.com"}},
+		"example.com._report._dmarc.dmarc.example.net": {TXT: []string{"v=DMARC1"}},
+	})
+
+	res := c.Check(context.Background(), Input{Domain: "example.com"})
+	if len(res.Authorizations) != 2 {
+		t.Fatalf("Expected %v but got %v", 2, res.Authorizations)
+	}
+
+	a := res.Authorizations[0]
+	if !a.Authorized || a.Destination != "dmarc.example.net" || a.HasIssues() {
+		t.Errorf("Expected dmarc.example.net to be authorized but got %v", a)
+	}
+
+	a = res.Authorizations[1]
+	if a.Authorized || a.Name != "example.com._report._dmarc.example.org" || len(a.Errors) != 1 {
+		t.Errorf("Expected example.org to be unauthorized but got %v", a)
+	}
+	if !res.HasIssues() {
+		t.Errorf("Expected issues for unauthorized destination")
+	}
+}
+
+func TestOrganizationalDomain(t *testing.T) {
+	tests := map[string]string{
+		"example.com":          "example.com",
+		"mail.example.com":     "example.com",
+		"dmarc.example.co.uk.": "example.co.uk",
+		"com":                  "com",
+	}
+	for name, expected := range tests {
+		if value := OrganizationalDomain(name); value != expected {
+			t.Errorf("Expected %v but got %v", expected, value)
+		}
+	}
+}
+
+func TestExpandSPF(t *testing.T) {
+	c := newTestChecker(t, dnstest.Zone{
+		"example.com":      {TXT: []string{"v=spf1 ip4:192.0.2.0/24 include:_spf.example.net a:mail.example.com/28 ptr -all"}},
+		"mail.example.com": {IP: []string{"203.0.113.7", "2001:db8::25"}},
+		"_spf.example.net": {TXT: []string{"v=spf1 ip4:198.51.100.0/24 ip4:192.0.2.0/24 ~all"}},
+		"redir.com":        {TXT: []string{"v=spf1 ip6:2001:db8:1::/48 redirect=example.com"}},
+	})
+	ctx := context.Background()
+
+	exp := c.ExpandSPF(ctx, "example.com")
+	tests := []struct {
+		ip, result, term string
+	}{
+		{"192.0.2.10", SPFPass, "ip4:192.0.2.0/24"},
+		{"198.51.100.1", SPFPass, "ip4:198.51.100.0/24"},
+		{"203.0.113.1", SPFPass, "a:mail.example.com/28"},
+		{"2001:db8::25", SPFPass, "a:mail.example.com/28"},
+		{"203.0.113.99", SPFFail, "-all"},
+	}
+	for _, test := range tests {
+		v := exp.Evaluate(net.ParseIP(test.ip))
+		if v.Result != test.result || v.Term != test.term {
+			t.Errorf("Expected %v %v for %v but got %v %v", test.result, test.term, test.ip, v.Result, v.Term)
+		}
+		if v.Result == SPFFail && len(v.Skipped) != 1 {
+			t.Errorf("Expected ptr to be skipped but got %v", v.Skipped)
+		}
+	}
+
+	expected := "v=spf1 ip4:192.0.2.0/24 ip4:198.51.100.0/24 ip4:203.0.113.0/28 ip6:2001:db8::25 ptr -all"
+	if flat, err := exp.Flatten(); err != nil || flat != expected {
+		t.Errorf("Expected %v but got %v %v", expected, flat, err)
+	}
+
+	exp = c.ExpandSPF(ctx, "redir.com")
+	v := exp.Evaluate(net.ParseIP("2001:db8:1::1"))
+	if v.Result != SPFPass || v.Domain != "redir.com" {
+		t.Errorf("Expected pass from redir.com but got %v from %v", v.Result, v.Domain)
+	}
+	v = exp.Evaluate(net.ParseIP("198.51.100.1"))
+	if v.Result != SPFPass || v.Domain != "_spf.example.net" {
+		t.Errorf("Expected pass from _spf.example.net but got %v from %v", v.Result, v.Domain)
+	}
+
+	v = c.ExpandSPF(ctx, "missing.com").Evaluate(net.ParseIP("192.0.2.1"))
+	if v.Result != SPFNone {
+		t.Errorf("Expected %v but got %v", SPFNone, v.Result)
+	}
+}
+
+func TestFlattenSPFQualifiers(t *testing.T) {
+	c := newTestChecker(t, dnstest.Zone{
+		"example.com":      {TXT: []string{"v=spf1 -ip4:192.0.2.4 ip4:192.0.2.0/24 ~include:_spf.example.net ?ip6:2001:db8::/32 -all"}},
+		"_spf.example.net": {TXT: []string{"v=spf1 -ip4:203.0.113.9 ip4:198.51.100.0/24 ip4:192.0.2.0/24 ~all"}},
+		"shadow.com":       {TXT: []string{"v=spf1 include:_spf.shadow.com -all"}},
+		"_spf.shadow.com":  {TXT: []string{"v=spf1 -ip4:198.51.100.9 ip4:198.51.100.0/24 -all"}},
+	})
+	ctx := context.Background()
+
+	exp := c.ExpandSPF(ctx, "example.com")
+	expected := "v=spf1 -ip4:192.0.2.4 ip4:192.0.2.0/24 ~ip4:198.51.100.0/24 ?ip6:2001:db8::/32 -all"
+	flat, err := exp.Flatten()
+	if err != nil || flat != expected {
+		t.Errorf("Expected %v but got %v %v", expected, flat, err)
+	}
+	if v := exp.Evaluate(net.ParseIP("192.0.2.4")); v.Result != SPFFail {
+		t.Errorf("Expected %v but got %v", SPFFail, v.Result)
+	}
+
+	var nets []string
+	for _, n := range exp.Networks() {
+		nets = append(nets, n.String())
+	}
+	expectedNets := "-192.0.2.4/32 192.0.2.0/24 ~198.51.100.0/24 ?2001:db8::/32"
+	if strings.Join(nets, " ") != expectedNets {
+		t.Errorf("Expected %v but got %v", expectedNets, nets)
+	}
+
+	if flat, err = c.ExpandSPF(ctx, "shadow.com").Flatten(); err == nil {
+		t.Errorf("Expected an error but got %v", flat)
+	}
+}
+
+func TestExpandSPFInvalidPrefixLength(t *testing.T) {
+	c := newTestChecker(t, dnstest.Zone{
+		"example.com":      {TXT: []string{"v=spf1 include:_spf.example.com ip4:192.0.2.0/24 -all"}},
+		"mail.example.com": {IP: []string{"203.0.113.7"}},
+		"alpha.com":        {TXT: []string{"v=spf1 a:mail.example.com/abc -all"}},
+		"range.com":        {TXT: []string{"v=spf1 a:mail.example.com/33 -all"}},
+		"mx.com":           {TXT: []string{"v=spf1 mx:mail.example.com//xyz -all"}},
+		"mxrange.com":      {TXT: []string{"v=spf1 mx:mail.example.com//129 -all"}},
+		"_spf.example.com": {TXT: []string{"v=spf1 a:mail.example.com/-1 -all"}},
+	})
+	ctx := context.Background()
+
+	for _, domain := range []string{"alpha.com", "range.com", "mx.com", "mxrange.com", "example.com"} {
+		exp := c.ExpandSPF(ctx, domain)
+		if len(exp.Errors) != 1 || !strings.Contains(exp.Errors[0], "invalid prefix length") {
+			t.Errorf("Expected an invalid prefix length error for %v but got %v", domain, exp.Errors)
+		}
+		if v := exp.Evaluate(net.ParseIP("203.0.113.7")); v.Result != SPFPermError {
+			t.Errorf("Expected %v for %v but got %v", SPFPermError, domain, v.Result)
+		}
+	}
+
+	exp := c.ExpandSPF(ctx, "example.com")
+	if v := exp.Evaluate(net.ParseIP("192.0.2.1")); v.Result != SPFPermError {
+		t.Errorf("Expected %v but got %v", SPFPermError, v.Result)
+	}
+}
+
+func TestLookupSource(t *testing.T) {
+	c := newTestChecker(t, dnstest.Zone{
+		"209.85.220.41":                      {PTR: []string{"mail-sor-f41.google.com."}},
+		"41.220.85.209.origin.asn.cymru.com": {TXT: []string{"15169 | 209.85.128.0/17 | US | arin | 2006-01-13"}},
+		"AS15169.asn.cymru.com":              {TXT: []string{"15169 | US | arin | 2000-03-30 | GOOGLE, US"}},
+	})
+
+	info := c.LookupSource(context.Background(), net.ParseIP("209.85.220.41"))
+	expected := SourceInfo{
+		IP:      "209.85.220.41",
+		Names:   []string{"mail-sor-f41.google.com"},
+		ASN:     "15169",
+		Prefix:  "209.85.128.0/17",
+		Country: "US",
+		ASName:  "GOOGLE, US",
+	}
+	if fmt.Sprint(info) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v but got %v", expected, info)
+	}
+
+	info = c.LookupSource(context.Background(), net.ParseIP("192.0.2.1"))
+	if len(info.Names) != 0 || info.ASN != "" {
+		t.Errorf("Expected no information but got %v", info)
+	}
+
+	expectedName := "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.origin6.asn.cymru.com"
+	if name := cymruOriginName(net.ParseIP("2001:db8::1")); name != expectedName {
+		t.Errorf("Expected %v but got %v", expectedName, name)
+	}
+}
@@ -0,0 +1,205 @@
+// Package dnstest provides a small in-memory DNS server for tests that need
+// a real resolver pointed at known records.
+package dnstest
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Records holds the answers served for a single name.
+type Records struct {
+	TXT []string
+	IP  []string // IPv4 addresses are served as A, IPv6 as AAAA.
+	MX  []MX
+	PTR []string
+}
+
+// MX is a single mail exchanger.
+type MX struct {
+	Host string
+	Pref uint16
+}
+
+// Zone maps a domain name to its records. A key that parses as an IP
+// address is served as the matching in-addr.arpa or ip6.arpa PTR name.
+type Zone map[string]Records
+
+// Server answers UDP DNS queries from a Zone.
+type Server struct {
+	Addr string
+
+	conn net.PacketConn
+	mu   sync.RWMutex
+	zone map[string]Records
+}
+
+// NewServer starts a server on a random local port.
+func NewServer(zone Zone) (*Server, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		Addr: conn.LocalAddr().String(),
+		conn: conn,
+		zone: map[string]Records{},
+	}
+	for name, r := range zone {
+		s.Set(name, r)
+	}
+
+	go s.serve()
+	return s, nil
+}
+
+// Set replaces the records served for name.
+func (s *Server) Set(name string, r Records) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.zone[canonicalName(name)] = r
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	return s.conn.Close()
+}
+
+// Resolver returns a resolver that sends every query to the server.
+func (s *Server) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", s.Addr)
+		},
+	}
+}
+
+func (s *Server) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		resp, err := s.answer(buf[:n])
+		if err != nil {
+			continue
+		}
+		s.conn.WriteTo(resp, addr)
+	}
+}
+
+func (s *Server) answer(req []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(req)
+	if err != nil {
+		return nil, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	r, found := s.zone[strings.ToLower(q.Name.String())]
+	s.mu.RUnlock()
+
+	rh := dnsmessage.Header{ID: h.ID, Response: true, Authoritative: true, RecursionDesired: h.RecursionDesired, RecursionAvailable: true}
+	if !found {
+		rh.RCode = dnsmessage.RCodeNameError
+	}
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), rh)
+	b.EnableCompression()
+	if err = b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err = b.Question(q); err != nil {
+		return nil, err
+	}
+	if err = b.StartAnswers(); err != nil {
+		return nil, err
+	}
+
+	rrh := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: 60}
+	switch q.Type {
+	case dnsmessage.TypeTXT:
+		for _, txt := range r.TXT {
+			err = b.TXTResource(rrh, dnsmessage.TXTResource{TXT: splitTXT(txt)})
+		}
+	case dnsmessage.TypeA:
+		for _, ip := range r.IP {
+			if v4 := net.ParseIP(ip).To4(); v4 != nil {
+				var a [4]byte
+				copy(a[:], v4)
+				err = b.AResource(rrh, dnsmessage.AResource{A: a})
+			}
+		}
+	case dnsmessage.TypeAAAA:
+		for _, ip := range r.IP {
+			parsed := net.ParseIP(ip)
+			if parsed != nil && parsed.To4() == nil {
+				var a [16]byte
+				copy(a[:], parsed)
+				err = b.AAAAResource(rrh, dnsmessage.AAAAResource{AAAA: a})
+			}
+		}
+	case dnsmessage.TypeMX:
+		for _, mx := range r.MX {
+			err = b.MXResource(rrh, dnsmessage.MXResource{Pref: mx.Pref, MX: dnsmessage.MustNewName(canonicalName(mx.Host))})
+		}
+	case dnsmessage.TypePTR:
+		for _, ptr := range r.PTR {
+			err = b.PTRResource(rrh, dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(canonicalName(ptr))})
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Finish()
+}
+
+// splitTXT breaks a record into the 255 byte character-strings DNS requires.
+func splitTXT(s string) (parts []string) {
+	for len(s) > 255 {
+		parts = append(parts, s[:255])
+		s = s[255:]
+	}
+	return append(parts, s)
+}
+
+func canonicalName(name string) string {
+	if ip := net.ParseIP(name); ip != nil {
+		name = reverseName(ip)
+	}
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+func reverseName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return net.IPv4(v4[3], v4[2], v4[1], v4[0]).String() + ".in-addr.arpa."
+	}
+
+	const hex = "0123456789abcdef"
+	var sb strings.Builder
+	for i := len(ip) - 1; i >= 0; i-- {
+		sb.WriteByte(hex[ip[i]&0xf])
+		sb.WriteByte('.')
+		sb.WriteByte(hex[ip[i]>>4])
+		sb.WriteByte('.')
+	}
+	sb.WriteString("ip6.arpa.")
+	return sb.String()
+}
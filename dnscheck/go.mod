@@ -0,0 +1,10 @@
+module github.com/ericdaugherty/dmarc/dnscheck
+
+go 1.18
+
+require (
+	github.com/ericdaugherty/dmarc/dmarcrecord v0.0.0-00010101000000-000000000000
+	golang.org/x/net v0.7.0
+)
+
+replace github.com/ericdaugherty/dmarc/dmarcrecord => ../dmarcrecord
@@ -0,0 +1,79 @@
+package dnscheck
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// SourceInfo describes who operates a sending IP address.
+type SourceInfo struct {
+	IP      string
+	Names   []string
+	ASN     string
+	Prefix  string
+	Country string
+	ASName  string
+}
+
+// LookupSource returns the reverse DNS names of ip and the autonomous system
+// announcing it, using the Team Cymru IP to ASN DNS service. Anything that
+// cannot be found is left empty.
+func (c *Checker) LookupSource(ctx context.Context, ip net.IP) (info SourceInfo) {
+	info.IP = ip.String()
+
+	names, _ := c.resolver.LookupAddr(ctx, info.IP)
+	for _, n := range names {
+		info.Names = append(info.Names, strings.TrimSuffix(n, "."))
+	}
+
+	txts, err := c.resolver.LookupTXT(ctx, cymruOriginName(ip))
+	if err != nil || len(txts) == 0 {
+		return
+	}
+	// ASN | prefix | country | registry | allocated
+	fields := splitCymru(txts[0])
+	if len(fields) < 3 {
+		return
+	}
+	// An address announced by several AS numbers lists them all, use the first.
+	info.ASN = strings.Fields(fields[0])[0]
+	info.Prefix = fields[1]
+	info.Country = fields[2]
+
+	txts, err = c.resolver.LookupTXT(ctx, fmt.Sprintf("AS%v.asn.cymru.com", info.ASN))
+	if err != nil || len(txts) == 0 {
+		return
+	}
+	// ASN | country | registry | allocated | name
+	if fields = splitCymru(txts[0]); len(fields) >= 5 {
+		info.ASName = fields[4]
+	}
+	return
+}
+
+// cymruOriginName returns the name to query for the origin AS of ip.
+func cymruOriginName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%v.%v.%v.%v.origin.asn.cymru.com", v4[3], v4[2], v4[1], v4[0])
+	}
+
+	const hex = "0123456789abcdef"
+	v6 := ip.To16()
+	nibbles := make([]string, 0, 32)
+	for i := len(v6) - 1; i >= 0; i-- {
+		nibbles = append(nibbles, string(hex[v6[i]&0xf]), string(hex[v6[i]>>4]))
+	}
+	return strings.Join(nibbles, ".") + ".origin6.asn.cymru.com"
+}
+
+func splitCymru(txt string) (fields []string) {
+	for _, f := range strings.Split(txt, "|") {
+		fields = append(fields, strings.TrimSpace(f))
+	}
+	if len(fields) == 0 || fields[0] == "" {
+		return nil
+	}
+	return
+}
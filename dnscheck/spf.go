@@ -0,0 +1,345 @@
+package dnscheck
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// RFC 7208 §4.6.4 limits.
+const (
+	spfMaxLookups     = 10
+	spfMaxVoidLookups = 2
+)
+
+// SPFResult is the outcome of checking a domain's SPF record.
+type SPFResult struct {
+	Record      string
+	Lookups     int
+	VoidLookups int
+	Issues
+}
+
+// CheckSPF fetches the SPF record for domain, validates its syntax and counts
+// the DNS lookups needed to evaluate it, following include and redirect.
+func (c *Checker) CheckSPF(ctx context.Context, domain string) (res SPFResult) {
+	exp := c.ExpandSPF(ctx, domain)
+	res.Issues = exp.Issues
+	res.Lookups = exp.Lookups
+	res.VoidLookups = exp.VoidLookups
+	if exp.Tree != nil {
+		res.Record = exp.Tree.Record
+	}
+	return
+}
+
+// SPFExpansion is a domain's SPF record with every include and redirect
+// followed and every a, mx, ip4 and ip6 mechanism resolved to networks.
+type SPFExpansion struct {
+	Domain      string
+	Tree        *SPFTree
+	Lookups     int
+	VoidLookups int
+	Issues
+}
+
+// SPFTree is one SPF record in an expansion. Invalid is set if the record
+// has a syntax error, which makes it evaluate to permerror.
+type SPFTree struct {
+	Domain   string
+	Record   string
+	Terms    []SPFTerm
+	Redirect *SPFTree
+	Invalid  bool
+}
+
+// SPFTerm is one mechanism of an SPF record. Include holds the expanded
+// record of an include mechanism. Unresolved is set for mechanisms whose
+// result depends on the message being evaluated, such as ptr, exists and
+// anything using macros.
+type SPFTerm struct {
+	Qualifier  string
+	Mechanism  string
+	Value      string
+	Nets       []*net.IPNet
+	Include    *SPFTree
+	Unresolved bool
+}
+
+func (t SPFTerm) String() string {
+	s := t.Mechanism
+	if t.Value != "" {
+		if t.Value[0] == '/' {
+			s += t.Value
+		} else {
+			s += ":" + t.Value
+		}
+	}
+	if t.Qualifier != "+" {
+		s = t.Qualifier + s
+	}
+	return s
+}
+
+// ExpandSPF fetches the SPF record for domain and recursively resolves it.
+func (c *Checker) ExpandSPF(ctx context.Context, domain string) (exp SPFExpansion) {
+	exp.Domain = domain
+
+	record, err := c.lookupSPF(ctx, domain)
+	if err != nil {
+		exp.errorf("%v", err)
+		return
+	}
+	if record == "" {
+		exp.warnf("no SPF record found for %v", domain)
+		return
+	}
+
+	e := spfExpander{checker: c, exp: &exp, visited: map[string]bool{strings.ToLower(domain): true}}
+	exp.Tree = e.expand(ctx, domain, record)
+
+	if exp.Lookups > spfMaxLookups {
+		exp.errorf("evaluation requires %v DNS lookups, more than the limit of %v", exp.Lookups, spfMaxLookups)
+	}
+	if exp.VoidLookups > spfMaxVoidLookups {
+		exp.errorf("evaluation has %v void lookups, more than the limit of %v", exp.VoidLookups, spfMaxVoidLookups)
+	}
+
+	return
+}
+
+// lookupSPF returns the single SPF record for domain, or "" if none exists.
+func (c *Checker) lookupSPF(ctx context.Context, domain string) (record string, err error) {
+	txts, err := c.lookupTXT(ctx, domain, "v=spf1")
+	if err != nil {
+		return
+	}
+
+	var records []string
+	for _, txt := range txts {
+		if len(txt) == len("v=spf1") || txt[len("v=spf1")] == ' ' {
+			records = append(records, txt)
+		}
+	}
+
+	switch len(records) {
+	case 0:
+		return
+	case 1:
+		return records[0], nil
+	default:
+		return "", fmt.Errorf("multiple SPF records found for %v", domain)
+	}
+}
+
+// spfExpander builds an SPFExpansion. visited holds the domains on the
+// current include path so loops can be detected.
+type spfExpander struct {
+	checker *Checker
+	exp     *SPFExpansion
+	visited map[string]bool
+}
+
+func (e *spfExpander) expand(ctx context.Context, domain, record string) *SPFTree {
+	tree := &SPFTree{Domain: domain, Record: record}
+	var redirect string
+	hasAll := false
+
+	for _, field := range strings.Fields(record)[1:] {
+		t := SPFTerm{Qualifier: "+"}
+		switch field[0] {
+		case '+', '-', '~', '?':
+			t.Qualifier = field[:1]
+			field = field[1:]
+		}
+
+		if i := strings.IndexAny(field, ":/="); i >= 0 {
+			if field[i] == '=' {
+				switch name := strings.ToLower(field[:i]); name {
+				case "redirect":
+					redirect = field[i+1:]
+				case "exp":
+				default:
+					e.exp.warnf("unknown modifier %v in SPF record for %v", name, domain)
+				}
+				continue
+			}
+			t.Mechanism = strings.ToLower(field[:i])
+			t.Value = strings.TrimPrefix(field[i:], ":")
+		} else {
+			t.Mechanism = strings.ToLower(field)
+		}
+		t.Unresolved = hasMacro(t.Value)
+
+		switch t.Mechanism {
+		case "all":
+			hasAll = true
+		case "ip4", "ip6":
+			n, err := parseNet(t.Value, t.Mechanism == "ip6")
+			if err != nil {
+				tree.Invalid = true
+				e.exp.errorf("invalid %v in SPF record for %v", t, domain)
+			} else {
+				t.Nets = []*net.IPNet{n}
+			}
+		case "include":
+			e.exp.Lookups++
+			if !t.Unresolved {
+				t.Include = e.follow(ctx, t.Value, "include:")
+			}
+		case "a":
+			e.exp.Lookups++
+			if t.Unresolved {
+				break
+			}
+			if host, v4, v6, err := splitDualCIDR(t.Value, domain); err != nil {
+				tree.Invalid = true
+				e.exp.errorf("invalid %v in SPF record for %v. %v", t, domain, err)
+			} else {
+				addrs, err := e.checker.resolver.LookupIPAddr(ctx, host)
+				e.countVoid(len(addrs), err)
+				t.Nets = addrNets(addrs, v4, v6)
+			}
+		case "mx":
+			e.exp.Lookups++
+			if t.Unresolved {
+				break
+			}
+			if host, v4, v6, err := splitDualCIDR(t.Value, domain); err != nil {
+				tree.Invalid = true
+				e.exp.errorf("invalid %v in SPF record for %v. %v", t, domain, err)
+			} else {
+				mxs, err := e.checker.resolver.LookupMX(ctx, host)
+				e.countVoid(len(mxs), err)
+				if len(mxs) > spfMaxLookups {
+					e.exp.errorf("mx mechanism for %v returns %v hosts, more than the limit of %v", host, len(mxs), spfMaxLookups)
+				}
+				for _, mx := range mxs {
+					addrs, _ := e.checker.resolver.LookupIPAddr(ctx, mx.Host)
+					t.Nets = append(t.Nets, addrNets(addrs, v4, v6)...)
+				}
+			}
+		case "ptr":
+			e.exp.Lookups++
+			t.Unresolved = true
+			e.exp.warnf("ptr mechanism in SPF record for %v is deprecated", domain)
+		case "exists":
+			e.exp.Lookups++
+			t.Unresolved = true
+		default:
+			tree.Invalid = true
+			e.exp.errorf("unknown mechanism %v in SPF record for %v", t.Mechanism, domain)
+			continue
+		}
+
+		tree.Terms = append(tree.Terms, t)
+	}
+
+	if redirect != "" && !hasAll {
+		e.exp.Lookups++
+		if !hasMacro(redirect) {
+			tree.Redirect = e.follow(ctx, redirect, "redirect=")
+		}
+	}
+
+	return tree
+}
+
+// follow expands the SPF record of an include or redirect target.
+func (e *spfExpander) follow(ctx context.Context, target, via string) *SPFTree {
+	key := strings.ToLower(target)
+	if e.visited[key] {
+		e.exp.errorf("SPF record for %v includes itself", target)
+		return nil
+	}
+	e.visited[key] = true
+	defer delete(e.visited, key)
+
+	record, err := e.checker.lookupSPF(ctx, target)
+	if err != nil {
+		e.exp.errorf("%v", err)
+		return nil
+	}
+	if record == "" {
+		e.exp.VoidLookups++
+		e.exp.errorf("%v%v has no SPF record", via, target)
+		return nil
+	}
+
+	return e.expand(ctx, target, record)
+}
+
+func (e *spfExpander) countVoid(answers int, err error) {
+	if answers == 0 && (err == nil || isNotFound(err)) {
+		e.exp.VoidLookups++
+	}
+}
+
+// splitDualCIDR splits the value of an a or mx mechanism into the domain,
+// defaulting to current, and the IPv4 and IPv6 prefix lengths.
+func splitDualCIDR(value, current string) (host string, v4, v6 int, err error) {
+	v4, v6 = 32, 128
+	if i := strings.Index(value, "//"); i >= 0 {
+		if v6, err = prefixLength(value[i+2:], 128); err != nil {
+			return
+		}
+		value = value[:i]
+	}
+	if i := strings.Index(value, "/"); i >= 0 {
+		if v4, err = prefixLength(value[i+1:], 32); err != nil {
+			return
+		}
+		value = value[:i]
+	}
+	host = value
+	if host == "" {
+		host = current
+	}
+	return
+}
+
+// prefixLength parses a CIDR prefix length of at most max bits.
+func prefixLength(s string, max int) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || strings.Trim(s, "0123456789") != "" || n > max {
+		return 0, fmt.Errorf("invalid prefix length /%v", s)
+	}
+	return n, nil
+}
+
+func addrNets(addrs []net.IPAddr, v4, v6 int) (nets []*net.IPNet) {
+	for _, a := range addrs {
+		if ip := a.IP.To4(); ip != nil {
+			nets = append(nets, &net.IPNet{IP: ip.Mask(net.CIDRMask(v4, 32)), Mask: net.CIDRMask(v4, 32)})
+		} else {
+			nets = append(nets, &net.IPNet{IP: a.IP.Mask(net.CIDRMask(v6, 128)), Mask: net.CIDRMask(v6, 128)})
+		}
+	}
+	return
+}
+
+// parseNet parses the address and optional prefix length of an ip4 or ip6
+// mechanism.
+func parseNet(value string, v6 bool) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		if v6 {
+			value += "/128"
+		} else {
+			value += "/32"
+		}
+	}
+	ip, n, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, err
+	}
+	if (ip.To4() == nil) != v6 {
+		return nil, fmt.Errorf("wrong address family in %v", value)
+	}
+	return n, nil
+}
+
+func hasMacro(s string) bool {
+	return strings.Contains(s, "%")
+}
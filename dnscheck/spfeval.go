@@ -0,0 +1,281 @@
+package dnscheck
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// SPF results from RFC 7208 §2.6.
+const (
+	SPFPass      = "pass"
+	SPFFail      = "fail"
+	SPFSoftFail  = "softfail"
+	SPFNeutral   = "neutral"
+	SPFNone      = "none"
+	SPFPermError = "permerror"
+)
+
+var qualifierResults = map[string]string{
+	"+": SPFPass,
+	"-": SPFFail,
+	"~": SPFSoftFail,
+	"?": SPFNeutral,
+}
+
+// SPFVerdict is the result of evaluating an expansion for one IP address.
+type SPFVerdict struct {
+	IP     string
+	Result string
+	// Domain and Term identify the mechanism that matched, if any.
+	Domain string
+	Term   string
+	// Skipped lists mechanisms that could not be evaluated without a message,
+	// such as ptr and exists. The verdict assumes none of them matched.
+	Skipped []string
+}
+
+// Evaluate returns the SPF result ip would get from the expansion.
+func (exp SPFExpansion) Evaluate(ip net.IP) (v SPFVerdict) {
+	v.IP = ip.String()
+	if exp.Tree == nil {
+		v.Result = SPFNone
+		if len(exp.Errors) > 0 {
+			v.Result = SPFPermError
+		}
+		return
+	}
+
+	v.Result = exp.Tree.evaluate(ip, &v)
+	return
+}
+
+func (t *SPFTree) evaluate(ip net.IP, v *SPFVerdict) string {
+	if t.Invalid {
+		v.Domain, v.Term = t.Domain, ""
+		return SPFPermError
+	}
+	for _, term := range t.Terms {
+		matched := false
+		switch {
+		case term.Unresolved:
+			v.Skipped = append(v.Skipped, t.Domain+": "+term.String())
+		case term.Mechanism == "all":
+			matched = true
+		case term.Mechanism == "include":
+			if term.Include == nil {
+				return SPFPermError
+			}
+			switch res := term.Include.evaluate(ip, v); res {
+			case SPFPass:
+				matched = true
+			case SPFPermError:
+				return res
+			default:
+				v.Domain, v.Term = "", ""
+			}
+		default:
+			for _, n := range term.Nets {
+				if n.Contains(ip) {
+					matched = true
+					break
+				}
+			}
+		}
+
+		if matched {
+			if v.Term == "" || term.Mechanism != "include" {
+				v.Domain = t.Domain
+				v.Term = term.String()
+			}
+			return qualifierResults[term.Qualifier]
+		}
+	}
+
+	if t.Redirect != nil {
+		return t.Redirect.evaluate(ip, v)
+	}
+	return SPFNeutral
+}
+
+// SPFNetwork is a network an SPF expansion gives a result for, with the
+// qualifier of the mechanism that gives it.
+type SPFNetwork struct {
+	Qualifier string
+	*net.IPNet
+}
+
+func (n SPFNetwork) String() string {
+	return qualify(n.Qualifier, n.IPNet.String())
+}
+
+// Networks returns every network the expansion gives a result for, following
+// includes and redirects, in the order they are evaluated. A network only
+// appears the first time, since only the first mechanism matching an address
+// counts. Networks in an included record take the qualifier of the include.
+func (exp SPFExpansion) Networks() []SPFNetwork {
+	f := newSPFFlattener()
+	f.flatten(exp.Tree, nil)
+	return f.nets
+}
+
+// Flatten returns an SPF record that gives the same results as the
+// expansion using only ip4 and ip6 mechanisms, so it needs no DNS lookups.
+// Mechanisms that cannot be resolved ahead of time are kept as they are,
+// and the all mechanism of the original record is kept. It returns an error
+// if the record is invalid, or if an included record has a mechanism that
+// does not pass before one that does, which a flat record cannot express.
+func (exp SPFExpansion) Flatten() (string, error) {
+	f := newSPFFlattener()
+	f.flatten(exp.Tree, nil)
+	if f.err != nil {
+		return "", f.err
+	}
+
+	terms := []string{"v=spf1"}
+	terms = append(terms, f.terms...)
+	if f.all != "" {
+		terms = append(terms, f.all)
+	}
+	return strings.Join(terms, " "), nil
+}
+
+// spfFlattener collects the terms of a flattened record. Its first error is
+// kept while it carries on, so Networks can still list every network.
+type spfFlattener struct {
+	terms []string
+	nets  []SPFNetwork
+	all   string
+	seen  map[string]bool
+	err   error
+}
+
+func newSPFFlattener() *spfFlattener {
+	return &spfFlattener{seen: map[string]bool{}}
+}
+
+func (f *spfFlattener) errorf(format string, args ...interface{}) {
+	if f.err == nil {
+		f.err = fmt.Errorf(format, args...)
+	}
+}
+
+// spfScope is an included record being flattened. Its mechanisms that pass
+// give the qualifier of the include. Those that do not only make the include
+// not match, so they are left out, and shadows keeps their networks, which
+// the mechanisms after them in the record can no longer pass. opaque is set
+// once one of them cannot be resolved ahead of time.
+type spfScope struct {
+	qualifier string
+	shadows   []*net.IPNet
+	opaque    bool
+}
+
+// flatten adds the terms of t, which is included with scope, or is the
+// record being flattened or one it redirects to if scope is nil. It returns
+// true once an all mechanism ends the evaluation.
+func (f *spfFlattener) flatten(t *SPFTree, scope *spfScope) (done bool) {
+	if t == nil {
+		return false
+	}
+	if t.Invalid {
+		f.errorf("SPF record for %v is invalid", t.Domain)
+		return false
+	}
+
+	for _, term := range t.Terms {
+		qualifier := term.Qualifier
+		if scope != nil {
+			if term.Qualifier != "+" {
+				switch {
+				case term.Unresolved || term.Mechanism == "include":
+					scope.opaque = true
+				case term.Mechanism == "all":
+					return false
+				}
+				scope.shadows = append(scope.shadows, term.Nets...)
+				continue
+			}
+			qualifier = scope.qualifier
+			if scope.blocks(term) {
+				f.errorf("%v in the SPF record for %v passes addresses an earlier mechanism does not", term, t.Domain)
+			}
+		}
+
+		switch {
+		case term.Unresolved:
+			term.Qualifier = qualifier
+			f.terms = append(f.terms, term.String())
+		case term.Mechanism == "all":
+			f.all = qualify(qualifier, "all")
+			return true
+		case term.Mechanism == "include":
+			if term.Include == nil {
+				f.errorf("%v in the SPF record for %v could not be expanded", term, t.Domain)
+				continue
+			}
+			inner := &spfScope{qualifier: qualifier}
+			if scope != nil {
+				inner.shadows = append(inner.shadows, scope.shadows...)
+				inner.opaque = scope.opaque
+			}
+			if f.flatten(term.Include, inner) {
+				return true
+			}
+		default:
+			for _, n := range term.Nets {
+				f.add(SPFNetwork{Qualifier: qualifier, IPNet: n})
+			}
+		}
+	}
+
+	return f.flatten(t.Redirect, scope)
+}
+
+// blocks reports whether an earlier mechanism of the scope that does not
+// pass matches some of the addresses term passes.
+func (scope *spfScope) blocks(term SPFTerm) bool {
+	if len(scope.shadows) == 0 && !scope.opaque {
+		return false
+	}
+	if scope.opaque || term.Unresolved || term.Mechanism == "all" || term.Mechanism == "include" {
+		return true
+	}
+	for _, n := range term.Nets {
+		for _, s := range scope.shadows {
+			if s.Contains(n.IP) || n.Contains(s.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// add adds an ip4 or ip6 term for n, unless an earlier term has the same
+// network.
+func (f *spfFlattener) add(n SPFNetwork) {
+	if f.seen[n.IPNet.String()] {
+		return
+	}
+	f.seen[n.IPNet.String()] = true
+	f.nets = append(f.nets, n)
+
+	ones, bits := n.Mask.Size()
+	mech := "ip4:"
+	if bits == 128 {
+		mech = "ip6:"
+	}
+	if ones == bits {
+		f.terms = append(f.terms, qualify(n.Qualifier, mech+n.IP.String()))
+	} else {
+		f.terms = append(f.terms, qualify(n.Qualifier, mech+n.IPNet.String()))
+	}
+}
+
+// qualify prefixes s with qualifier, unless it is the default +.
+func qualify(qualifier, s string) string {
+	if qualifier == "+" {
+		return s
+	}
+	return qualifier + s
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// aggregateAttempts is how many times the aggregates of a day are rebuilt
+// when reports arrive for it while it is being rebuilt.
+const aggregateAttempts = 3
+
+// aggRow is a row of the aggregates table.
+type aggRow struct {
+	GMTDate          string `json:"gmtDate"`
+	AggregateKey     string `json:"aggregateKey"`
+	Domain           string `json:"domain"`
+	OrgName          string `json:"orgName"`
+	Reports          int    `json:"reports"`
+	CountAccepted    int    `json:"countAccepted"`
+	CountQuarantined int    `json:"countQuarantined"`
+	CountRejected    int    `json:"countRejected"`
+	CountPass        int    `json:"countPass"`
+	CountDKIMPass    int    `json:"countDkimPass"`
+	CountSPFPass     int    `json:"countSpfPass"`
+}
+
+// counts returns the counts of row as an entry, for aggregateChange.
+func (row aggRow) counts() dbEntry {
+	return dbEntry{
+		CountAccepted:    row.CountAccepted,
+		CountQuarantined: row.CountQuarantined,
+		CountRejected:    row.CountRejected,
+		CountPass:        row.CountPass,
+		CountDKIMPass:    row.CountDKIMPass,
+		CountSPFPass:     row.CountSPFPass,
+	}
+}
+
+// runAggregate rebuilds the aggregates of days from the reports stored
+// under them. Reports stored before the aggregates table was deployed were
+// never added to it, so run it once after deploying the table. Days are
+// chosen by the range of GMT dates, and without one every day with reports
+// is rebuilt.
+func runAggregate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("aggregate", flag.ExitOnError)
+	from := flags.String("from", "", "rebuild the aggregates of GMT dates from this one, 2006-01-02")
+	to := flags.String("to", "", "rebuild the aggregates of GMT dates up to this one, the from date by default")
+	dryRun := flags.Bool("dry-run", false, "print the changes without storing them")
+	workers := flags.Int("workers", 4, "number of days to rebuild at once")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return errors.New("unexpected arguments")
+	}
+	dates, err := parseDates(*from, *to)
+	if err != nil {
+		return err
+	}
+	if *workers < 1 {
+		return errors.New("workers must be at least 1")
+	}
+	if aggregateTableName == "" {
+		return errors.New("AGGREGATETABLENAME is not set")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	if dates == nil {
+		if dates, err = storedDates(ctx, svc); err != nil {
+			return err
+		}
+	}
+
+	p := newProgress("days", resultUnchanged, resultChanged, resultFailed)
+	p.setTotal(len(dates))
+	err = runTasks(ctx, *workers, p, func(run func(task) error) error {
+		for _, date := range dates {
+			date := date
+			if err := run(func(ctx context.Context) string { return aggregateDay(ctx, svc, date, *dryRun) }); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if *dryRun {
+		fmt.Printf("Checked %v\n", p)
+	} else {
+		fmt.Printf("Rebuilt %v\n", p)
+	}
+	return err
+}
+
+// parseDates returns every date from from to to, or from alone if to is
+// empty, or nil if both are empty.
+func parseDates(from, to string) (dates []string, err error) {
+	if from == "" {
+		if to != "" {
+			return nil, errors.New("a to date needs a from date")
+		}
+		return nil, nil
+	}
+	if to == "" {
+		to = from
+	}
+	first, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from date %q", from)
+	}
+	last, err := time.Parse("2006-01-02", to)
+	if err != nil || last.Before(first) {
+		return nil, fmt.Errorf("invalid to date %q", to)
+	}
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		dates = append(dates, d.Format("2006-01-02"))
+	}
+	return
+}
+
+// storedDates returns every GMT date that has reports stored under it.
+func storedDates(ctx context.Context, svc *dynamodb.Client) (dates []string, err error) {
+	seen := map[string]bool{}
+	pages := dynamodb.NewScanPaginator(svc, &dynamodb.ScanInput{
+		TableName:            aws.String(dynamoDBTableName),
+		ProjectionExpression: aws.String("gmtDate"),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var entries []dbEntry
+		if err = attributevalue.UnmarshalListOfMaps(page.Items, &entries); err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !seen[e.GMTDate] {
+				seen[e.GMTDate] = true
+				dates = append(dates, e.GMTDate)
+			}
+		}
+	}
+	sort.Strings(dates)
+	return
+}
+
+// aggregateDay totals the reports stored under date and, unless dryRun is
+// set, corrects the aggregate rows of the day that differ. Each row is
+// corrected on condition that it still holds what was read, so a report
+// stored in the meantime makes the day be read and totalled again.
+func aggregateDay(ctx context.Context, svc *dynamodb.Client, date string, dryRun bool) string {
+	for attempt := 1; ; attempt++ {
+		stored, err := queryAggregates(ctx, svc, date)
+		if err != nil {
+			fmt.Printf("Error rebuilding the aggregates of %v. %v\n", date, err)
+			return resultFailed
+		}
+		totals, err := totalReports(ctx, svc, date)
+		if err != nil {
+			fmt.Printf("Error rebuilding the aggregates of %v. %v\n", date, err)
+			return resultFailed
+		}
+
+		updates, changes := aggregateCorrections(stored, totals)
+		if len(updates) == 0 {
+			return resultUnchanged
+		}
+		fmt.Printf("Changed %v:\n  %v\n", date, strings.Join(changes, "\n  "))
+		if dryRun {
+			return resultChanged
+		}
+
+		err = nil
+		for _, u := range updates {
+			if _, err = svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName:                 u.TableName,
+				Key:                       u.Key,
+				UpdateExpression:          u.UpdateExpression,
+				ConditionExpression:       u.ConditionExpression,
+				ExpressionAttributeNames:  u.ExpressionAttributeNames,
+				ExpressionAttributeValues: u.ExpressionAttributeValues,
+			}); err != nil {
+				break
+			}
+		}
+		switch {
+		case err == nil:
+			return resultChanged
+		case isDuplicate(err) && attempt < aggregateAttempts:
+			fmt.Printf("Aggregates of %v changed while they were rebuilt, trying again.\n", date)
+		default:
+			fmt.Printf("Error rebuilding the aggregates of %v. %v\n", date, err)
+			return resultFailed
+		}
+	}
+}
+
+// queryAggregates returns the aggregate rows stored for date.
+func queryAggregates(ctx context.Context, svc *dynamodb.Client, date string) (rows []aggRow, err error) {
+	pages := dynamodb.NewQueryPaginator(svc, &dynamodb.QueryInput{
+		TableName:                 aws.String(aggregateTableName),
+		KeyConditionExpression:    aws.String("gmtDate = :date"),
+		ExpressionAttributeValues: map[string]dbtypes.AttributeValue{":date": &dbtypes.AttributeValueMemberS{Value: date}},
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var items []aggRow
+		if err = attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		rows = append(rows, items...)
+	}
+	return
+}
+
+// totalReports totals the reports stored under date that are not
+// quarantined into a row for each domain and reporting organization.
+func totalReports(ctx context.Context, svc *dynamodb.Client, date string) (totals map[string]aggRow, err error) {
+	totals = map[string]aggRow{}
+	pages := dynamodb.NewQueryPaginator(svc, &dynamodb.QueryInput{
+		TableName:              aws.String(dynamoDBTableName),
+		KeyConditionExpression: aws.String("gmtDate = :date"),
+		// domain is a reserved word.
+		ProjectionExpression: aws.String("gmtDate, #domain, orgName, countAccepted, countQuarantined, countRejected, " +
+			"countPass, countDkimPass, countSpfPass, quarantined"),
+		ExpressionAttributeNames:  map[string]string{"#domain": "domain"},
+		ExpressionAttributeValues: map[string]dbtypes.AttributeValue{":date": &dbtypes.AttributeValueMemberS{Value: date}},
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var entries []dbEntry
+		if err = attributevalue.UnmarshalListOfMaps(page.Items, &entries); err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Quarantined {
+				continue
+			}
+			key := aggregateKey(e.Domain, e.OrgName)
+			row := totals[key]
+			row.GMTDate, row.AggregateKey, row.Domain, row.OrgName = e.GMTDate, key, e.Domain, e.OrgName
+			row.Reports++
+			row.CountAccepted += e.CountAccepted
+			row.CountQuarantined += e.CountQuarantined
+			row.CountRejected += e.CountRejected
+			row.CountPass += e.CountPass
+			row.CountDKIMPass += e.CountDKIMPass
+			row.CountSPFPass += e.CountSPFPass
+			totals[key] = row
+		}
+	}
+	return
+}
+
+// aggregateCorrections returns the updates that turn the stored aggregate
+// rows of a day into the totals of its reports, each conditioned on the row
+// being unchanged, and a description of each. Rows without reports are
+// brought to zero.
+func aggregateCorrections(stored []aggRow, totals map[string]aggRow) (updates []*dbtypes.Update, changes []string) {
+	byKey := map[string]aggRow{}
+	for _, row := range stored {
+		byKey[row.AggregateKey] = row
+	}
+	var keys []string
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	for key := range byKey {
+		if _, ok := totals[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		old, found := byKey[key]
+		row, ok := totals[key]
+		if !ok {
+			row = aggRow{GMTDate: old.GMTDate, AggregateKey: key, Domain: old.Domain, OrgName: old.OrgName}
+		}
+		if found && old == row {
+			continue
+		}
+
+		u := aggregateChange(dbEntry{GMTDate: row.GMTDate, Domain: row.Domain, OrgName: row.OrgName},
+			row.Reports-old.Reports, countDelta(old.counts(), row.counts()))
+		if found {
+			var conditions []string
+			for attr, value := range map[string]int{
+				"reports":          old.Reports,
+				"countAccepted":    old.CountAccepted,
+				"countQuarantined": old.CountQuarantined,
+				"countRejected":    old.CountRejected,
+				"countPass":        old.CountPass,
+				"countDkimPass":    old.CountDKIMPass,
+				"countSpfPass":     old.CountSPFPass,
+			} {
+				u.ExpressionAttributeValues[":old"+attr] = &dbtypes.AttributeValueMemberN{Value: fmt.Sprint(value)}
+				// Rows added before a count was have no value for it.
+				cond := fmt.Sprintf("%v = :old%v", attr, attr)
+				if value == 0 {
+					cond = fmt.Sprintf("(attribute_not_exists(%v) OR %v)", attr, cond)
+				}
+				conditions = append(conditions, cond)
+			}
+			sort.Strings(conditions)
+			u.ConditionExpression = aws.String(strings.Join(conditions, " AND "))
+		} else {
+			u.ConditionExpression = aws.String("attribute_not_exists(aggregateKey)")
+		}
+		updates = append(updates, u)
+		changes = append(changes, fmt.Sprintf("%v: %v reports, %v messages -> %v reports, %v messages",
+			key, old.Reports, old.counts().total(), row.Reports, row.counts().total()))
+	}
+	return
+}
+
+// total returns the number of messages counted in e.
+func (e dbEntry) total() int {
+	return e.CountAccepted + e.CountQuarantined + e.CountRejected
+}
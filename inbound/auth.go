@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/ericdaugherty/dmarc/dnscheck"
+	"github.com/ericdaugherty/dmarc/report"
+)
+
+// Email authentication results.
+const (
+	authPass = "pass"
+	authFail = "fail"
+	authNone = "none"
+)
+
+// Actions for reports whose email fails authentication.
+const (
+	actionAccept     = "accept"
+	actionQuarantine = "quarantine"
+	actionReject     = "reject"
+)
+
+// authResolver is used to fetch DKIM keys. Report emails are not
+// authenticated if it is nil.
+var authResolver dnscheck.Resolver
+
+// unauthenticatedAction is what to do with a report whose email fails
+// authentication.
+var unauthenticatedAction = actionAccept
+
+// authServID is the authserv-id the receiving server puts in the
+// Authentication-Results header it adds, amazonses.com for SES. The SPF
+// result of that header is only used if it is set.
+var authServID string
+
+// validAction reports whether action is one of the actions for reports that
+// fail authentication.
+func validAction(action string) bool {
+	switch action {
+	case actionAccept, actionQuarantine, actionReject:
+		return true
+	}
+	return false
+}
+
+// emailAuth is the result of authenticating the email a report arrived in.
+type emailAuth struct {
+	Result string
+	Detail string
+}
+
+// authenticateEmail checks that the raw email a report arrived in was sent
+// by the organization the report claims to be from. The email passes if it
+// has a valid DKIM signature, or passed the receiving server's SPF check
+// when authServID is set, for a domain in the same organizational domain as
+// the reporter, see reporterDomain.
+func authenticateEmail(ctx context.Context, raw []byte, f report.Feedback) (a emailAuth) {
+	if authResolver == nil {
+		a.Result = authNone
+		a.Detail = "not checked"
+		return
+	}
+
+	reporter := reporterDomain(f)
+	if reporter == "" {
+		a.Result = authFail
+		a.Detail = "report has no reporter domain"
+		return
+	}
+
+	a.Result = authFail
+	var details []string
+
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(raw), &dkim.VerifyOptions{
+		LookupTXT:        func(domain string) ([]string, error) { return authResolver.LookupTXT(ctx, domain) },
+		MaxVerifications: 5,
+	})
+	if err != nil && err != dkim.ErrTooManySignatures {
+		details = append(details, fmt.Sprintf("dkim=permerror (%v)", err))
+	}
+	for _, v := range verifications {
+		switch {
+		case v.Err != nil:
+			details = append(details, fmt.Sprintf("dkim=fail d=%v (%v)", v.Domain, v.Err))
+		case sameOrganization(v.Domain, reporter):
+			a.Result = authPass
+			details = append(details, fmt.Sprintf("dkim=pass d=%v", v.Domain))
+		default:
+			details = append(details, fmt.Sprintf("dkim=pass d=%v (not aligned)", v.Domain))
+		}
+	}
+	if len(verifications) == 0 && err == nil {
+		details = append(details, "dkim=none")
+	}
+
+	if result, domain := receivedSPF(raw); result != "" {
+		switch {
+		case result != "pass":
+			details = append(details, fmt.Sprintf("spf=%v", result))
+		case sameOrganization(domain, reporter):
+			a.Result = authPass
+			details = append(details, fmt.Sprintf("spf=pass %v", domain))
+		default:
+			details = append(details, fmt.Sprintf("spf=pass %v (not aligned)", domain))
+		}
+	}
+
+	a.Detail = strings.Join(details, "; ")
+	return
+}
+
+// reporterDomain returns the domain a report claims to come from. org_name
+// is free text, but is often the reporter's domain, and is then used rather
+// than the domain of the report_metadata email, so a report naming one
+// reporter cannot be authenticated by the domain of another. Otherwise both
+// are chosen by the sender, so a report only proves it came from the domain
+// of its email.
+func reporterDomain(f report.Feedback) string {
+	if org := f.ReportMetadata.OrgName; strings.Contains(org, ".") && !strings.ContainsAny(org, " @") {
+		return org
+	}
+	if i := strings.LastIndex(f.ReportMetadata.Email, "@"); i >= 0 {
+		return f.ReportMetadata.Email[i+1:]
+	}
+	return ""
+}
+
+func sameOrganization(domain, reporter string) bool {
+	return strings.EqualFold(dnscheck.OrganizationalDomain(domain), dnscheck.OrganizationalDomain(reporter))
+}
+
+// receivedSPF returns the SPF result and envelope from domain of the first
+// Authentication-Results header, if it was added by the receiving server
+// named by authServID. Any other header may have been added by the sender,
+// so is not trusted.
+func receivedSPF(raw []byte) (result, domain string) {
+	if authServID == "" {
+		return
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return
+	}
+	parts := strings.Split(msg.Header.Get("Authentication-Results"), ";")
+	if id := strings.Fields(parts[0]); len(id) == 0 || !strings.EqualFold(id[0], authServID) {
+		return
+	}
+
+	for _, part := range parts[1:] {
+		for _, field := range strings.Fields(part) {
+			switch {
+			case strings.HasPrefix(field, "spf="):
+				result = strings.ToLower(field[len("spf="):])
+			case strings.HasPrefix(field, "smtp.mailfrom="), strings.HasPrefix(field, "envelope-from="):
+				// SES puts envelope-from in a part of its own after the SPF result.
+				from := field[strings.Index(field, "=")+1:]
+				domain = from[strings.LastIndex(from, "@")+1:]
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// progressEvery is how many items of a batch are processed between progress
+// lines.
+const progressEvery = 100
+
+// task processes one item of a batch, returning the result it is counted
+// under.
+type task func(ctx context.Context) string
+
+// runTasks runs the tasks produce sends, workers at a time, counting their
+// results in p. It returns the error from produce once the tasks sent before
+// it have finished.
+func runTasks(ctx context.Context, workers int, p *progress, produce func(send func(task) error) error) error {
+	tasks := make(chan task)
+	var produced error
+	go func() {
+		defer close(tasks)
+		produced = produce(func(t task) error {
+			select {
+			case tasks <- t:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tasks {
+				p.add(runTask(ctx, t))
+			}
+		}()
+	}
+	wg.Wait()
+	return produced
+}
+
+// runTask runs t, counting it as failed if it panics, so one malformed item
+// does not end the batch.
+func runTask(ctx context.Context, t task) (result string) {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Printf("Error processing item. %v\n", err)
+			result = resultFailed
+		}
+	}()
+	return t(ctx)
+}
+
+// progress counts the results of a batch, and the reports it has seen so a
+// report found more than once is only processed once.
+type progress struct {
+	noun    string
+	order   []string
+	mu      sync.Mutex
+	total   int
+	done    int
+	results map[string]int
+	seen    map[string]bool
+}
+
+// newProgress returns a progress for a batch of noun, listing the counts of
+// results in order.
+func newProgress(noun string, results ...string) *progress {
+	return &progress{noun: noun, order: results, results: map[string]int{}, seen: map[string]bool{}}
+}
+
+// setTotal sets the number of items in the batch, once it is known.
+func (p *progress) setTotal(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total = n
+}
+
+// first returns true the first time it is called with a report ID.
+func (p *progress) first(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.seen[id] {
+		return false
+	}
+	p.seen[id] = true
+	return true
+}
+
+// add counts a processed item, printing the progress every progressEvery
+// items.
+func (p *progress) add(result string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.results[result]++
+	if p.done%progressEvery == 0 {
+		fmt.Printf("Progress: %v\n", p.string())
+	}
+}
+
+func (p *progress) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.string()
+}
+
+func (p *progress) string() string {
+	done := fmt.Sprint(p.done)
+	if p.total > 0 {
+		done += fmt.Sprintf(" of %v", p.total)
+	}
+	var counts []string
+	for _, r := range p.order {
+		counts = append(counts, fmt.Sprintf("%v %v", p.results[r], r))
+	}
+	return fmt.Sprintf("%v %v: %v", done, p.noun, strings.Join(counts, ", "))
+}
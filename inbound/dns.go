@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/ericdaugherty/dmarc/dnscheck"
+	"github.com/ericdaugherty/dmarc/report"
+)
+
+var dnsChecker *dnscheck.Checker
+
+// dnsCheckTableName is the table holding the last DNS findings for each
+// domain. DNS findings are only included in notifications when it is set.
+var dnsCheckTableName string
+
+// dnsCheckTimeout bounds the DNS check made for each report, so a slow or
+// unreachable name server does not hold up the notification.
+const dnsCheckTimeout = 10 * time.Second
+
+// recordFindingsFunc records the DNS findings for a domain, returning the
+// previous findings and true if they differ.
+var recordFindingsFunc = recordFindings
+
+// checkDNS checks the live DNS records for the domain a report covers and
+// returns a description of any problems found, including any difference
+// between the policy the reporter saw and the one currently published. ok is
+// false if the check did not finish in time, in which case nothing is known.
+func checkDNS(ctx context.Context, f report.Feedback) (message string, ok bool) {
+	if dnsChecker == nil || f.PolicyPublished.Domain == "" {
+		return "", false
+	}
+
+	in := dnscheck.Input{
+		Domain: f.PolicyPublished.Domain,
+		Published: &dnscheck.Policy{
+			Domain: f.PolicyPublished.Domain,
+			Adkim:  f.PolicyPublished.Adkim,
+			Aspf:   f.PolicyPublished.Aspf,
+			P:      f.PolicyPublished.P,
+			Sp:     f.PolicyPublished.Sp,
+			Pct:    f.PolicyPublished.Pct,
+			Fo:     f.PolicyPublished.Fo,
+		},
+	}
+	for _, record := range f.Record {
+		for _, dkim := range record.AuthResults.Dkim {
+			in.Selectors = append(in.Selectors, dnscheck.Selector{Domain: dkim.Domain, Selector: dkim.Selector})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dnsCheckTimeout)
+	defer cancel()
+	res := dnsChecker.Check(ctx, in)
+	if ctx.Err() != nil {
+		fmt.Printf("DNS check for %v did not finish in %v, skipping.\n", in.Domain, dnsCheckTimeout)
+		return "", false
+	}
+	if !res.HasIssues() {
+		return "", true
+	}
+
+	for _, line := range res.Summary() {
+		fmt.Printf("DNS check: %v\n", line)
+		message += line + "\n"
+	}
+	return message, true
+}
+
+// dnsNotice returns the DNS findings for the domain of a report to include
+// in its notification, if they changed since they were last recorded. A
+// domain with a lasting problem is reported once rather than with every
+// report.
+func dnsNotice(ctx context.Context, f report.Feedback) string {
+	if dnsCheckTableName == "" {
+		return ""
+	}
+	findings, ok := checkDNS(ctx, f)
+	if !ok {
+		return ""
+	}
+
+	domain := f.PolicyPublished.Domain
+	previous, changed, err := recordFindingsFunc(ctx, domain, findings)
+	if err != nil {
+		fmt.Printf("Error recording DNS findings for %v. %v\n", domain, err)
+		return ""
+	}
+	switch {
+	case !changed:
+		return ""
+	case findings != "":
+		return fmt.Sprintf("DNS records for %v have issues.\n\n%v", domain, findings)
+	case previous != "":
+		return fmt.Sprintf("DNS records for %v no longer have issues.\n", domain)
+	}
+	return ""
+}
+
+// recordFindings stores the findings for domain unless they are the ones
+// already stored. The condition makes the check and the update atomic, so
+// only one of several reports processed at once sees the change.
+func recordFindings(ctx context.Context, domain, findings string) (previous string, changed bool, err error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return
+	}
+
+	out, err := dynamodb.NewFromConfig(cfg).UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(dnsCheckTableName),
+		Key: map[string]dbtypes.AttributeValue{
+			"domain": &dbtypes.AttributeValueMemberS{Value: domain},
+		},
+		UpdateExpression:    aws.String("SET findings = :findings, checkedAt = :now"),
+		ConditionExpression: aws.String("attribute_not_exists(findings) OR findings <> :findings"),
+		ExpressionAttributeValues: map[string]dbtypes.AttributeValue{
+			":findings": &dbtypes.AttributeValueMemberS{Value: findings},
+			":now":      &dbtypes.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+		ReturnValues: dbtypes.ReturnValueUpdatedOld,
+	})
+	if isDuplicate(err) {
+		return findings, false, nil
+	}
+	if err != nil {
+		return
+	}
+	if v, ok := out.Attributes["findings"].(*dbtypes.AttributeValueMemberS); ok {
+		previous = v.Value
+	}
+	return previous, true, nil
+}
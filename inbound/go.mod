@@ -11,6 +11,10 @@ require (
 	github.com/aws/aws-sdk-go-v2/service/dynamodb v1.15.5
 	github.com/aws/aws-sdk-go-v2/service/s3 v1.26.10
 	github.com/aws/aws-sdk-go-v2/service/ses v1.14.6
+	github.com/emersion/go-msgauth v0.6.6
+	github.com/ericdaugherty/dmarc/dnscheck v0.0.0-00010101000000-000000000000
+	github.com/ericdaugherty/dmarc/report v0.0.0-00010101000000-000000000000
+	github.com/prometheus/client_golang v1.14.0
 )
 
 require (
@@ -30,6 +34,25 @@ require (
 	github.com/aws/aws-sdk-go-v2/service/sso v1.11.7 // indirect
 	github.com/aws/aws-sdk-go-v2/service/sts v1.16.6 // indirect
 	github.com/aws/smithy-go v1.11.2 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/ericdaugherty/dmarc/dmarcrecord v0.0.0-00010101000000-000000000000 // indirect
+	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/prometheus/client_model v0.3.0 // indirect
+	github.com/prometheus/common v0.37.0 // indirect
+	github.com/prometheus/procfs v0.8.0 // indirect
+	golang.org/x/crypto v0.0.0-20220518034528-6f7dac969898 // indirect
+	golang.org/x/net v0.7.0 // indirect
+	golang.org/x/sys v0.5.0 // indirect
+	google.golang.org/protobuf v1.28.1 // indirect
 	gopkg.in/yaml.v3 v3.0.0 // indirect
 )
+
+replace (
+	github.com/ericdaugherty/dmarc/dmarcrecord => ../dmarcrecord
+	github.com/ericdaugherty/dmarc/dnscheck => ../dnscheck
+	github.com/ericdaugherty/dmarc/report => ../report
+)
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// importItem is one email to import. Emails from S3 keep their bucket and
+// key, which are stored with the report.
+type importItem struct {
+	name   string
+	bucket string
+	key    string
+	load   func(ctx context.Context) ([]byte, error)
+}
+
+// runImport stores the reports in a backlog of report emails, from a
+// directory, an mbox file or an S3 prefix, as if they had just arrived. No
+// notifications are sent.
+func runImport(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dir := flags.String("dir", "", "import every file under a directory as a report email")
+	mbox := flags.String("mbox", "", "import the emails in an mbox file")
+	prefix := flags.String("s3", "", "import the emails under an S3 prefix, s3://bucket/prefix")
+	workers := flags.Int("workers", 4, "number of emails to import at once")
+	skipAuth := flags.Bool("skip-auth", false, "store the reports without authenticating their emails against today's DNS")
+	flags.Parse(args)
+
+	sources := 0
+	for _, s := range []string{*dir, *mbox, *prefix} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 || flags.NArg() != 0 {
+		flags.Usage()
+		return errors.New("expected one of -dir, -mbox or -s3")
+	}
+	if *workers < 1 {
+		return errors.New("workers must be at least 1")
+	}
+
+	p := newProgress("emails", resultStored, resultDuplicate, resultQuarantined, resultRejected, resultFailed)
+	err := runTasks(ctx, *workers, p, func(run func(task) error) error {
+		send := func(item importItem) error {
+			return run(func(ctx context.Context) string { return importEmail(ctx, item, *skipAuth, p) })
+		}
+		switch {
+		case *dir != "":
+			return importDir(*dir, p, send)
+		case *mbox != "":
+			return importMbox(*mbox, send)
+		default:
+			return importS3(ctx, *prefix, p, send)
+		}
+	})
+
+	fmt.Printf("Imported %v\n", p)
+	return err
+}
+
+// importEmail stores the report in one email, unless another email of the
+// import already had it. With skipAuth the email is recorded as not checked
+// rather than authenticated.
+func importEmail(ctx context.Context, item importItem, skipAuth bool, p *progress) string {
+	fmt.Printf("Importing %v\n", item.name)
+	raw, err := item.load(ctx)
+	if err != nil {
+		fmt.Printf("Error importing %v. %v\n", item.name, err)
+		reportsTotal.WithLabelValues(resultFailed).Inc()
+		return resultFailed
+	}
+
+	f, fd, err := readReport(raw)
+	if err != nil {
+		return resultFailed
+	}
+	if !p.first(f.ReportMetadata.OrgName + ":" + f.ReportMetadata.ReportID) {
+		fmt.Printf("Report %v from %v already imported, skipping.\n", f.ReportMetadata.ReportID, f.ReportMetadata.OrgName)
+		reportsTotal.WithLabelValues(resultDuplicate).Inc()
+		return resultDuplicate
+	}
+
+	auth := emailAuth{Result: authNone, Detail: "not checked (import)"}
+	if !skipAuth {
+		auth = authenticateEmail(ctx, raw, f)
+	}
+	result, _ := storeEmail(ctx, f, fd, item.bucket, item.key, auth)
+	return result
+}
+
+// importDir sends every regular file under dir, in lexical order.
+func importDir(dir string, p *progress, send func(importItem) error) error {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			paths = append(paths, path)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	p.setTotal(len(paths))
+	for _, path := range paths {
+		path := path
+		item := importItem{name: path, load: func(context.Context) ([]byte, error) { return ioutil.ReadFile(path) }}
+		if err := send(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importMbox sends each email in an mbox file as it is read.
+func importMbox(name string, send func(importItem) error) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return readMbox(file, func(n int, msg []byte) error {
+		return send(importItem{
+			name: fmt.Sprintf("%v message %v", name, n),
+			load: func(context.Context) ([]byte, error) { return msg, nil },
+		})
+	})
+}
+
+// readMbox calls fn with each message in an mbox, numbered from 1. The From
+// line starting each message is dropped, and the quoting of From lines in
+// the body is undone.
+func readMbox(r io.Reader, fn func(n int, msg []byte) error) error {
+	br := bufio.NewReader(r)
+	var msg bytes.Buffer
+	n := 0
+	started := false
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		switch {
+		case bytes.HasPrefix(line, []byte("From ")):
+			if started {
+				n++
+				if err := fn(n, append([]byte(nil), msg.Bytes()...)); err != nil {
+					return err
+				}
+				msg.Reset()
+			}
+			started = true
+		case started:
+			if unquoted := bytes.TrimLeft(line, ">"); len(unquoted) < len(line) && bytes.HasPrefix(unquoted, []byte("From ")) {
+				line = line[1:]
+			}
+			msg.Write(line)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if !started {
+		return errors.New("not an mbox file, no From line found")
+	}
+	return fn(n+1, msg.Bytes())
+}
+
+// importS3 sends every object under an s3://bucket/prefix URL, once they
+// have all been listed.
+func importS3(ctx context.Context, prefix string, p *progress, send func(importItem) error) error {
+	u, err := url.Parse(prefix)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return fmt.Errorf("invalid S3 prefix %q, expected s3://bucket/prefix", prefix)
+	}
+	bucket := u.Host
+	keyPrefix := strings.TrimPrefix(u.Path, "/")
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	pages := s3.NewListObjectsV2Paginator(s3.NewFromConfig(cfg), &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(keyPrefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, o := range page.Contents {
+			if key := aws.ToString(o.Key); !strings.HasSuffix(key, "/") {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	p.setTotal(len(keys))
+	for _, key := range keys {
+		key := key
+		item := importItem{name: "s3://" + bucket + "/" + key, bucket: bucket, key: key,
+			load: func(ctx context.Context) ([]byte, error) { return getEmailFunc(ctx, bucket, key) }}
+		if err := send(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
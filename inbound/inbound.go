@@ -1,22 +1,20 @@
 package main
 
 import (
-	"archive/zip"
 	"bytes"
-	"compress/gzip"
 	"context"
-	"encoding/xml"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
+	"net"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/DusanKasan/parsemail"
+	"github.com/ericdaugherty/dmarc/dnscheck"
+	"github.com/ericdaugherty/dmarc/report"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -24,19 +22,22 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/ses"
 	"github.com/aws/aws-sdk-go-v2/service/ses/types"
 )
 
-var getEmailFunc func(context.Context, string, string) (*parsemail.Email, error)
+var getEmailFunc func(context.Context, string, string) ([]byte, error)
 var dynamoDBTableName string
+var aggregateTableName string
 var mailFrom string
 var mailTo string
 
 type dbEntry struct {
 	GMTDate          string `json:"gmtDate"`
 	OrgReportID      string `json:"orgReportId"`
+	Domain           string `json:"domain"`
 	S3Bucket         string `json:"s3bucket"`
 	S3Key            string `json:"s3key"`
 	OrgName          string `json:"orgName"`
@@ -46,7 +47,13 @@ type dbEntry struct {
 	CountAccepted    int    `json:"countAccepted"`
 	CountQuarantined int    `json:"countQuarantined"`
 	CountRejected    int    `json:"countRejected"`
+	CountPass        int    `json:"countPass"`
+	CountDKIMPass    int    `json:"countDkimPass"`
+	CountSPFPass     int    `json:"countSpfPass"`
 	XML              string `json:"xml"`
+	AuthResult       string `json:"authResult"`
+	AuthDetail       string `json:"authDetail"`
+	Quarantined      bool   `json:"quarantined"`
 }
 
 func handler(ctx context.Context, s3Event events.S3Event) {
@@ -56,127 +63,154 @@ func handler(ctx context.Context, s3Event events.S3Event) {
 	for _, record := range s3Event.Records {
 		s3 := record.S3
 		fmt.Printf("Processing email from bucket: %v with key: %v\n", s3.Bucket.Name, s3.Object.Key)
-		msg, err := getEmailFunc(ctx, s3.Bucket.Name, s3.Object.Key)
-		if err != nil {
-			fmt.Printf("Error processing email. Unable to load from S3. %v\n", err)
+		start := time.Now()
+		ok := processEmail(ctx, s3.Bucket.Name, s3.Object.Key)
+		processingSeconds.Observe(time.Since(start).Seconds())
+		if !ok {
 			return
 		}
+	}
+}
 
-		fd, err := decodeAttachment(msg)
-		if err != nil {
-			fmt.Printf("Error processing email. Unable to decode attachment. %v\n", err)
-			return
-		}
+// processEmail processes the report email stored at key in bucket. It returns
+// false if the email could not be read as a report, which stops the rest of
+// the event being processed.
+func processEmail(ctx context.Context, bucket, key string) bool {
+	raw, err := getEmailFunc(ctx, bucket, key)
+	if err != nil {
+		fmt.Printf("Error processing email. Unable to load from S3. %v\n", err)
+		reportsTotal.WithLabelValues(resultFailed).Inc()
+		return false
+	}
 
-		f, err := decodeXML(fd)
-		if err != nil {
-			fmt.Printf("Error processing email. Unable to decode XML. %v\n", err)
-			return
-		}
+	f, fd, err := readReport(raw)
+	if err != nil {
+		return false
+	}
 
-		err = storeReport(ctx, s3.Bucket.Name, s3.Object.Key, f, fd)
-		if err != nil {
-			fmt.Printf("Error processing email. Unable to process report data. %v\n", err)
-		}
+	_, notify := storeEmail(ctx, f, fd, bucket, key, authenticateEmail(ctx, raw, f))
+	if !notify {
+		return true
+	}
 
-		err = sendNotification(ctx, f)
-		if err != nil {
-			fmt.Printf("Error processing email. Unable to process report data. %v\n", err)
-		}
+	err = sendNotification(ctx, f, dnsNotice(ctx, f))
+	if err != nil {
+		fmt.Printf("Error processing email. Unable to process report data. %v\n", err)
+		notificationFailures.Inc()
 	}
+	return true
 }
 
-func getMailFromS3(ctx context.Context, bucket string, key string) (m *parsemail.Email, err error) {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+// readReport decodes the report attached to a raw report email. Failures are
+// logged and counted.
+func readReport(raw []byte) (f report.Feedback, fd []byte, err error) {
+	msg, err := parsemail.Parse(bytes.NewReader(raw))
 	if err != nil {
+		fmt.Printf("Error processing email. Unable to parse email. %v\n", err)
+		parseFailed("email")
 		return
 	}
 
-	svc := s3.NewFromConfig(cfg)
-
-	resp, err := svc.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
+	fd, err = decodeAttachment(&msg)
 	if err != nil {
+		fmt.Printf("Error processing email. Unable to decode attachment. %v\n", err)
+		parseFailed("attachment")
 		return
 	}
-	defer resp.Body.Close()
 
-	pm, e := parsemail.Parse(resp.Body)
-	return &pm, e
+	f, err = decodeXML(fd)
+	if err != nil {
+		fmt.Printf("Error processing email. Unable to decode XML. %v\n", err)
+		parseFailed("xml")
+	}
+	return
 }
 
-func decodeAttachment(msg *parsemail.Email) (res []byte, err error) {
-
-	var content []byte
-	ct := msg.ContentType
-	ct = strings.Fields(ct)[0]
-	switch ct {
-	case "application/zip;":
-		// parsemail will decode the file for us.
-		content, _ = ioutil.ReadAll(msg.Content)
-		return unzip(content)
-	case "multipart/mixed;":
-		for _, f := range msg.Attachments {
-			ext := filepath.Ext(f.Filename)
-			switch ext {
-			case ".gz":
-				return ungzip(f.Data)
-			default:
-				return res, errors.New("unknown file extension " + ext)
-			}
+// storeEmail stores a report, unless auth, the result of authenticating the
+// email it arrived in, has it rejected. It returns the result it was counted
+// under, and whether a notification should be sent for it.
+func storeEmail(ctx context.Context, f report.Feedback, fd []byte, bucket, key string, auth emailAuth) (result string, notify bool) {
+	fmt.Printf("Report email authentication: %v %v\n", auth.Result, auth.Detail)
+	quarantined := false
+	if auth.Result == authFail {
+		switch unauthenticatedAction {
+		case actionReject:
+			fmt.Printf("Rejecting unauthenticated report %v from %v.\n", f.ReportMetadata.ReportID, f.ReportMetadata.OrgName)
+			reportsTotal.WithLabelValues(resultRejected).Inc()
+			return resultRejected, false
+		case actionQuarantine:
+			fmt.Printf("Quarantining unauthenticated report %v from %v.\n", f.ReportMetadata.ReportID, f.ReportMetadata.OrgName)
+			quarantined = true
 		}
-	default:
-		return res, errors.New("unknown content type " + ct)
 	}
 
-	return res, errors.New("no reports found in email")
+	duplicate, err := storeReportFunc(ctx, bucket, key, f, fd, auth, quarantined)
+	switch {
+	case err != nil:
+		fmt.Printf("Error processing email. Unable to process report data. %v\n", err)
+		result = resultFailed
+	case duplicate:
+		result = resultDuplicate
+	case quarantined:
+		result = resultQuarantined
+	default:
+		result = resultStored
+	}
+	reportsTotal.WithLabelValues(result).Inc()
+	// Only a newly stored report is notified, so a redelivered email does not
+	// send its notification again.
+	return result, result == resultStored
 }
 
-func unzip(data []byte) (b []byte, err error) {
-	r := bytes.NewReader(data)
-	zr, err := zip.NewReader(r, int64(r.Len()))
+func getMailFromS3(ctx context.Context, bucket string, key string) (raw []byte, err error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
 	if err != nil {
 		return
 	}
 
-	for _, f := range zr.File {
-		if strings.Contains(f.Name, ".xml") {
-			var zf io.ReadCloser
-			zf, err = f.Open()
-			if err != nil {
-				return
-			}
-			return ioutil.ReadAll(zf)
-		}
-	}
-
-	return b, errors.New("no xml file found in zip data")
-}
+	svc := s3.NewFromConfig(cfg)
 
-func ungzip(r io.Reader) (b []byte, err error) {
-	zr, err := gzip.NewReader(r)
+	resp, err := svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
 	if err != nil {
 		return
 	}
+	defer resp.Body.Close()
 
-	return ioutil.ReadAll(zr)
+	return ioutil.ReadAll(resp.Body)
 }
 
-func decodeXML(data []byte) (f Feedback, err error) {
-	err = xml.Unmarshal(data, &f)
-	return
+func decodeAttachment(msg *parsemail.Email) ([]byte, error) {
+	return report.DecodeAttachment(msg)
+}
+
+func decodeXML(data []byte) (f report.Feedback, err error) {
+	return report.Parse(data)
 }
 
-func storeReport(ctx context.Context, s3Bucket, s3Key string, f Feedback, fd []byte) (err error) {
+// newDBEntry returns the stored form of a report.
+func newDBEntry(s3Bucket, s3Key string, f report.Feedback, fd []byte, auth emailAuth, quarantined bool) (entry dbEntry, err error) {
 
 	var countAccepted, countQuarantined, countRejected int
+	var countPass, countDKIMPass, countSPFPass int
 	for _, record := range f.Record {
 		c, err := strconv.Atoi(record.Row.Count)
 		if err != nil {
 			c = 1
 		}
+		dkimPass := record.Row.PolicyEvaluated.Dkim == "pass"
+		spfPass := record.Row.PolicyEvaluated.Spf == "pass"
+		if dkimPass {
+			countDKIMPass += c
+		}
+		if spfPass {
+			countSPFPass += c
+		}
+		if dkimPass || spfPass {
+			countPass += c
+		}
 		switch record.Row.PolicyEvaluated.Disposition {
 		case "quarantine":
 			countQuarantined += c
@@ -201,9 +235,10 @@ func storeReport(ctx context.Context, s3Bucket, s3Key string, f Feedback, fd []b
 
 	unixBeginTime := time.Unix(int64(beginTime), 0).UTC()
 
-	entry := dbEntry{
+	entry = dbEntry{
 		GMTDate:          unixBeginTime.Format("2006-01-02"),
 		OrgReportID:      f.ReportMetadata.OrgName + ":" + f.ReportMetadata.ReportID,
+		Domain:           f.PolicyPublished.Domain,
 		S3Bucket:         s3Bucket,
 		S3Key:            s3Key,
 		OrgName:          f.ReportMetadata.OrgName,
@@ -213,7 +248,28 @@ func storeReport(ctx context.Context, s3Bucket, s3Key string, f Feedback, fd []b
 		CountAccepted:    countAccepted,
 		CountQuarantined: countQuarantined,
 		CountRejected:    countRejected,
+		CountPass:        countPass,
+		CountDKIMPass:    countDKIMPass,
+		CountSPFPass:     countSPFPass,
 		XML:              string(fd),
+		AuthResult:       auth.Result,
+		AuthDetail:       auth.Detail,
+		Quarantined:      quarantined,
+	}
+	return
+}
+
+// storeReportFunc stores a report, returning true for duplicate if it was
+// already stored.
+var storeReportFunc = storeReport
+
+// storeReport stores the report and adds it to the aggregates. It returns true
+// for duplicate if the report was already stored.
+func storeReport(ctx context.Context, s3Bucket, s3Key string, f report.Feedback, fd []byte, auth emailAuth, quarantined bool) (duplicate bool, err error) {
+
+	entry, err := newDBEntry(s3Bucket, s3Key, f, fd, auth, quarantined)
+	if err != nil {
+		return
 	}
 
 	cfg, err := config.LoadDefaultConfig(context.TODO())
@@ -227,17 +283,103 @@ func storeReport(ctx context.Context, s3Bucket, s3Key string, f Feedback, fd []b
 		return
 	}
 
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(dynamoDBTableName),
+	// The condition makes storing the same report twice a no-op, so the
+	// aggregate counters are only incremented once per report.
+	put := &dbtypes.Put{
+		Item:                av,
+		TableName:           aws.String(dynamoDBTableName),
+		ConditionExpression: aws.String("attribute_not_exists(orgReportId)"),
+	}
+
+	if aggregateTableName == "" || quarantined {
+		_, err = svc.PutItem(ctx, &dynamodb.PutItemInput{
+			Item:                put.Item,
+			TableName:           put.TableName,
+			ConditionExpression: put.ConditionExpression,
+		})
+	} else {
+		_, err = svc.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: []dbtypes.TransactWriteItem{
+				{Put: put},
+				{Update: aggregateUpdate(entry)},
+			},
+		})
 	}
 
-	_, err = svc.PutItem(ctx, input)
+	if isDuplicate(err) {
+		fmt.Printf("Report %v for %v already stored, skipping.\n", entry.OrgReportID, entry.GMTDate)
+		duplicate, err = true, nil
+	}
 
 	return
 }
 
-func sendNotification(ctx context.Context, f Feedback) (err error) {
+// aggregateKey is the range key of the aggregate row for a domain and
+// reporting organization.
+func aggregateKey(domain, orgName string) string {
+	return domain + "#" + orgName
+}
+
+// aggregateUpdate adds the counts of a report to the aggregate row for its
+// day, domain and reporting organization, creating the row if needed.
+func aggregateUpdate(entry dbEntry) *dbtypes.Update {
+	return aggregateChange(entry, 1, entry)
+}
+
+// aggregateChange adds reports, and the counts of delta, to the aggregate row
+// for the day, domain and reporting organization of entry. Negative values
+// take them away.
+func aggregateChange(entry dbEntry, reports int, delta dbEntry) *dbtypes.Update {
+	n := func(i int) dbtypes.AttributeValue {
+		return &dbtypes.AttributeValueMemberN{Value: strconv.Itoa(i)}
+	}
+
+	return &dbtypes.Update{
+		TableName: aws.String(aggregateTableName),
+		Key: map[string]dbtypes.AttributeValue{
+			"gmtDate":      &dbtypes.AttributeValueMemberS{Value: entry.GMTDate},
+			"aggregateKey": &dbtypes.AttributeValueMemberS{Value: aggregateKey(entry.Domain, entry.OrgName)},
+		},
+		UpdateExpression: aws.String("SET #domain = :domain, orgName = :org " +
+			"ADD reports :reports, countAccepted :accepted, countQuarantined :quarantined, countRejected :rejected, " +
+			"countPass :pass, countDkimPass :dkimPass, countSpfPass :spfPass"),
+		ExpressionAttributeNames: map[string]string{"#domain": "domain"},
+		ExpressionAttributeValues: map[string]dbtypes.AttributeValue{
+			":domain":      &dbtypes.AttributeValueMemberS{Value: entry.Domain},
+			":org":         &dbtypes.AttributeValueMemberS{Value: entry.OrgName},
+			":reports":     n(reports),
+			":accepted":    n(delta.CountAccepted),
+			":quarantined": n(delta.CountQuarantined),
+			":rejected":    n(delta.CountRejected),
+			":pass":        n(delta.CountPass),
+			":dkimPass":    n(delta.CountDKIMPass),
+			":spfPass":     n(delta.CountSPFPass),
+		},
+	}
+}
+
+// isDuplicate returns true if err is caused by the condition that stops a
+// report being stored twice.
+func isDuplicate(err error) bool {
+	var condErr *dbtypes.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return true
+	}
+
+	var txErr *dbtypes.TransactionCanceledException
+	if errors.As(err, &txErr) {
+		for _, r := range txErr.CancellationReasons {
+			if aws.ToString(r.Code) == "ConditionalCheckFailed" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sendNotification emails the records of a report that were quarantined or
+// rejected, and dnsNotice if it is not empty.
+func sendNotification(ctx context.Context, f report.Feedback, dnsNotice string) (err error) {
 
 	message := ""
 
@@ -256,15 +398,25 @@ func sendNotification(ctx context.Context, f Feedback) (err error) {
 		}
 	}
 
+	body := ""
 	if message != "" {
-		body := fmt.Sprintf("Processed Records with issues.\n\n%v", message)
+		body = fmt.Sprintf("Processed Records with issues.\n\n%v", message)
+	}
+	if dnsNotice != "" {
+		if body != "" {
+			body += "\n"
+		}
+		body += dnsNotice
+	}
+
+	if body != "" {
 		return sendEmail(ctx, "DMARC Issues Detected", body)
 	}
 
 	return nil
 }
 
-func formatEmailMessage(f Feedback, i int) string {
+func formatEmailMessage(f report.Feedback, i int) string {
 	message := "%v email%v from: %v to: %v %v processed by %v and was marked %v.\n"
 
 	r := f.Record[i]
@@ -310,10 +462,48 @@ func sendEmail(ctx context.Context, subject string, body string) (err error) {
 func main() {
 
 	getEmailFunc = getMailFromS3
+	dnsChecker = dnscheck.New(nil)
+	authResolver = net.DefaultResolver
 
 	dynamoDBTableName = os.Getenv("TABLENAME")
+	aggregateTableName = os.Getenv("AGGREGATETABLENAME")
+	dnsCheckTableName = os.Getenv("DNSCHECKTABLENAME")
 	mailFrom = os.Getenv("MAILFROM")
 	mailTo = os.Getenv("MAILTO")
+	if action := os.Getenv("UNAUTHENTICATED"); action != "" {
+		if !validAction(action) {
+			fmt.Printf("Invalid UNAUTHENTICATED %q, expected accept, quarantine or reject.\n", action)
+			os.Exit(1)
+		}
+		unauthenticatedAction = action
+	}
+	authServID = os.Getenv("AUTHSERV_ID")
+	eventsToken = os.Getenv("EVENTS_TOKEN")
+	eventsBucket = os.Getenv("EVENTS_BUCKET")
+
+	commands := map[string]func(context.Context, []string) error{
+		"aggregate": runAggregate,
+		"import":    runImport,
+		"reprocess": runReprocess,
+	}
+	if len(os.Args) > 1 && commands[os.Args[1]] != nil {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err := commands[os.Args[1]](ctx, os.Args[2:])
+		stop()
+		if err != nil {
+			fmt.Printf("Error running %v. %v\n", os.Args[1], err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if addr := os.Getenv("LISTEN"); addr != "" {
+		if err := serve(addr); err != nil {
+			fmt.Printf("Error serving on %v. %v\n", addr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	lambda.Start(handler)
 }
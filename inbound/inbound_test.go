@@ -1,13 +1,32 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/DusanKasan/parsemail"
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	dbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/ericdaugherty/dmarc/dnscheck"
+	"github.com/ericdaugherty/dmarc/dnscheck/dnstest"
+	"github.com/ericdaugherty/dmarc/report"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestS3KeyParsing(t *testing.T) {
@@ -22,11 +41,10 @@ func TestS3KeyParsing(t *testing.T) {
 	requestedKey := ""
 
 	getEmailFunc =
-		func(_ context.Context, bucket string, key string) (*parsemail.Email, error) {
+		func(_ context.Context, bucket string, key string) ([]byte, error) {
 			requestedBucket = bucket
 			requestedKey = key
-			m, err := parsemail.Parse(strings.NewReader(simpleEmailS3))
-			return &m, err
+			return []byte(simpleEmailS3), nil
 		}
 	handler(context.Background(), event)
 
@@ -154,13 +172,167 @@ func TestZipXMLAttachment(t *testing.T) {
 		return
 	}
 
-	getEmailFunc = func(_ context.Context, bucket string, key string) (*parsemail.Email, error) {
-		m, err := parsemail.Parse(strings.NewReader(googleSampleZipped))
-		return &m, err
+	getEmailFunc = func(_ context.Context, bucket string, key string) ([]byte, error) {
+		return []byte(googleSampleZipped), nil
 	}
 	handler(context.Background(), event)
 }
 
+func TestProcessingMetrics(t *testing.T) {
+
+	event, err := getEvent(simpleEmailS3Event)
+	if err != nil {
+		t.Errorf("Error parsing JSON into S3Event. %v", err)
+		return
+	}
+
+	failed := testutil.ToFloat64(reportsTotal.WithLabelValues(resultFailed))
+	attachment := testutil.ToFloat64(parseFailures.WithLabelValues("attachment"))
+
+	getEmailFunc = func(_ context.Context, bucket string, key string) ([]byte, error) {
+		return []byte("Content-Type: text/plain\r\n\r\nNo report here.\r\n"), nil
+	}
+	handler(context.Background(), event)
+
+	if got := testutil.ToFloat64(parseFailures.WithLabelValues("attachment")); got != attachment+1 {
+		t.Errorf("Expected %v but got %v", attachment+1, got)
+	}
+	if got := testutil.ToFloat64(reportsTotal.WithLabelValues(resultFailed)); got != failed+1 {
+		t.Errorf("Expected %v but got %v", failed+1, got)
+	}
+}
+
+func TestServeEvents(t *testing.T) {
+	var fetched []string
+	getEmailFunc = func(_ context.Context, bucket string, key string) ([]byte, error) {
+		fetched = append(fetched, bucket+"/"+key)
+		return []byte("Content-Type: text/plain\r\n\r\nNo report here.\r\n"), nil
+	}
+	event := func(bucket string) string {
+		return `{"Records":[{"s3":{"bucket":{"name":"` + bucket + `"},"object":{"key":"email"}}}]}`
+	}
+
+	eventsToken, eventsBucket = "", ""
+	r := httptest.NewRequest("POST", "/events", strings.NewReader(event("reports")))
+	w := httptest.NewRecorder()
+	serveMux().ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected %v without a token configured but got %v", http.StatusNotFound, w.Code)
+	}
+
+	eventsToken, eventsBucket = "secret", "reports"
+	defer func() { eventsToken, eventsBucket = "", "" }()
+	tests := []struct {
+		name, auth, bucket string
+		status             int
+	}{
+		{"no token", "", "reports", http.StatusUnauthorized},
+		{"wrong token", "Bearer guess", "reports", http.StatusUnauthorized},
+		{"token without scheme", "secret", "reports", http.StatusUnauthorized},
+		{"other bucket", "Bearer secret", "private", http.StatusForbidden},
+		{"configured bucket", "Bearer secret", "reports", http.StatusNoContent},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/events", strings.NewReader(event(test.bucket)))
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+		serveMux().ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%v: Expected %v but got %v", test.name, test.status, w.Code)
+		}
+	}
+	if fmt.Sprint(fetched) != "[reports/email]" {
+		t.Errorf("Expected only the configured bucket to be read but got %v", fetched)
+	}
+}
+
+func TestReadMbox(t *testing.T) {
+	mbox := "From a@example.com Sat Apr 18 16:55:30 2020\n" +
+		"Subject: one\n\n>From the start\n>>From quoted twice\n\n" +
+		"From b@example.com Sat Apr 18 16:56:30 2020\n" +
+		"Subject: two\n\nbody\n"
+
+	var msgs []string
+	err := readMbox(strings.NewReader(mbox), func(n int, msg []byte) error {
+		if n != len(msgs)+1 {
+			t.Errorf("Expected %v but got %v", len(msgs)+1, n)
+		}
+		msgs = append(msgs, string(msg))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading mbox: %v", err)
+	}
+
+	expected := []string{"Subject: one\n\nFrom the start\n>From quoted twice\n\n", "Subject: two\n\nbody\n"}
+	if fmt.Sprint(msgs) != fmt.Sprint(expected) {
+		t.Errorf("Expected %q but got %q", expected, msgs)
+	}
+
+	if err := readMbox(strings.NewReader("Subject: not an mbox\n"), func(int, []byte) error { return nil }); err == nil {
+		t.Errorf("Expected an error for a file without a From line")
+	}
+}
+
+func TestStoreEmailNotify(t *testing.T) {
+	defer func() { storeReportFunc = storeReport }()
+	var f report.Feedback
+
+	tests := []struct {
+		duplicate bool
+		err       error
+		auth      string
+		result    string
+		notify    bool
+	}{
+		{false, nil, authPass, resultStored, true},
+		{true, nil, authPass, resultDuplicate, false},
+		{false, errors.New("throttled"), authPass, resultFailed, false},
+		{false, nil, authFail, resultQuarantined, false},
+	}
+	unauthenticatedAction = actionQuarantine
+	defer func() { unauthenticatedAction = actionAccept }()
+	for _, test := range tests {
+		storeReportFunc = func(context.Context, string, string, report.Feedback, []byte, emailAuth, bool) (bool, error) {
+			return test.duplicate, test.err
+		}
+		result, notify := storeEmail(context.Background(), f, nil, "", "", emailAuth{Result: test.auth})
+		if result != test.result || notify != test.notify {
+			t.Errorf("Expected %v and notify %v but got %v and %v", test.result, test.notify, result, notify)
+		}
+	}
+}
+
+func TestImportMalformed(t *testing.T) {
+	mbox := filepath.Join(t.TempDir(), "reports.mbox")
+	content := "From a@example.com Sat Apr 18 16:55:30 2020\n" +
+		"Subject: no content type\n\nNo report here.\n" +
+		"From b@example.com Sat Apr 18 16:56:30 2020\n" +
+		"Subject: not a report\nContent-Type: image/png\n\npng\n"
+	if err := os.WriteFile(mbox, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newProgress("emails", resultStored, resultFailed)
+	err := runTasks(context.Background(), 2, p, func(run func(task) error) error {
+		if err := importMbox(mbox, func(item importItem) error {
+			return run(func(ctx context.Context) string { return importEmail(ctx, item, true, p) })
+		}); err != nil {
+			return err
+		}
+		// A task that panics is counted as failed rather than ending the batch.
+		return run(func(context.Context) string { panic("malformed") })
+	})
+	if err != nil {
+		t.Fatalf("Error importing: %v", err)
+	}
+	if p.results[resultFailed] != 3 || p.results[resultStored] != 0 {
+		t.Errorf("Expected %v failed but got %v", 3, p.results)
+	}
+}
+
 func TestFormatEmailMessage(t *testing.T) {
 
 	f, err := decodeXML([]byte(amazonsesEmailXML))
@@ -185,6 +357,447 @@ func TestFormatEmailMessage(t *testing.T) {
 	}
 }
 
+func TestCheckDNS(t *testing.T) {
+	s, err := dnstest.NewServer(dnstest.Zone{
+		"_dmarc.ericdaugherty.com": {TXT: []string{"v=DMARC1; p=none; rua=mailto:dmarc@dmarc.ericdaugherty.com"}},
+		"ericdaugherty.com":        {TXT: []string{"v=spf1 include:_spf.google.com ~all"}},
+		"_spf.google.com":          {TXT: []string{"v=spf1 ip4:209.85.128.0/17 ~all"}},
+	})
+	if err != nil {
+		t.Fatalf("Error starting DNS server. %v", err)
+	}
+	defer s.Close()
+
+	dnsChecker = dnscheck.New(s.Resolver())
+	defer func() { dnsChecker = nil }()
+
+	f, err := decodeXML([]byte(googleSampleZippedXML))
+	if err != nil {
+		t.Errorf("Error decoding XML: %v", err)
+	}
+
+	value, ok := checkDNS(context.Background(), f)
+	if !ok {
+		t.Errorf("Expected the DNS check to finish")
+	}
+
+	expected := "DKIM google._domainkey.ericdaugherty.com error: no DKIM key found at google._domainkey.ericdaugherty.com\n" +
+		"DMARC policy for ericdaugherty.com: reporter saw p=reject but the live record has p=none\n" +
+		"DMARC policy for ericdaugherty.com: reporter saw sp=reject but the live record has sp=none\n"
+	if value != expected {
+		t.Errorf("Expected \n%v\n but got: \n%v\n", expected, value)
+	}
+}
+
+func TestDNSNotice(t *testing.T) {
+	s, err := dnstest.NewServer(dnstest.Zone{
+		"_dmarc.ericdaugherty.com": {TXT: []string{"v=DMARC1; p=reject; sp=reject; rua=mailto:dmarc@dmarc.ericdaugherty.com"}},
+		"ericdaugherty.com":        {TXT: []string{"v=spf1 include:_spf.google.com ~all"}},
+		"_spf.google.com":          {TXT: []string{"v=spf1 ip4:209.85.128.0/17 ~all"}},
+	})
+	if err != nil {
+		t.Fatalf("Error starting DNS server. %v", err)
+	}
+	defer s.Close()
+
+	dnsChecker = dnscheck.New(s.Resolver())
+	dnsCheckTableName = "dmarcDNSChecks"
+	stored := map[string]string{}
+	recordFindingsFunc = func(_ context.Context, domain, findings string) (string, bool, error) {
+		previous, ok := stored[domain]
+		stored[domain] = findings
+		return previous, !ok || previous != findings, nil
+	}
+	defer func() { dnsChecker, dnsCheckTableName, recordFindingsFunc = nil, "", recordFindings }()
+
+	f, err := decodeXML([]byte(googleSampleZippedXML))
+	if err != nil {
+		t.Errorf("Error decoding XML: %v", err)
+	}
+
+	notice := dnsNotice(context.Background(), f)
+	if !strings.HasPrefix(notice, "DNS records for ericdaugherty.com have issues.") {
+		t.Errorf("Expected the DNS issues but got %q", notice)
+	}
+	if notice = dnsNotice(context.Background(), f); notice != "" {
+		t.Errorf("Expected unchanged issues to be left out but got %q", notice)
+	}
+
+	stored["ericdaugherty.com"] = "DKIM key too short\n"
+	if notice = dnsNotice(context.Background(), f); !strings.HasPrefix(notice, "DNS records for ericdaugherty.com have issues.") {
+		t.Errorf("Expected changed issues to be included but got %q", notice)
+	}
+}
+
+func TestAuthenticateEmail(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key. %v", err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Error encoding key. %v", err)
+	}
+
+	s, err := dnstest.NewServer(dnstest.Zone{
+		"test._domainkey.google.com":    {TXT: []string{"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(pub)}},
+		"test._domainkey.mail.evil.com": {TXT: []string{"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(pub)}},
+	})
+	if err != nil {
+		t.Fatalf("Error starting DNS server. %v", err)
+	}
+	defer s.Close()
+
+	authResolver = s.Resolver()
+	authServID = "amazonses.com"
+	defer func() { authResolver, authServID = nil, "" }()
+
+	f, err := decodeXML([]byte(googleSampleZippedXML))
+	if err != nil {
+		t.Errorf("Error decoding XML: %v", err)
+	}
+
+	sign := func(domain, msg string) []byte {
+		var b bytes.Buffer
+		err := dkim.Sign(&b, strings.NewReader(msg), &dkim.SignOptions{Domain: domain, Selector: "test", Signer: key})
+		if err != nil {
+			t.Fatalf("Error signing message. %v", err)
+		}
+		return b.Bytes()
+	}
+	msg := "From: noreply-dmarc-support@google.com\r\nTo: dmarc@ericdaugherty.com\r\nSubject: Report\r\n\r\nReport\r\n"
+
+	tests := []struct {
+		raw    []byte
+		result string
+	}{
+		{sign("google.com", msg), authPass},
+		{sign("mail.evil.com", msg), authFail},
+		{bytes.Replace(sign("google.com", msg), []byte("Report\r\n"), []byte("Forged\r\n"), 1), authFail},
+		{[]byte(msg), authFail},
+		{[]byte("Authentication-Results: amazonses.com; spf=pass (spfCheck: domain of google.com designates 192.0.2.1 as permitted sender) client-ip=192.0.2.1; envelope-from=noreply@google.com; helo=mail.google.com; dkim=none;\r\n" + msg), authPass},
+		{[]byte("Authentication-Results: amazonses.com; spf=fail client-ip=192.0.2.1; envelope-from=noreply@google.com;\r\n" + msg), authFail},
+		{[]byte("Authentication-Results: mx.google.com; spf=pass smtp.mailfrom=noreply@google.com\r\n" + msg), authFail},
+		{[]byte("Received-SPF: pass (domain of google.com designates 192.0.2.1 as permitted sender) client-ip=192.0.2.1; envelope-from=noreply@google.com;\r\n" + msg), authFail},
+	}
+	for i, test := range tests {
+		a := authenticateEmail(context.Background(), test.raw, f)
+		if a.Result != test.result {
+			t.Errorf("Expected %v but got %v for message %v: %v", test.result, a.Result, i, a.Detail)
+		}
+	}
+
+	// An org_name that is a domain is the reporter, whatever the email says.
+	forged := f
+	forged.ReportMetadata.Email = "dmarc@evil.com"
+	evil := "From: dmarc@evil.com\r\nTo: dmarc@ericdaugherty.com\r\nSubject: Report\r\n\r\nReport\r\n"
+	if a := authenticateEmail(context.Background(), sign("mail.evil.com", evil), forged); a.Result != authFail {
+		t.Errorf("Expected %v for a report from %v signed by evil.com but got %v: %v", authFail, forged.ReportMetadata.OrgName, a.Result, a.Detail)
+	}
+	forged.ReportMetadata.OrgName = "Evil Corp"
+	if a := authenticateEmail(context.Background(), sign("mail.evil.com", evil), forged); a.Result != authPass {
+		t.Errorf("Expected %v for a report from the domain of its email but got %v: %v", authPass, a.Result, a.Detail)
+	}
+
+	authServID = ""
+	if a := authenticateEmail(context.Background(), tests[4].raw, f); a.Result != authFail {
+		t.Errorf("Expected %v without an authserv-id but got %v: %v", authFail, a.Result, a.Detail)
+	}
+}
+
+func TestAggregateUpdate(t *testing.T) {
+	aggregateTableName = "dmarcAggregates"
+	defer func() { aggregateTableName = "" }()
+
+	u := aggregateUpdate(dbEntry{GMTDate: "2020-04-17", Domain: "ericdaugherty.com", OrgName: "google.com", CountAccepted: 3, CountRejected: 1})
+
+	key := u.Key["aggregateKey"].(*dbtypes.AttributeValueMemberS).Value
+	expected := "ericdaugherty.com#google.com"
+	if key != expected {
+		t.Errorf("Expected %v but got %v", expected, key)
+	}
+
+	accepted := u.ExpressionAttributeValues[":accepted"].(*dbtypes.AttributeValueMemberN).Value
+	if accepted != "3" {
+		t.Errorf("Expected %v but got %v", 3, accepted)
+	}
+}
+
+func TestReprocessChanges(t *testing.T) {
+	old := dbEntry{GMTDate: "2020-04-17", OrgReportID: "google.com:1", CountAccepted: 3, CountRejected: 1, XML: "<feedback></feedback>"}
+	entry := old
+	entry.CountPass = 2
+	entry.XML = "<feedback><record></record></feedback>"
+
+	changes := diffEntries(old, entry)
+	expected := []string{"countPass: 0 -> 2", "xml: 0 records in 21 bytes -> 1 records in 38 bytes"}
+	if fmt.Sprint(changes) != fmt.Sprint(expected) {
+		t.Errorf("Expected %q but got %q", expected, changes)
+	}
+	if diffEntries(old, old) != nil {
+		t.Errorf("Expected no changes but got %v", diffEntries(old, old))
+	}
+
+	delta := countDelta(old, entry)
+	if delta != (dbEntry{CountPass: 2}) {
+		t.Errorf("Expected only countPass to change but got %+v", delta)
+	}
+
+	aggregateTableName = "dmarcAggregates"
+	defer func() { aggregateTableName = "" }()
+	u := aggregateChange(entry, 0, delta)
+	if v := u.ExpressionAttributeValues[":reports"].(*dbtypes.AttributeValueMemberN).Value; v != "0" {
+		t.Errorf("Expected %v but got %v", 0, v)
+	}
+	if v := u.ExpressionAttributeValues[":pass"].(*dbtypes.AttributeValueMemberN).Value; v != "2" {
+		t.Errorf("Expected %v but got %v", 2, v)
+	}
+
+	condition, _ := unchangedCondition(old)
+	if !strings.Contains(condition, "countAccepted = :countAccepted") || !strings.Contains(condition, "(attribute_not_exists(countPass) OR countPass = :countPass)") {
+		t.Errorf("Expected conditions on the stored counts but got %v", condition)
+	}
+}
+
+func TestDiffEntries(t *testing.T) {
+	old := dbEntry{GMTDate: "2020-04-17", OrgReportID: "google.com:1", Domain: "example.com", CountAccepted: 3, XML: "<feedback></feedback>"}
+	tests := []struct {
+		change  func(e *dbEntry)
+		changes []string
+	}{
+		{func(e *dbEntry) {}, nil},
+		{func(e *dbEntry) { e.CountAccepted = 4 }, []string{"countAccepted: 3 -> 4"}},
+		{func(e *dbEntry) { e.GMTDate = "2020-04-16"; e.Domain = "example.org" }, []string{"gmtDate: 2020-04-17 -> 2020-04-16", "domain: example.com -> example.org"}},
+		{func(e *dbEntry) { e.Quarantined = true }, []string{"quarantined: false -> true"}},
+		{func(e *dbEntry) { e.XML = "<feedback><record></record><record></record></feedback>" }, []string{"xml: 0 records in 21 bytes -> 2 records in 55 bytes"}},
+	}
+	for _, test := range tests {
+		entry := old
+		test.change(&entry)
+		if changes := diffEntries(old, entry); fmt.Sprint(changes) != fmt.Sprint(test.changes) {
+			t.Errorf("Expected %q but got %q", test.changes, changes)
+		}
+	}
+}
+
+func TestCountDelta(t *testing.T) {
+	tests := []struct {
+		old, entry, delta dbEntry
+	}{
+		{dbEntry{}, dbEntry{}, dbEntry{}},
+		{dbEntry{CountAccepted: 3}, dbEntry{CountAccepted: 3, GMTDate: "2020-04-17"}, dbEntry{}},
+		{dbEntry{CountAccepted: 3, CountPass: 1}, dbEntry{CountAccepted: 5, CountDKIMPass: 2}, dbEntry{CountAccepted: 2, CountPass: -1, CountDKIMPass: 2}},
+		{dbEntry{CountQuarantined: 1, CountRejected: 2, CountSPFPass: 3}, dbEntry{}, dbEntry{CountQuarantined: -1, CountRejected: -2, CountSPFPass: -3}},
+	}
+	for _, test := range tests {
+		if delta := countDelta(test.old, test.entry); delta != test.delta {
+			t.Errorf("Expected %+v but got %+v", test.delta, delta)
+		}
+	}
+}
+
+func TestUnchangedCondition(t *testing.T) {
+	tests := []struct {
+		old        dbEntry
+		conditions []string
+		values     map[string]string
+	}{
+		{dbEntry{}, []string{
+			"attribute_exists(orgReportId)",
+			"(attribute_not_exists(countAccepted) OR countAccepted = :countAccepted)",
+			"(attribute_not_exists(countSpfPass) OR countSpfPass = :countSpfPass)",
+		}, map[string]string{":countAccepted": "0", ":countSpfPass": "0"}},
+		{dbEntry{CountAccepted: 3, CountDKIMPass: 2}, []string{
+			"countAccepted = :countAccepted",
+			"countDkimPass = :countDkimPass",
+			"(attribute_not_exists(countRejected) OR countRejected = :countRejected)",
+		}, map[string]string{":countAccepted": "3", ":countDkimPass": "2", ":countRejected": "0"}},
+	}
+	for _, test := range tests {
+		condition, values := unchangedCondition(test.old)
+		conditions := strings.Split(condition, " AND ")
+		if len(conditions) != 7 || len(values) != 6 {
+			t.Errorf("Expected a condition on the key and each of 6 counts but got %v with %v values", condition, len(values))
+		}
+		for _, c := range test.conditions {
+			if !strings.Contains(" AND "+condition+" AND ", " AND "+c+" AND ") {
+				t.Errorf("Expected %q in %v", c, condition)
+			}
+		}
+		for name, value := range test.values {
+			if v := values[name].(*dbtypes.AttributeValueMemberN).Value; v != value {
+				t.Errorf("Expected %v for %v but got %v", value, name, v)
+			}
+		}
+	}
+}
+
+// describeItems describes each write of a transaction.
+func describeItems(t *testing.T, items []dbtypes.TransactWriteItem) (writes []string) {
+	key := func(key map[string]dbtypes.AttributeValue, sortKey string) string {
+		return key["gmtDate"].(*dbtypes.AttributeValueMemberS).Value + " " + key[sortKey].(*dbtypes.AttributeValueMemberS).Value
+	}
+	condition := func(c *string) string {
+		if strings.Contains(aws.ToString(c), "countAccepted") {
+			return "unchanged"
+		}
+		return aws.ToString(c)
+	}
+	for _, item := range items {
+		switch {
+		case item.Put != nil:
+			var e dbEntry
+			if err := attributevalue.UnmarshalMap(item.Put.Item, &e); err != nil {
+				t.Fatal(err)
+			}
+			writes = append(writes, fmt.Sprintf("put %v %v if %v", e.GMTDate, e.OrgReportID, condition(item.Put.ConditionExpression)))
+		case item.Delete != nil:
+			writes = append(writes, fmt.Sprintf("delete %v if %v", key(item.Delete.Key, "orgReportId"), condition(item.Delete.ConditionExpression)))
+		case item.Update != nil:
+			v := item.Update.ExpressionAttributeValues
+			writes = append(writes, fmt.Sprintf("update %v reports %v accepted %v", key(item.Update.Key, "aggregateKey"),
+				v[":reports"].(*dbtypes.AttributeValueMemberN).Value, v[":accepted"].(*dbtypes.AttributeValueMemberN).Value))
+		}
+	}
+	return
+}
+
+func TestUpdateItems(t *testing.T) {
+	aggregateTableName = "dmarcAggregates"
+	defer func() { aggregateTableName = "" }()
+
+	old := dbEntry{GMTDate: "2020-04-17", OrgReportID: "google.com!1", Domain: "example.com", OrgName: "google.com", CountAccepted: 3}
+	tests := []struct {
+		name   string
+		change func(e *dbEntry)
+		writes []string
+	}{
+		{"same counts", func(e *dbEntry) { e.XML = "<feedback></feedback>" }, []string{
+			"put 2020-04-17 google.com!1 if unchanged",
+		}},
+		{"new counts", func(e *dbEntry) { e.CountAccepted = 5 }, []string{
+			"put 2020-04-17 google.com!1 if unchanged",
+			"update 2020-04-17 example.com#google.com reports 0 accepted 2",
+		}},
+		{"new date", func(e *dbEntry) { e.GMTDate = "2020-04-16" }, []string{
+			"delete 2020-04-17 google.com!1 if unchanged",
+			"put 2020-04-16 google.com!1 if attribute_not_exists(orgReportId)",
+			"update 2020-04-17 example.com#google.com reports -1 accepted -3",
+			"update 2020-04-16 example.com#google.com reports 1 accepted 3",
+		}},
+		{"new report ID", func(e *dbEntry) { e.OrgReportID = "Google!1"; e.OrgName = "Google" }, []string{
+			"delete 2020-04-17 google.com!1 if unchanged",
+			"put 2020-04-17 Google!1 if attribute_not_exists(orgReportId)",
+			"update 2020-04-17 example.com#google.com reports -1 accepted -3",
+			"update 2020-04-17 example.com#Google reports 1 accepted 3",
+		}},
+		{"new domain", func(e *dbEntry) { e.Domain = "example.org"; e.CountAccepted = 4 }, []string{
+			"put 2020-04-17 google.com!1 if unchanged",
+			"update 2020-04-17 example.com#google.com reports -1 accepted -3",
+			"update 2020-04-17 example.org#google.com reports 1 accepted 4",
+		}},
+	}
+	for _, test := range tests {
+		entry := old
+		test.change(&entry)
+		items, err := updateItems(old, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if writes := describeItems(t, items); fmt.Sprint(writes) != fmt.Sprint(test.writes) {
+			t.Errorf("%v: Expected %q but got %q", test.name, test.writes, writes)
+		}
+	}
+
+	// Quarantined reports are not in the aggregates.
+	quarantined := old
+	quarantined.Quarantined = true
+	entry := quarantined
+	entry.GMTDate = "2020-04-16"
+	items, err := updateItems(quarantined, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"delete 2020-04-17 google.com!1 if unchanged", "put 2020-04-16 google.com!1 if attribute_not_exists(orgReportId)"}
+	if writes := describeItems(t, items); fmt.Sprint(writes) != fmt.Sprint(expected) {
+		t.Errorf("Expected %q but got %q", expected, writes)
+	}
+}
+
+func TestAggregateCorrections(t *testing.T) {
+	aggregateTableName = "dmarcAggregates"
+	defer func() { aggregateTableName = "" }()
+
+	stored := []aggRow{
+		{GMTDate: "2020-04-17", AggregateKey: "ericdaugherty.com#google.com", Domain: "ericdaugherty.com", OrgName: "google.com", Reports: 1, CountAccepted: 3},
+		{GMTDate: "2020-04-17", AggregateKey: "ericdaugherty.com#yahoo.com", Domain: "ericdaugherty.com", OrgName: "yahoo.com", Reports: 1, CountAccepted: 2},
+		{GMTDate: "2020-04-17", AggregateKey: "example.com#google.com", Domain: "example.com", OrgName: "google.com", Reports: 1, CountRejected: 4},
+	}
+	totals := map[string]aggRow{
+		"ericdaugherty.com#google.com":  {GMTDate: "2020-04-17", AggregateKey: "ericdaugherty.com#google.com", Domain: "ericdaugherty.com", OrgName: "google.com", Reports: 2, CountAccepted: 5, CountPass: 4},
+		"ericdaugherty.com#yahoo.com":   stored[1],
+		"ericdaugherty.com#outlook.com": {GMTDate: "2020-04-17", AggregateKey: "ericdaugherty.com#outlook.com", Domain: "ericdaugherty.com", OrgName: "outlook.com", Reports: 1, CountAccepted: 1},
+	}
+
+	updates, changes := aggregateCorrections(stored, totals)
+	expected := []string{
+		"ericdaugherty.com#google.com: 1 reports, 3 messages -> 2 reports, 5 messages",
+		"ericdaugherty.com#outlook.com: 0 reports, 0 messages -> 1 reports, 1 messages",
+		"example.com#google.com: 1 reports, 4 messages -> 0 reports, 0 messages",
+	}
+	if fmt.Sprint(changes) != fmt.Sprint(expected) {
+		t.Errorf("Expected %q but got %q", expected, changes)
+	}
+	if len(updates) != 3 {
+		t.Fatalf("Expected %v updates but got %v", 3, len(updates))
+	}
+
+	value := func(u *dbtypes.Update, name string) string {
+		return u.ExpressionAttributeValues[name].(*dbtypes.AttributeValueMemberN).Value
+	}
+	if value(updates[0], ":reports") != "1" || value(updates[0], ":accepted") != "2" || value(updates[0], ":pass") != "4" {
+		t.Errorf("Expected the difference in counts but got %v", updates[0].ExpressionAttributeValues)
+	}
+	if c := aws.ToString(updates[0].ConditionExpression); !strings.Contains(c, "countAccepted = :oldcountAccepted") || !strings.Contains(c, "(attribute_not_exists(countPass) OR countPass = :oldcountPass)") {
+		t.Errorf("Expected conditions on the stored counts but got %v", c)
+	}
+	if c := aws.ToString(updates[1].ConditionExpression); c != "attribute_not_exists(aggregateKey)" {
+		t.Errorf("Expected a new row but got %v", c)
+	}
+	if value(updates[2], ":reports") != "-1" || value(updates[2], ":rejected") != "-4" {
+		t.Errorf("Expected the row to be brought to zero but got %v", updates[2].ExpressionAttributeValues)
+	}
+}
+
+func TestParseDates(t *testing.T) {
+	dates, err := parseDates("2020-02-28", "2020-03-01")
+	expected := []string{"2020-02-28", "2020-02-29", "2020-03-01"}
+	if err != nil || fmt.Sprint(dates) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v but got %v %v", expected, dates, err)
+	}
+	if dates, err = parseDates("", ""); err != nil || dates != nil {
+		t.Errorf("Expected no dates but got %v %v", dates, err)
+	}
+	for _, r := range [][2]string{{"", "2020-03-01"}, {"2020-03-01", "2020-02-28"}, {"yesterday", ""}} {
+		if _, err := parseDates(r[0], r[1]); err == nil {
+			t.Errorf("Expected an error for %v", r)
+		}
+	}
+}
+
+func TestIsDuplicate(t *testing.T) {
+	if isDuplicate(errors.New("other")) || isDuplicate(nil) {
+		t.Errorf("Expected unrelated errors not to be duplicates")
+	}
+
+	err := fmt.Errorf("wrapped: %w", &dbtypes.TransactionCanceledException{
+		CancellationReasons: []dbtypes.CancellationReason{{Code: aws.String("ConditionalCheckFailed")}, {Code: aws.String("None")}},
+	})
+	if !isDuplicate(err) {
+		t.Errorf("Expected %v to be a duplicate", err)
+	}
+}
+
 func getEvent(s string) (ses events.S3Event, e error) {
 	e = json.Unmarshal([]byte(s), &ses)
 	return
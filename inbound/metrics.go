@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Outcomes of processing a report email, the result label of reportsTotal.
+const (
+	resultStored      = "stored"
+	resultDuplicate   = "duplicate"
+	resultQuarantined = "quarantined"
+	resultRejected    = "rejected"
+	resultFailed      = "failed"
+)
+
+var (
+	reportsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dmarc_inbound_reports_total",
+		Help: "Report emails processed, by result.",
+	}, []string{"result"})
+	parseFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dmarc_inbound_parse_failures_total",
+		Help: "Report emails that could not be read, by the step that failed.",
+	}, []string{"reason"})
+	notificationFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dmarc_inbound_notification_failures_total",
+		Help: "Notification emails that could not be sent.",
+	})
+	processingSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "dmarc_inbound_processing_seconds",
+		Help: "Time taken to process a report email.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(reportsTotal, parseFailures, notificationFailures, processingSeconds)
+}
+
+// parseFailed counts an email that could not be read as a report.
+func parseFailed(reason string) {
+	parseFailures.WithLabelValues(reason).Inc()
+	reportsTotal.WithLabelValues(resultFailed).Inc()
+}
+
+// eventsToken is the bearer token required to POST S3 events to /events,
+// and eventsBucket the only bucket those events may name. /events is only
+// served when both are set.
+var eventsToken, eventsBucket string
+
+// maxEventSize bounds the body of a POSTed S3 event.
+const maxEventSize = 1 << 20
+
+// serve runs the handler outside Lambda. The metrics are served at /metrics,
+// and S3 event notifications for eventsBucket POSTed to /events with
+// eventsToken are processed as they would be by Lambda.
+func serve(addr string) error {
+	fmt.Printf("Listening on %v\n", addr)
+	return http.ListenAndServe(addr, serveMux())
+}
+
+func serveMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	if eventsToken != "" && eventsBucket != "" {
+		mux.HandleFunc("/events", serveEvents)
+	}
+	return mux
+}
+
+// serveEvents processes a POSTed S3 event, refusing it unless it carries
+// eventsToken and every record is for eventsBucket.
+func serveEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == r.Header.Get("Authorization") || subtle.ConstantTimeCompare([]byte(token), []byte(eventsToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="events"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var event events.S3Event
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxEventSize)).Decode(&event); err != nil {
+		http.Error(w, fmt.Sprintf("invalid S3 event. %v", err), http.StatusBadRequest)
+		return
+	}
+	for _, record := range event.Records {
+		if record.S3.Bucket.Name != eventsBucket {
+			http.Error(w, fmt.Sprintf("bucket %q is not %v", record.S3.Bucket.Name, eventsBucket), http.StatusForbidden)
+			return
+		}
+	}
+	handler(r.Context(), event)
+	w.WriteHeader(http.StatusNoContent)
+}
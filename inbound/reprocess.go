@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/ericdaugherty/dmarc/report"
+)
+
+// reportIDIndex is the index of the reports table on orgReportId.
+const reportIDIndex = "orgReportId-index"
+
+// Results of reprocessing a stored report.
+const (
+	resultUnchanged = "unchanged"
+	resultChanged   = "changed"
+	resultSkipped   = "skipped"
+)
+
+// runReprocess reads stored reports again from the emails they arrived in,
+// and updates any that now decode differently. Reports can be chosen by
+// orgReportId, or by the range of GMT dates they are stored under, and
+// without either every report is reprocessed.
+func runReprocess(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("reprocess", flag.ExitOnError)
+	from := flags.String("from", "", "reprocess the reports stored under GMT dates from this one, 2006-01-02")
+	to := flags.String("to", "", "reprocess the reports stored under GMT dates up to this one, the from date by default")
+	dryRun := flags.Bool("dry-run", false, "print the changes without storing them")
+	workers := flags.Int("workers", 4, "number of reports to reprocess at once")
+	flags.Parse(args)
+
+	ids := flags.Args()
+	if *from != "" && len(ids) > 0 {
+		return errors.New("give report IDs or a date range, not both")
+	}
+	dates, err := parseDates(*from, *to)
+	if err != nil {
+		return err
+	}
+	if *workers < 1 {
+		return errors.New("workers must be at least 1")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	p := newProgress("reports", resultUnchanged, resultChanged, resultSkipped, resultFailed)
+	err = runTasks(ctx, *workers, p, func(run func(task) error) error {
+		return eachStored(ctx, svc, ids, dates, func(e dbEntry) error {
+			return run(func(ctx context.Context) string { return reprocessReport(ctx, svc, e, *dryRun) })
+		})
+	})
+
+	if *dryRun {
+		fmt.Printf("Checked %v\n", p)
+	} else {
+		fmt.Printf("Reprocessed %v\n", p)
+	}
+	return err
+}
+
+// eachStored calls fn with each stored report with one of ids, or stored
+// under one of dates, or every stored report if there are neither.
+func eachStored(ctx context.Context, svc *dynamodb.Client, ids, dates []string, fn func(dbEntry) error) error {
+	each := func(items []map[string]dbtypes.AttributeValue) error {
+		var entries []dbEntry
+		if err := attributevalue.UnmarshalListOfMaps(items, &entries); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	query := func(input *dynamodb.QueryInput) error {
+		pages := dynamodb.NewQueryPaginator(svc, input)
+		for pages.HasMorePages() {
+			page, err := pages.NextPage(ctx)
+			if err != nil {
+				return err
+			}
+			if err = each(page.Items); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	keyQuery := func(attr, value string) *dynamodb.QueryInput {
+		return &dynamodb.QueryInput{
+			TableName:                 aws.String(dynamoDBTableName),
+			KeyConditionExpression:    aws.String(attr + " = :v"),
+			ExpressionAttributeValues: map[string]dbtypes.AttributeValue{":v": &dbtypes.AttributeValueMemberS{Value: value}},
+		}
+	}
+
+	switch {
+	case len(ids) > 0:
+		for _, id := range ids {
+			input := keyQuery("orgReportId", id)
+			input.IndexName = aws.String(reportIDIndex)
+			if err := query(input); err != nil {
+				return err
+			}
+		}
+	case len(dates) > 0:
+		for _, date := range dates {
+			if err := query(keyQuery("gmtDate", date)); err != nil {
+				return err
+			}
+		}
+	default:
+		pages := dynamodb.NewScanPaginator(svc, &dynamodb.ScanInput{TableName: aws.String(dynamoDBTableName)})
+		for pages.HasMorePages() {
+			page, err := pages.NextPage(ctx)
+			if err != nil {
+				return err
+			}
+			if err = each(page.Items); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reprocessReport reads a stored report again from its email, or from its
+// stored XML if the email was not stored, and, unless dryRun is set, stores
+// it if it changed. The result of authenticating the email when it arrived
+// is kept, since DKIM keys may have changed since.
+func reprocessReport(ctx context.Context, svc *dynamodb.Client, old dbEntry, dryRun bool) string {
+	name := fmt.Sprintf("%v (%v)", old.OrgReportID, old.GMTDate)
+	var f report.Feedback
+	var fd []byte
+	switch {
+	case old.S3Key != "":
+		raw, err := getEmailFunc(ctx, old.S3Bucket, old.S3Key)
+		if err != nil {
+			fmt.Printf("Error reprocessing %v. Unable to load from S3. %v\n", name, err)
+			return resultFailed
+		}
+		if f, fd, err = readReport(raw); err != nil {
+			fmt.Printf("Error reprocessing %v. %v\n", name, err)
+			return resultFailed
+		}
+	case old.XML != "":
+		fd = []byte(old.XML)
+		var err error
+		if f, err = decodeXML(fd); err != nil {
+			fmt.Printf("Error reprocessing %v. Unable to decode the stored XML. %v\n", name, err)
+			return resultFailed
+		}
+	default:
+		fmt.Printf("Skipping %v, neither its email nor its XML was stored.\n", name)
+		return resultSkipped
+	}
+	entry, err := newDBEntry(old.S3Bucket, old.S3Key, f, fd, emailAuth{Result: old.AuthResult, Detail: old.AuthDetail}, old.Quarantined)
+	if err != nil {
+		fmt.Printf("Error reprocessing %v. %v\n", name, err)
+		return resultFailed
+	}
+
+	changes := diffEntries(old, entry)
+	if len(changes) == 0 {
+		return resultUnchanged
+	}
+	fmt.Printf("Changed %v:\n  %v\n", name, strings.Join(changes, "\n  "))
+	if dryRun {
+		return resultChanged
+	}
+
+	if err = updateReport(ctx, svc, old, entry); err != nil {
+		fmt.Printf("Error reprocessing %v. %v\n", name, err)
+		return resultFailed
+	}
+	return resultChanged
+}
+
+// diffEntries describes each field that differs between two stored reports.
+func diffEntries(old, entry dbEntry) (changes []string) {
+	ov, nv := reflect.ValueOf(old), reflect.ValueOf(entry)
+	for i := 0; i < ov.NumField(); i++ {
+		o, n := ov.Field(i).Interface(), nv.Field(i).Interface()
+		if o == n {
+			continue
+		}
+		name := strings.Split(ov.Type().Field(i).Tag.Get("json"), ",")[0]
+		if name == "xml" {
+			changes = append(changes, fmt.Sprintf("xml: %v records in %v bytes -> %v records in %v bytes",
+				countRecords(old.XML), len(old.XML), countRecords(entry.XML), len(entry.XML)))
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%v: %v -> %v", name, o, n))
+	}
+	return
+}
+
+func countRecords(xml string) int {
+	f, _ := report.Parse([]byte(xml))
+	return len(f.Record)
+}
+
+// updateReport replaces a stored report with entry and corrects the
+// aggregates by the difference in their counts, in one transaction. The
+// transaction fails if the stored counts are no longer those of old, so
+// running it twice changes nothing.
+func updateReport(ctx context.Context, svc *dynamodb.Client, old, entry dbEntry) error {
+	items, err := updateItems(old, entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if isDuplicate(err) {
+		err = fmt.Errorf("the stored report changed while it was reprocessed, or another report is stored as %v. %v", entry.OrgReportID, err)
+	}
+	return err
+}
+
+// updateItems returns the writes of updateReport. A report whose key
+// changed is deleted and stored again under its new key.
+func updateItems(old, entry dbEntry) (items []dbtypes.TransactWriteItem, err error) {
+	av, err := attributevalue.MarshalMap(entry)
+	if err != nil {
+		return
+	}
+
+	unchanged, values := unchangedCondition(old)
+	if old.GMTDate == entry.GMTDate && old.OrgReportID == entry.OrgReportID {
+		items = append(items, dbtypes.TransactWriteItem{Put: &dbtypes.Put{
+			Item:                      av,
+			TableName:                 aws.String(dynamoDBTableName),
+			ConditionExpression:       aws.String(unchanged),
+			ExpressionAttributeValues: values,
+		}})
+	} else {
+		items = append(items,
+			dbtypes.TransactWriteItem{Delete: &dbtypes.Delete{
+				Key: map[string]dbtypes.AttributeValue{
+					"gmtDate":     &dbtypes.AttributeValueMemberS{Value: old.GMTDate},
+					"orgReportId": &dbtypes.AttributeValueMemberS{Value: old.OrgReportID},
+				},
+				TableName:                 aws.String(dynamoDBTableName),
+				ConditionExpression:       aws.String(unchanged),
+				ExpressionAttributeValues: values,
+			}},
+			dbtypes.TransactWriteItem{Put: &dbtypes.Put{
+				Item:                av,
+				TableName:           aws.String(dynamoDBTableName),
+				ConditionExpression: aws.String("attribute_not_exists(orgReportId)"),
+			}})
+	}
+
+	if aggregateTableName != "" && !old.Quarantined {
+		if old.GMTDate == entry.GMTDate && aggregateKey(old.Domain, old.OrgName) == aggregateKey(entry.Domain, entry.OrgName) {
+			if delta := countDelta(old, entry); delta != (dbEntry{}) {
+				items = append(items, dbtypes.TransactWriteItem{Update: aggregateChange(entry, 0, delta)})
+			}
+		} else {
+			items = append(items,
+				dbtypes.TransactWriteItem{Update: aggregateChange(old, -1, countDelta(old, dbEntry{}))},
+				dbtypes.TransactWriteItem{Update: aggregateUpdate(entry)})
+		}
+	}
+	return
+}
+
+// unchangedCondition returns a condition that the stored report still has
+// the counts of old, with its values. Reports stored before a count was
+// added do not have it, which is the same as zero.
+func unchangedCondition(old dbEntry) (condition string, values map[string]dbtypes.AttributeValue) {
+	conditions := []string{"attribute_exists(orgReportId)"}
+	values = map[string]dbtypes.AttributeValue{}
+	for _, c := range []struct {
+		attr  string
+		value int
+	}{
+		{"countAccepted", old.CountAccepted},
+		{"countQuarantined", old.CountQuarantined},
+		{"countRejected", old.CountRejected},
+		{"countPass", old.CountPass},
+		{"countDkimPass", old.CountDKIMPass},
+		{"countSpfPass", old.CountSPFPass},
+	} {
+		values[":"+c.attr] = &dbtypes.AttributeValueMemberN{Value: fmt.Sprint(c.value)}
+		if c.value == 0 {
+			conditions = append(conditions, fmt.Sprintf("(attribute_not_exists(%v) OR %v = :%v)", c.attr, c.attr, c.attr))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("%v = :%v", c.attr, c.attr))
+		}
+	}
+	return strings.Join(conditions, " AND "), values
+}
+
+// countDelta returns an entry holding the counts of entry less those of old.
+func countDelta(old, entry dbEntry) dbEntry {
+	return dbEntry{
+		CountAccepted:    entry.CountAccepted - old.CountAccepted,
+		CountQuarantined: entry.CountQuarantined - old.CountQuarantined,
+		CountRejected:    entry.CountRejected - old.CountRejected,
+		CountPass:        entry.CountPass - old.CountPass,
+		CountDKIMPass:    entry.CountDKIMPass - old.CountDKIMPass,
+		CountSPFPass:     entry.CountSPFPass - old.CountSPFPass,
+	}
+}
@@ -0,0 +1,122 @@
+package report
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/DusanKasan/parsemail"
+)
+
+// Extract returns the report XML in data, which can be a report email, a zip
+// or gzip file, or the XML itself. The kind is told from the content, so the
+// file name does not matter.
+func Extract(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return Unzip(data)
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		return Ungzip(bytes.NewReader(data))
+	case bytes.HasPrefix(bytes.TrimLeft(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), " \t\r\n"), []byte("<")):
+		return data, nil
+	}
+
+	msg, err := parsemail.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse email. %v", err)
+	}
+	return DecodeAttachment(&msg)
+}
+
+// DecodeAttachment returns the report XML from a report email, either sent
+// as a single zip, gzip or XML part or attached to a multipart email as a
+// .zip, .gz or .xml file.
+func DecodeAttachment(msg *parsemail.Email) (res []byte, err error) {
+	if strings.TrimSpace(msg.ContentType) == "" {
+		return res, errors.New("email has no content type")
+	}
+	mediaType, _, err := mime.ParseMediaType(msg.ContentType)
+	if err != nil {
+		return res, fmt.Errorf("invalid content type %q. %v", msg.ContentType, err)
+	}
+
+	switch mediaType {
+	case "multipart/mixed":
+		for _, f := range msg.Attachments {
+			if res, ok, err := decodeFile(f.Filename, f.Data); ok {
+				return res, err
+			}
+		}
+		return res, errors.New("no reports found in email")
+	case "application/zip", "application/x-zip-compressed":
+		// parsemail will decode the file for us.
+		content, _ := ioutil.ReadAll(msg.Content)
+		return Unzip(content)
+	case "application/gzip", "application/x-gzip":
+		return Ungzip(msg.Content)
+	case "application/xml", "text/xml":
+		return ioutil.ReadAll(msg.Content)
+	default:
+		return res, errors.New("unknown content type " + mediaType)
+	}
+}
+
+// decodeFile returns the report XML in an attached file, telling the kind of
+// file from its extension. ok is false if the extension is not one a report
+// is sent with.
+func decodeFile(name string, r io.Reader) (res []byte, ok bool, err error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz":
+		res, err = Ungzip(r)
+	case ".zip":
+		var content []byte
+		if content, err = ioutil.ReadAll(r); err == nil {
+			res, err = Unzip(content)
+		}
+	case ".xml":
+		res, err = ioutil.ReadAll(r)
+	default:
+		return nil, false, nil
+	}
+	return res, true, err
+}
+
+// Unzip returns the first XML file in zip data.
+func Unzip(data []byte) (b []byte, err error) {
+	r := bytes.NewReader(data)
+	zr, err := zip.NewReader(r, int64(r.Len()))
+	if err != nil {
+		return
+	}
+
+	for _, f := range zr.File {
+		if strings.Contains(f.Name, ".xml") {
+			var zf io.ReadCloser
+			zf, err = f.Open()
+			if err != nil {
+				return
+			}
+			defer zf.Close()
+			return ioutil.ReadAll(zf)
+		}
+	}
+
+	return b, errors.New("no xml file found in zip data")
+}
+
+// Ungzip returns the decompressed contents of gzip data.
+func Ungzip(r io.Reader) (b []byte, err error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return
+	}
+
+	return ioutil.ReadAll(zr)
+}
@@ -0,0 +1,66 @@
+package report
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// MaxSpan is the longest date range a report's counts are split across.
+// RFC 7489 asks for daily reports, but some reporters cover a week at a
+// time. Longer reports are split across the MaxSpan from their start.
+const MaxSpan = 7 * 24 * time.Hour
+
+// DayShare is the part of a report's date range that falls on one day.
+type DayShare struct {
+	// Date is the day in the form 2006-01-02.
+	Date     string
+	Fraction float64
+}
+
+// SplitDays splits the date range from begin to end into the days of loc it
+// covers, with the fraction of the range on each day, in order. A range that
+// is empty or ends before it begins is attributed to the day it begins.
+func SplitDays(begin, end time.Time, loc *time.Location) (shares []DayShare) {
+	begin, end = begin.In(loc), end.In(loc)
+	total := end.Sub(begin)
+	if total <= 0 {
+		return []DayShare{{Date: begin.Format("2006-01-02"), Fraction: 1}}
+	}
+
+	for start := begin; start.Before(end); {
+		y, m, d := start.Date()
+		next := time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+		if next.After(end) {
+			next = end
+		}
+		shares = append(shares, DayShare{Date: start.Format("2006-01-02"), Fraction: float64(next.Sub(start)) / float64(total)})
+		start = next
+	}
+	return
+}
+
+// Prorate divides n between shares in proportion to their fractions. The
+// parts are whole numbers that add up to n, with the remainder going to the
+// shares that lost the most to rounding down.
+func Prorate(n int, shares []DayShare) []int {
+	parts := make([]int, len(shares))
+	order := make([]int, len(shares))
+	left := n
+	for i, s := range shares {
+		exact := float64(n) * s.Fraction
+		parts[i] = int(math.Floor(exact))
+		left -= parts[i]
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ea := float64(n)*shares[order[a]].Fraction - float64(parts[order[a]])
+		eb := float64(n)*shares[order[b]].Fraction - float64(parts[order[b]])
+		return ea > eb
+	})
+	for i := 0; left > 0 && len(shares) > 0; i = (i + 1) % len(shares) {
+		parts[order[i]]++
+		left--
+	}
+	return parts
+}
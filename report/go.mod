@@ -0,0 +1,5 @@
+module github.com/ericdaugherty/dmarc/report
+
+go 1.18
+
+require github.com/DusanKasan/parsemail v1.2.0
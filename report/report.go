@@ -0,0 +1,164 @@
+// Package report decodes DMARC aggregate reports.
+package report
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// Feedback maps the DMARC XML report to a struct
+type Feedback struct {
+	XMLName         xml.Name        `xml:"feedback"`
+	Text            string          `xml:",chardata"`
+	Version         string          `xml:"version"`
+	ReportMetadata  ReportMetadata  `xml:"report_metadata"`
+	PolicyPublished PolicyPublished `xml:"policy_published"`
+	Record          []Record        `xml:"record"`
+}
+
+// ReportMetadata describes the reporting organization and period.
+type ReportMetadata struct {
+	Text      string `xml:",chardata"`
+	OrgName   string `xml:"org_name"`
+	Email     string `xml:"email"`
+	ReportID  string `xml:"report_id"`
+	DateRange struct {
+		Text  string `xml:",chardata"`
+		Begin string `xml:"begin"`
+		End   string `xml:"end"`
+	} `xml:"date_range"`
+}
+
+// PolicyPublished is the DMARC policy the reporter found for the domain.
+type PolicyPublished struct {
+	Text   string `xml:",chardata"`
+	Domain string `xml:"domain"`
+	Adkim  string `xml:"adkim"`
+	Aspf   string `xml:"aspf"`
+	P      string `xml:"p"`
+	Sp     string `xml:"sp"`
+	Pct    string `xml:"pct"`
+	Fo     string `xml:"fo"`
+}
+
+// Tags formats the policy as DMARC record tags, leaving out the optional
+// tags the reporter did not include.
+func (p PolicyPublished) Tags() string {
+	tags := []string{"p=" + p.P}
+	for _, t := range []struct{ name, value string }{
+		{"sp", p.Sp}, {"pct", p.Pct}, {"adkim", p.Adkim}, {"aspf", p.Aspf}, {"fo", p.Fo},
+	} {
+		if t.value != "" {
+			tags = append(tags, t.name+"="+t.value)
+		}
+	}
+	return strings.Join(tags, "; ")
+}
+
+// Effective returns the policy with the optional tags the reporter left out
+// set to their RFC 7489 defaults, and every value in lower case. Reporters
+// include different optional tags, so policies are compared in this form.
+func (p PolicyPublished) Effective() PolicyPublished {
+	value := func(s, def string) string {
+		if s = strings.ToLower(strings.TrimSpace(s)); s == "" {
+			return def
+		}
+		return s
+	}
+	p.Text = ""
+	p.Domain = value(p.Domain, "")
+	p.P = value(p.P, "")
+	p.Sp = value(p.Sp, p.P)
+	p.Pct = value(p.Pct, "100")
+	p.Adkim = value(p.Adkim, "r")
+	p.Aspf = value(p.Aspf, "r")
+	p.Fo = value(p.Fo, "0")
+	return p
+}
+
+// Record is the result for one source IP and set of identifiers.
+type Record struct {
+	Text        string      `xml:",chardata"`
+	Row         Row         `xml:"row"`
+	Identifiers Identifiers `xml:"identifiers"`
+	AuthResults AuthResults `xml:"auth_results"`
+}
+
+// Row is the number of messages from a source IP and the DMARC result the
+// reporter applied to them.
+type Row struct {
+	Text            string          `xml:",chardata"`
+	SourceIP        string          `xml:"source_ip"`
+	Count           string          `xml:"count"`
+	PolicyEvaluated PolicyEvaluated `xml:"policy_evaluated"`
+}
+
+// PolicyEvaluated is the DMARC result for a row, with the reasons the
+// reporter applied a different disposition than the policy asked for.
+type PolicyEvaluated struct {
+	Text        string   `xml:",chardata"`
+	Disposition string   `xml:"disposition"`
+	Dkim        string   `xml:"dkim"`
+	Spf         string   `xml:"spf"`
+	Reason      []Reason `xml:"reason"`
+}
+
+// Reason is a policy override reason.
+type Reason struct {
+	Text    string `xml:",chardata"`
+	Type    string `xml:"type"`
+	Comment string `xml:"comment"`
+}
+
+// Identifiers are the domains the messages in a record were sent as.
+type Identifiers struct {
+	Text         string `xml:",chardata"`
+	EnvelopeTo   string `xml:"envelope_to"`
+	EnvelopeFrom string `xml:"envelope_from"`
+	HeaderFrom   string `xml:"header_from"`
+}
+
+// AuthResults are the raw DKIM and SPF results, before alignment. A record
+// can have several DKIM results, one per signature.
+type AuthResults struct {
+	Text string           `xml:",chardata"`
+	Dkim []DKIMAuthResult `xml:"dkim"`
+	Spf  []SPFAuthResult  `xml:"spf"`
+}
+
+// DKIMAuthResult is the result of checking one DKIM signature.
+type DKIMAuthResult struct {
+	Text        string `xml:",chardata"`
+	Domain      string `xml:"domain"`
+	Selector    string `xml:"selector"`
+	Result      string `xml:"result"`
+	HumanResult string `xml:"human_result"`
+}
+
+// SPFAuthResult is the result of an SPF check.
+type SPFAuthResult struct {
+	Text   string `xml:",chardata"`
+	Domain string `xml:"domain"`
+	Scope  string `xml:"scope"`
+	Result string `xml:"result"`
+}
+
+// SPFDomain returns the domain whose SPF record was checked for the record,
+// falling back to the envelope and header from domains when the reporter
+// left it out.
+func (r Record) SPFDomain() string {
+	switch {
+	case len(r.AuthResults.Spf) > 0 && r.AuthResults.Spf[0].Domain != "":
+		return r.AuthResults.Spf[0].Domain
+	case r.Identifiers.EnvelopeFrom != "":
+		return r.Identifiers.EnvelopeFrom
+	default:
+		return r.Identifiers.HeaderFrom
+	}
+}
+
+// Parse decodes an XML aggregate report.
+func Parse(data []byte) (f Feedback, err error) {
+	err = xml.Unmarshal(data, &f)
+	return
+}
@@ -0,0 +1,224 @@
+package report
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const multiResultXML = `<?xml version="1.0" encoding="UTF-8" ?>
+<feedback>
+  <report_metadata>
+    <org_name>example.net</org_name>
+    <email>dmarc@example.net</email>
+    <report_id>1234</report_id>
+    <date_range><begin>1587081600</begin><end>1587167999</end></date_range>
+  </report_metadata>
+  <policy_published><domain>example.com</domain><p>reject</p></policy_published>
+  <record>
+    <row>
+      <source_ip>192.0.2.1</source_ip>
+      <count>2</count>
+      <policy_evaluated>
+        <disposition>none</disposition>
+        <dkim>pass</dkim>
+        <spf>fail</spf>
+        <reason><type>forwarded</type><comment>mailing list</comment></reason>
+      </policy_evaluated>
+    </row>
+    <identifiers><header_from>example.com</header_from></identifiers>
+    <auth_results>
+      <dkim><domain>example.com</domain><selector>s1</selector><result>pass</result></dkim>
+      <dkim><domain>list.example.org</domain><selector>s2</selector><result>pass</result></dkim>
+      <spf><domain>list.example.org</domain><scope>mfrom</scope><result>pass</result></spf>
+    </auth_results>
+  </record>
+</feedback>`
+
+func TestParseMultipleResults(t *testing.T) {
+	f, err := Parse([]byte(multiResultXML))
+	if err != nil {
+		t.Fatalf("Error decoding XML: %v", err)
+	}
+
+	r := f.Record[0]
+	if len(r.AuthResults.Dkim) != 2 {
+		t.Errorf("Expected %v but got %v", 2, len(r.AuthResults.Dkim))
+	}
+	if len(r.Row.PolicyEvaluated.Reason) != 1 || r.Row.PolicyEvaluated.Reason[0].Type != "forwarded" {
+		t.Errorf("Expected a forwarded reason but got %v", r.Row.PolicyEvaluated.Reason)
+	}
+
+	expected := "list.example.org"
+	if r.SPFDomain() != expected {
+		t.Errorf("Expected %v but got %v", expected, r.SPFDomain())
+	}
+
+	r.AuthResults.Spf = nil
+	expected = "example.com"
+	if r.SPFDomain() != expected {
+		t.Errorf("Expected %v but got %v", expected, r.SPFDomain())
+	}
+}
+
+func TestSplitDays(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("No time zone data: %v", err)
+	}
+
+	// A report for a GMT day covers the evening of one New York day and
+	// most of the next.
+	begin := time.Date(2020, 4, 17, 0, 0, 0, 0, time.UTC)
+	shares := SplitDays(begin, begin.Add(24*time.Hour), ny)
+	if len(shares) != 2 {
+		t.Fatalf("Expected 2 days but got %v", shares)
+	}
+	if shares[0].Date != "2020-04-16" || shares[0].Fraction != 4.0/24 {
+		t.Errorf("Expected 2020-04-16 with 4/24 but got %v", shares[0])
+	}
+	if shares[1].Date != "2020-04-17" || shares[1].Fraction != 20.0/24 {
+		t.Errorf("Expected 2020-04-17 with 20/24 but got %v", shares[1])
+	}
+
+	shares = SplitDays(begin, begin, time.UTC)
+	if len(shares) != 1 || shares[0].Date != "2020-04-17" || shares[0].Fraction != 1 {
+		t.Errorf("Expected the whole of an empty range on 2020-04-17 but got %v", shares)
+	}
+}
+
+func TestProrate(t *testing.T) {
+	shares := []DayShare{{Date: "2020-04-16", Fraction: 0.25}, {Date: "2020-04-17", Fraction: 0.75}}
+	for _, c := range []struct {
+		n    int
+		want []int
+	}{
+		{0, []int{0, 0}},
+		{1, []int{0, 1}},
+		{3, []int{1, 2}},
+		{10, []int{3, 7}},
+	} {
+		got := Prorate(c.n, shares)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Expected %v for %v but got %v", c.want, c.n, got)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(multiResultXML))
+	zw.Close()
+
+	var zipped bytes.Buffer
+	w := zip.NewWriter(&zipped)
+	f, _ := w.Create("example.net!example.com!1587081600!1587167999.xml")
+	f.Write([]byte(multiResultXML))
+	w.Close()
+
+	for name, data := range map[string][]byte{
+		"xml":  []byte("\n" + multiResultXML),
+		"gzip": gz.Bytes(),
+		"zip":  zipped.Bytes(),
+	} {
+		b, err := Extract(data)
+		if err != nil {
+			t.Errorf("%v: Error extracting report: %v", name, err)
+			continue
+		}
+		if _, err := Parse(b); err != nil {
+			t.Errorf("%v: Error decoding XML: %v", name, err)
+		}
+	}
+
+	if _, err := Extract([]byte("Content-Type: text/plain\r\n\r\nNo report here.\r\n")); err == nil {
+		t.Errorf("Expected an error for an email without a report")
+	}
+	if _, err := Extract([]byte("Subject: Report\r\n\r\nNo report here.\r\n")); err == nil {
+		t.Errorf("Expected an error for an email without a content type")
+	}
+}
+
+func TestDecodeAttachment(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(multiResultXML))
+	zw.Close()
+
+	var zipped bytes.Buffer
+	w := zip.NewWriter(&zipped)
+	f, _ := w.Create("report.xml")
+	f.Write([]byte(multiResultXML))
+	w.Close()
+
+	single := func(contentType string, data []byte) string {
+		return "Content-Type: " + contentType + "\r\nContent-Transfer-Encoding: base64\r\n\r\n" +
+			base64.StdEncoding.EncodeToString(data) + "\r\n"
+	}
+	attached := func(name string, data []byte) string {
+		return "Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+			"--b\r\nContent-Type: text/plain\r\n\r\nA report.\r\n" +
+			"--b\r\nContent-Type: application/octet-stream\r\nContent-Disposition: attachment; filename=\"" + name + "\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n\r\n" + base64.StdEncoding.EncodeToString(data) + "\r\n--b--\r\n"
+	}
+
+	for name, email := range map[string]string{
+		"zip":             single("application/zip", zipped.Bytes()),
+		"zip with params": single("application/zip; name=\"report.zip\"", zipped.Bytes()),
+		"gzip":            single("application/gzip", gz.Bytes()),
+		"xml":             single("text/xml; charset=utf-8", []byte(multiResultXML)),
+		"attached gz":     attached("report.xml.gz", gz.Bytes()),
+		"attached zip":    attached("report.ZIP", zipped.Bytes()),
+		"attached xml":    attached("report.xml", []byte(multiResultXML)),
+	} {
+		b, err := Extract([]byte(email))
+		if err != nil {
+			t.Errorf("%v: Error extracting report: %v", name, err)
+			continue
+		}
+		if _, err := Parse(b); err != nil {
+			t.Errorf("%v: Error decoding XML: %v", name, err)
+		}
+	}
+
+	for name, email := range map[string]string{
+		"no content type":    "Subject: Report\r\n\r\nNo report here.\r\n",
+		"other attachment":   attached("report.pdf", []byte("%PDF")),
+		"other content type": single("image/png", []byte("png")),
+	} {
+		if _, err := Extract([]byte(email)); err == nil {
+			t.Errorf("%v: Expected an error but got none", name)
+		}
+	}
+}
+
+func TestPolicyPublished(t *testing.T) {
+	reported := PolicyPublished{Domain: "example.com", P: "reject", Pct: "100"}
+	if got, expected := reported.Tags(), "p=reject; pct=100"; got != expected {
+		t.Errorf("Expected %v but got %v", expected, got)
+	}
+
+	full := PolicyPublished{Domain: "Example.com", P: "Reject", Sp: "reject", Pct: "100", Adkim: "r", Aspf: "r", Fo: "0"}
+	if reported.Effective() != full.Effective() {
+		t.Errorf("Expected %v and %v to be the same policy", reported.Tags(), full.Tags())
+	}
+	if got, expected := reported.Effective().Tags(), "p=reject; sp=reject; pct=100; adkim=r; aspf=r; fo=0"; got != expected {
+		t.Errorf("Expected %v but got %v", expected, got)
+	}
+
+	for _, changed := range []PolicyPublished{
+		{Domain: "example.com", P: "quarantine"},
+		{Domain: "example.com", P: "reject", Sp: "none"},
+		{Domain: "example.com", P: "reject", Pct: "50"},
+		{Domain: "example.com", P: "reject", Adkim: "s"},
+	} {
+		if changed.Effective() == reported.Effective() {
+			t.Errorf("Expected %v to differ from %v", changed.Tags(), reported.Tags())
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ericdaugherty/dmarc/report"
+)
+
+// JSON API served under /api/v1. The OpenAPI document describing it is
+// public/api/v1/openapi.json.
+
+const (
+	apiDefaultLimit = 100
+	apiMaxLimit     = 1000
+)
+
+// apiError is the body of every error response.
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// apiPage is a page of a list. Next is the cursor for the following page,
+// and is empty on the last page.
+type apiPage struct {
+	Items interface{} `json:"items"`
+	Next  string      `json:"next,omitempty"`
+}
+
+type apiSummary struct {
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Days    []apiDay `json:"days"`
+	Domains []string `json:"domains"`
+	Orgs    []string `json:"orgs"`
+}
+
+type apiDay struct {
+	Date string `json:"date"`
+	apiCounts
+}
+
+type apiCounts struct {
+	Reports     int `json:"reports"`
+	Messages    int `json:"messages"`
+	Accepted    int `json:"accepted"`
+	Quarantined int `json:"quarantined"`
+	Rejected    int `json:"rejected"`
+	Pass        int `json:"pass"`
+	DKIMPass    int `json:"dkimPass"`
+	SPFPass     int `json:"spfPass"`
+}
+
+type apiReport struct {
+	OrgReportID string    `json:"orgReportId"`
+	Date        string    `json:"date"`
+	Domain      string    `json:"domain"`
+	OrgName     string    `json:"orgName"`
+	ReportID    string    `json:"reportId"`
+	Begin       time.Time `json:"begin"`
+	End         time.Time `json:"end"`
+	AuthResult  string    `json:"authResult,omitempty"`
+	Quarantined bool      `json:"quarantined"`
+	apiCounts
+}
+
+type apiReportDetail struct {
+	apiReport
+	Email   string      `json:"email"`
+	Policy  apiPolicy   `json:"policy"`
+	Records []apiRecord `json:"records"`
+}
+
+type apiPolicy struct {
+	Domain string `json:"domain"`
+	Adkim  string `json:"adkim"`
+	Aspf   string `json:"aspf"`
+	P      string `json:"p"`
+	Sp     string `json:"sp"`
+	Pct    string `json:"pct"`
+	Fo     string `json:"fo"`
+}
+
+type apiRecord struct {
+	Report       *apiReportRef   `json:"report,omitempty"`
+	SourceIP     string          `json:"sourceIp"`
+	Count        int             `json:"count"`
+	Disposition  string          `json:"disposition"`
+	DKIM         string          `json:"dkim"`
+	SPF          string          `json:"spf"`
+	Reasons      []apiReason     `json:"reasons,omitempty"`
+	HeaderFrom   string          `json:"headerFrom"`
+	EnvelopeFrom string          `json:"envelopeFrom,omitempty"`
+	DKIMResults  []apiAuthResult `json:"dkimResults,omitempty"`
+	SPFResults   []apiAuthResult `json:"spfResults,omitempty"`
+}
+
+type apiReportRef struct {
+	OrgReportID string `json:"orgReportId"`
+	Date        string `json:"date"`
+	Domain      string `json:"domain"`
+	OrgName     string `json:"orgName"`
+}
+
+type apiReason struct {
+	Type    string `json:"type"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type apiAuthResult struct {
+	Domain   string `json:"domain"`
+	Selector string `json:"selector,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	Result   string `json:"result"`
+}
+
+func (web *web) apiSummary(w http.ResponseWriter, r *http.Request) error {
+	filter, err := parseFilter(r)
+	if err != nil {
+		return err
+	}
+
+	rows, err := web.queryAggregates(r.Context(), filter)
+	if err != nil {
+		return upstreamError(err)
+	}
+
+	res := apiSummary{From: filter.From, To: filter.To, Days: []apiDay{}, Domains: []string{}, Orgs: []string{}}
+	seen := map[string]bool{}
+	byDate := map[string]int{}
+	for _, row := range rows {
+		if !filter.Allows(row.Domain) {
+			continue
+		}
+		if !seen["d:"+row.Domain] {
+			seen["d:"+row.Domain] = true
+			res.Domains = append(res.Domains, row.Domain)
+		}
+		if !seen["o:"+row.OrgName] {
+			seen["o:"+row.OrgName] = true
+			res.Orgs = append(res.Orgs, row.OrgName)
+		}
+		if !filter.Matches(row.Domain, row.OrgName) {
+			continue
+		}
+
+		i, ok := byDate[row.GMTDate]
+		if !ok {
+			i = len(res.Days)
+			byDate[row.GMTDate] = i
+			res.Days = append(res.Days, apiDay{Date: row.GMTDate})
+		}
+		res.Days[i].add(row)
+	}
+	sort.Strings(res.Domains)
+	sort.Strings(res.Orgs)
+
+	writeJSON(w, http.StatusOK, res)
+	return nil
+}
+
+func (web *web) apiReports(w http.ResponseWriter, r *http.Request) error {
+	date := r.URL.Query().Get("date")
+	if _, err := time.Parse(dateFormat, date); err != nil {
+		return badRequest("date must be a date in the form 2006-01-02")
+	}
+	filter, err := newFilter(r)
+	if err != nil {
+		return err
+	}
+
+	after, limit, err := parsePage(r)
+	if err == nil && after.ID != "" && after.Date != date {
+		err = badRequest("invalid cursor")
+	}
+	if err != nil {
+		return err
+	}
+
+	p := pager[apiReport]{limit: limit}
+	page, err := p.page(web.eachStored(r.Context(), []string{date}, after, listAttributes, func(e dbEntry) error {
+		if !filter.Matches(e.Domain, e.OrgName) {
+			return nil
+		}
+		return p.add(newAPIReport(e), pageKey{Date: e.GMTDate, ID: e.OrgReportID})
+	}))
+	if err != nil {
+		return upstreamError(err)
+	}
+
+	writeJSON(w, http.StatusOK, page)
+	return nil
+}
+
+func (web *web) apiReport(w http.ResponseWriter, r *http.Request) error {
+	entry, err := web.loadReport(r)
+	if err != nil {
+		return err
+	}
+
+	f := entry.Feedback()
+	p := f.PolicyPublished
+	res := apiReportDetail{
+		apiReport: newAPIReport(entry),
+		Email:     f.ReportMetadata.Email,
+		Policy:    apiPolicy{Domain: p.Domain, Adkim: p.Adkim, Aspf: p.Aspf, P: p.P, Sp: p.Sp, Pct: p.Pct, Fo: p.Fo},
+		Records:   []apiRecord{},
+	}
+	for _, rec := range f.Record {
+		res.Records = append(res.Records, newAPIRecord(rec))
+	}
+
+	writeJSON(w, http.StatusOK, res)
+	return nil
+}
+
+func (web *web) apiRecords(w http.ResponseWriter, r *http.Request) error {
+	var ip net.IP
+	if s := r.URL.Query().Get("source"); s != "" {
+		if ip = net.ParseIP(s); ip == nil {
+			return badRequest("invalid source IP %q", s)
+		}
+	}
+
+	filter, err := parseFilter(r)
+	if err == nil && filter.Days > maxSourceDays {
+		err = badRequest("records can be searched over at most %v days", maxSourceDays)
+	}
+	if err != nil {
+		return err
+	}
+
+	after, limit, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	p := pager[apiRecord]{limit: limit}
+	page, err := p.page(web.eachRecordAfter(r.Context(), filter, ip, after, func(f sourceRecord) error {
+		return p.add(newAPISourceRecord(f), f.key())
+	}))
+	if err != nil {
+		return upstreamError(err)
+	}
+
+	writeJSON(w, http.StatusOK, page)
+	return nil
+}
+
+func (web *web) apiNotFound(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusNotFound, fmt.Sprintf("no API endpoint at %v", r.URL.Path))
+}
+
+func (web *web) apiMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %v is not allowed for %v", r.Method, r.URL.Path))
+}
+
+func newAPIReport(e dbEntry) apiReport {
+	return apiReport{
+		OrgReportID: e.OrgReportID,
+		Date:        e.GMTDate,
+		Domain:      e.Domain,
+		OrgName:     e.OrgName,
+		ReportID:    e.ReportID,
+		Begin:       time.Unix(int64(e.BeginTime), 0).UTC(),
+		End:         time.Unix(int64(e.EndTime), 0).UTC(),
+		AuthResult:  e.AuthResult,
+		Quarantined: e.Quarantined,
+		apiCounts: apiCounts{
+			Reports:     1,
+			Messages:    e.CountAccepted + e.CountQuarantined + e.CountRejected,
+			Accepted:    e.CountAccepted,
+			Quarantined: e.CountQuarantined,
+			Rejected:    e.CountRejected,
+			Pass:        e.CountPass,
+			DKIMPass:    e.CountDKIMPass,
+			SPFPass:     e.CountSPFPass,
+		},
+	}
+}
+
+// newAPISourceRecord returns a record with a reference to its report.
+func newAPISourceRecord(f sourceRecord) apiRecord {
+	rec := newAPIRecord(f.Record)
+	rec.Report = &apiReportRef{OrgReportID: f.Entry.OrgReportID, Date: f.Entry.GMTDate, Domain: f.Entry.Domain, OrgName: f.Entry.OrgName}
+	return rec
+}
+
+func newAPIRecord(rec report.Record) (r apiRecord) {
+	r = apiRecord{
+		SourceIP:     rec.Row.SourceIP,
+		Count:        atoi(rec.Row.Count),
+		Disposition:  rec.Row.PolicyEvaluated.Disposition,
+		DKIM:         rec.Row.PolicyEvaluated.Dkim,
+		SPF:          rec.Row.PolicyEvaluated.Spf,
+		HeaderFrom:   rec.Identifiers.HeaderFrom,
+		EnvelopeFrom: rec.Identifiers.EnvelopeFrom,
+	}
+	for _, reason := range rec.Row.PolicyEvaluated.Reason {
+		r.Reasons = append(r.Reasons, apiReason{Type: reason.Type, Comment: reason.Comment})
+	}
+	for _, d := range rec.AuthResults.Dkim {
+		r.DKIMResults = append(r.DKIMResults, apiAuthResult{Domain: d.Domain, Selector: d.Selector, Result: d.Result})
+	}
+	for _, s := range rec.AuthResults.Spf {
+		r.SPFResults = append(r.SPFResults, apiAuthResult{Domain: s.Domain, Scope: s.Scope, Result: s.Result})
+	}
+	return
+}
+
+func (d *apiDay) add(row aggRow) {
+	d.Reports += row.Reports
+	d.Messages += row.Total()
+	d.Accepted += row.CountAccepted
+	d.Quarantined += row.CountQuarantined
+	d.Rejected += row.CountRejected
+	d.Pass += row.CountPass
+	d.DKIMPass += row.CountDKIMPass
+	d.SPFPass += row.CountSPFPass
+}
+
+// pageKey is where a page of a list ends, in the order lists are read: the
+// date and orgReportId of the report its last item comes from and, for lists
+// of records, the index of that record in the report. The cursor for the
+// next page is its opaque form, so that page is read from the report on
+// rather than from the start of the list.
+type pageKey struct {
+	Date   string `json:"d"`
+	ID     string `json:"r"`
+	Record int    `json:"i,omitempty"`
+}
+
+func (k pageKey) cursor() string {
+	b, _ := json.Marshal(k)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// parsePage reads the cursor and limit parameters. after is the zero key
+// for the first page.
+func parsePage(r *http.Request) (after pageKey, limit int, err error) {
+	limit = apiDefaultLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > apiMaxLimit {
+			return after, 0, badRequest("limit must be between 1 and %v", apiMaxLimit)
+		}
+	}
+
+	if s := r.URL.Query().Get("cursor"); s != "" {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err == nil {
+			err = json.Unmarshal(b, &after)
+		}
+		if err == nil {
+			_, err = time.Parse(dateFormat, after.Date)
+		}
+		if err != nil || after.ID == "" || after.Record < 0 {
+			return pageKey{}, 0, badRequest("invalid cursor")
+		}
+	}
+	return
+}
+
+// errPageFull stops reading a list once an item beyond the page is found.
+var errPageFull = errors.New("page full")
+
+// pager collects a page of at most limit items.
+type pager[T any] struct {
+	limit int
+	items []T
+	last  pageKey
+	next  string
+}
+
+// add adds an item found at key to the page. Once the page is full it
+// returns errPageFull, which the list should be stopped with.
+func (p *pager[T]) add(item T, key pageKey) error {
+	if len(p.items) == p.limit {
+		p.next = p.last.cursor()
+		return errPageFull
+	}
+	p.items = append(p.items, item)
+	p.last = key
+	return nil
+}
+
+// page returns the page collected, or the error reading the list stopped
+// with if it was not errPageFull.
+func (p *pager[T]) page(err error) (apiPage, error) {
+	if err != nil && err != errPageFull {
+		return apiPage{}, err
+	}
+	items := p.items
+	if items == nil {
+		items = []T{}
+	}
+	return apiPage{Items: items, Next: p.next}, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Printf("Error writing JSON response. %v\n", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: apiErrorDetail{Status: status, Message: message}})
+}
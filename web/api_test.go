@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordReport returns a stored report for example.com on date with a
+// record for each of ips.
+func recordReport(id, date string, ips ...string) dbEntry {
+	begin, _ := time.Parse(dateFormat, date)
+	var records string
+	for _, ip := range ips {
+		records += fmt.Sprintf("<record><row><source_ip>%v</source_ip><count>1</count></row></record>", ip)
+	}
+	return dbEntry{
+		GMTDate:     date,
+		OrgReportID: id,
+		Domain:      "example.com",
+		OrgName:     "google.com",
+		BeginTime:   int(begin.Unix()),
+		EndTime:     int(begin.Add(24*time.Hour - time.Second).Unix()),
+		XML:         "<feedback>" + records + "</feedback>",
+	}
+}
+
+// pageTestRouter returns the handler reading from three reports on
+// 2024-03-01 and one on 2024-03-02.
+func pageTestRouter(t *testing.T) http.Handler {
+	useDB(t, fakeDynamoDB{reports: []dbEntry{
+		recordReport("b", "2024-03-01", "192.0.2.3", "192.0.2.4"),
+		recordReport("a", "2024-03-01", "192.0.2.1", "192.0.2.2"),
+		recordReport("c", "2024-03-01", "192.0.2.5"),
+		recordReport("d", "2024-03-02", "192.0.2.6", "192.0.2.7", "192.0.2.8"),
+	}})
+	return (&router{location: time.UTC}).handler()
+}
+
+// getPage returns the items of the page at path, decoded into items, and the
+// cursor of the next page.
+func getPage(t *testing.T, h http.Handler, path string, items interface{}) string {
+	w := serve(h, httptest.NewRequest("GET", path, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %v from %v but got %v: %v", http.StatusOK, path, w.Code, w.Body.String())
+	}
+	page := struct {
+		Items json.RawMessage
+		Next  string
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(page.Items, items); err != nil {
+		t.Fatal(err)
+	}
+	return page.Next
+}
+
+func TestReportPages(t *testing.T) {
+	h := pageTestRouter(t)
+
+	var ids []string
+	path := "/api/v1/reports?date=2024-03-01&limit=2"
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatalf("Expected the last page by now but got %v", ids)
+		}
+		var reports []apiReport
+		next := getPage(t, h, path, &reports)
+		for _, r := range reports {
+			ids = append(ids, r.OrgReportID)
+		}
+		if next == "" {
+			break
+		}
+		path = "/api/v1/reports?date=2024-03-01&limit=2&cursor=" + next
+	}
+
+	expected := []string{"a", "b", "c"}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Expected %v but got %v", expected, ids)
+	}
+}
+
+func TestRecordPages(t *testing.T) {
+	h := pageTestRouter(t)
+	const query = "/api/v1/records?from=2024-03-01&to=2024-03-02"
+
+	expected := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4", "192.0.2.5", "192.0.2.6", "192.0.2.7", "192.0.2.8"}
+	for _, limit := range []int{1, 3, 8, 100} {
+		var ips []string
+		path := fmt.Sprintf("%v&limit=%v", query, limit)
+		for pages := 0; ; pages++ {
+			if pages > len(expected) {
+				t.Fatalf("Expected the last page by now but got %v", ips)
+			}
+			var records []apiRecord
+			next := getPage(t, h, path, &records)
+			if len(records) > limit {
+				t.Errorf("Expected at most %v records but got %v", limit, len(records))
+			}
+			for _, r := range records {
+				ips = append(ips, r.SourceIP)
+			}
+			if next == "" {
+				break
+			}
+			path = fmt.Sprintf("%v&limit=%v&cursor=%v", query, limit, next)
+		}
+		if !reflect.DeepEqual(ips, expected) {
+			t.Errorf("Expected %v with a limit of %v but got %v", expected, limit, ips)
+		}
+	}
+
+	// A cursor into the middle of a report continues with its next record.
+	var records []apiRecord
+	cursor := pageKey{Date: "2024-03-02", ID: "d", Record: 1}.cursor()
+	if next := getPage(t, h, query+"&limit=2&cursor="+cursor, &records); next != "" {
+		t.Errorf("Expected the last page but got the cursor %v", next)
+	}
+	var ips []string
+	for _, r := range records {
+		ips = append(ips, r.SourceIP)
+		if r.Report == nil || r.Report.OrgReportID != "d" {
+			t.Errorf("Expected %v to be from report d but got %v", r.SourceIP, r.Report)
+		}
+	}
+	if expected := []string{"192.0.2.8"}; !reflect.DeepEqual(ips, expected) {
+		t.Errorf("Expected %v but got %v", expected, ips)
+	}
+}
+
+func TestInvalidCursor(t *testing.T) {
+	h := pageTestRouter(t)
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		path, cursor string
+	}{
+		{"/api/v1/records", "not base64!"},
+		{"/api/v1/records", encode("not json")},
+		{"/api/v1/records", encode(`{"d":"2024-03-01"}`)},
+		{"/api/v1/records", encode(`{"d":"yesterday","r":"a"}`)},
+		{"/api/v1/records", encode(`{"d":"2024-03-01","r":"a","i":-1}`)},
+		{"/api/v1/records", pageKey{Date: "2024-03-01", ID: "a"}.cursor() + "x"},
+		{"/api/v1/reports?date=2024-03-02", pageKey{Date: "2024-03-01", ID: "a"}.cursor()},
+	}
+	for _, test := range tests {
+		path := test.path
+		if strings.Contains(path, "?") {
+			path += "&cursor=" + url.QueryEscape(test.cursor)
+		} else {
+			path += "?from=2024-03-01&to=2024-03-02&cursor=" + url.QueryEscape(test.cursor)
+		}
+		w := serve(h, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid cursor") {
+			t.Errorf("Expected %v for %v but got %v: %v", http.StatusBadRequest, path, w.Code, w.Body.String())
+		}
+	}
+}
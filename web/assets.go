@@ -0,0 +1,42 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// embedded holds the templates and public files built into the binary.
+//
+//go:embed templates public
+var embedded embed.FS
+
+// assets returns the filesystem the templates and public files are read
+// from. In dev mode they are read from the working directory, so edits show
+// up without a rebuild.
+func assets(devMode bool) fs.FS {
+	if devMode {
+		return os.DirFS(".")
+	}
+	return embedded
+}
+
+// lastModified returns the latest modification time of the files under dir.
+// Embedded files have no modification time, so it is always zero for them.
+func lastModified(fsys fs.FS, dir string) (latest time.Time, err error) {
+	err = fs.WalkDir(fsys, dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Authentication is configured with environment variables:
+//
+//	AUTH             basic, oidc, or none to make every page public.
+//	AUTH_USERS       JSON list of users, see user.
+//	AUTH_TOKENS      JSON list of API tokens, see apiToken.
+//	SESSION_KEY      secret used to sign the OIDC session cookie.
+//	OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, OIDC_REDIRECT_URL
+//	                 the OIDC provider, and the URL of /auth/callback.
+
+const (
+	authBasic = "basic"
+	authOIDC  = "oidc"
+	authNone  = "none"
+)
+
+const sessionCookie = "dmarc_session"
+
+// sessionLifetime is how long an OIDC login lasts.
+const sessionLifetime = 12 * time.Hour
+
+// user is someone allowed to use the web module. Basic authentication
+// matches Name and PasswordHash, a bcrypt hash, and OIDC matches Email. A
+// user with Domains only sees reports for those domains, and one with
+// Timezone sees times and days in that IANA time zone.
+type user struct {
+	Name         string   `json:"name"`
+	Email        string   `json:"email"`
+	PasswordHash string   `json:"passwordHash"`
+	Domains      []string `json:"domains"`
+	Timezone     string   `json:"timezone"`
+
+	location *time.Location
+}
+
+// apiToken is a static bearer token for the JSON API and exports. Only the
+// hex SHA-256 hash of the token is configured.
+type apiToken struct {
+	Name    string   `json:"name"`
+	SHA256  string   `json:"sha256"`
+	Domains []string `json:"domains"`
+}
+
+// auth authenticates requests. A nil *auth lets every request through.
+type auth struct {
+	method     string
+	users      []user
+	tokens     []apiToken
+	sessionKey []byte
+	oidc       *oidcLogin
+	secure     bool
+}
+
+type userKey struct{}
+
+// loadAuth reads the authentication configuration from the environment,
+// returning nil if AUTH is none. AUTH must be set, so a deployment missing
+// it fails to start rather than serving every page publicly.
+func loadAuth(secure bool) (a *auth, err error) {
+	method := os.Getenv("AUTH")
+	if method == authNone {
+		return
+	}
+	if method != authBasic && method != authOIDC {
+		return nil, fmt.Errorf("AUTH must be %v, %v or %v", authBasic, authOIDC, authNone)
+	}
+
+	a = &auth{method: method, secure: secure}
+	if s := os.Getenv("AUTH_USERS"); s != "" {
+		if err = json.Unmarshal([]byte(s), &a.users); err != nil {
+			return nil, fmt.Errorf("invalid AUTH_USERS. %v", err)
+		}
+	}
+	for i, u := range a.users {
+		if u.Timezone == "" {
+			continue
+		}
+		if a.users[i].location, err = time.LoadLocation(u.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q in AUTH_USERS. %v", u.Timezone, err)
+		}
+	}
+	if s := os.Getenv("AUTH_TOKENS"); s != "" {
+		if err = json.Unmarshal([]byte(s), &a.tokens); err != nil {
+			return nil, fmt.Errorf("invalid AUTH_TOKENS. %v", err)
+		}
+	}
+
+	if method == authOIDC {
+		if a.sessionKey = []byte(os.Getenv("SESSION_KEY")); len(a.sessionKey) < 32 {
+			return nil, fmt.Errorf("SESSION_KEY must be at least 32 bytes")
+		}
+		a.oidc, err = newOIDCLogin(os.Getenv("OIDC_ISSUER"), os.Getenv("OIDC_CLIENT_ID"),
+			os.Getenv("OIDC_CLIENT_SECRET"), os.Getenv("OIDC_REDIRECT_URL"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// pages requires a logged in user, asking for a password or redirecting to
+// the OIDC provider if there is none.
+func (a *auth) pages(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := a.pageUser(r)
+		if u == nil {
+			if a.method == authOIDC {
+				http.Redirect(w, r, a.oidc.loginURL(r.URL.RequestURI()), http.StatusFound)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Basic realm="DMARC", charset="UTF-8"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			}
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, u)))
+	})
+}
+
+// api requires an API token or a logged in user, responding with a JSON
+// error if there is neither.
+func (a *auth) api(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := a.tokenUser(r)
+		if u == nil {
+			u = a.pageUser(r)
+		}
+		if u == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="DMARC"`)
+			writeAPIError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, u)))
+	})
+}
+
+// pageUser returns the user from the basic authentication header or the
+// session cookie, or nil if there is none.
+func (a *auth) pageUser(r *http.Request) *user {
+	if a.method == authBasic {
+		name, password, ok := r.BasicAuth()
+		if !ok {
+			return nil
+		}
+		for i, u := range a.users {
+			if u.Name == name && u.PasswordHash != "" &&
+				bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil {
+				return &a.users[i]
+			}
+		}
+		return nil
+	}
+
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	var s session
+	if !a.verify(c.Value, &s) || time.Now().Unix() > s.Expires {
+		return nil
+	}
+	return a.userByEmail(s.Email)
+}
+
+// tokenUser returns a user for the bearer token of r, or nil if there is
+// none or it is not configured.
+func (a *auth) tokenUser(r *http.Request) *user {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return nil
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	hash := hex.EncodeToString(sum[:])
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(strings.ToLower(t.SHA256)), []byte(hash)) == 1 {
+			return &user{Name: t.Name, Domains: t.Domains}
+		}
+	}
+	return nil
+}
+
+func (a *auth) userByEmail(email string) *user {
+	for i, u := range a.users {
+		if u.Email != "" && strings.EqualFold(u.Email, email) {
+			return &a.users[i]
+		}
+	}
+	return nil
+}
+
+// session is the content of the session cookie.
+type session struct {
+	Email   string `json:"email"`
+	Expires int64  `json:"expires"`
+}
+
+// sign returns v encoded as JSON along with its signature.
+func (a *auth) sign(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	mac := hmac.New(sha256.New, a.sessionKey)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verify decodes a value signed by sign into v, returning false if the
+// signature does not match.
+func (a *auth) verify(signed string, v interface{}) bool {
+	payload, sig, ok := strings.Cut(signed, ".")
+	if !ok {
+		return false
+	}
+	mac := hmac.New(sha256.New, a.sessionKey)
+	mac.Write([]byte(payload))
+	expected := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	return err == nil && json.Unmarshal(b, v) == nil
+}
+
+func (a *auth) setCookie(w http.ResponseWriter, name, value string, maxAge time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   a.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (a *auth) clearCookie(w http.ResponseWriter, name string) {
+	a.setCookie(w, name, "", -time.Second)
+}
+
+// currentUser returns the user making r, or nil if authentication is off.
+func currentUser(r *http.Request) *user {
+	u, _ := r.Context().Value(userKey{}).(*user)
+	return u
+}
+
+// allowedDomains returns the domains the user making r may see, or nil for
+// every domain.
+func allowedDomains(r *http.Request) []string {
+	if u := currentUser(r); u != nil {
+		return u.Domains
+	}
+	return nil
+}
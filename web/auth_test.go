@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeDynamoDB answers the key queries of the web module from reports and
+// aggregates held in memory. Items are returned in order of their sort key,
+// starting after the ExclusiveStartKey of the query, and pageSize at a time
+// if it is set.
+type fakeDynamoDB struct {
+	reports    []dbEntry
+	aggregates []aggRow
+	pageSize   int
+}
+
+func (f fakeDynamoDB) Query(ctx context.Context, in *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	key := in.ExpressionAttributeNames["#k"]
+	value := in.ExpressionAttributeValues[":v"].(*types.AttributeValueMemberS).Value
+
+	type item struct {
+		sortKey string
+		value   interface{}
+	}
+	var items []item
+	sortKey := "orgReportId"
+	switch aws.StringValue(in.TableName) {
+	case aggregatesTable:
+		sortKey = "aggregateKey"
+		for _, row := range f.aggregates {
+			if row.GMTDate == value {
+				items = append(items, item{row.AggregateKey, row})
+			}
+		}
+	case reportsTable:
+		for _, e := range f.reports {
+			if (key == "gmtDate" && e.GMTDate == value) || (key == "orgReportId" && e.OrgReportID == value) {
+				items = append(items, item{e.OrgReportID, e})
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].sortKey < items[j].sortKey })
+
+	if start, ok := in.ExclusiveStartKey[sortKey].(*types.AttributeValueMemberS); ok {
+		for len(items) > 0 && items[0].sortKey <= start.Value {
+			items = items[1:]
+		}
+	}
+
+	out := &dynamodb.QueryOutput{}
+	if f.pageSize > 0 && len(items) > f.pageSize {
+		items = items[:f.pageSize]
+		out.LastEvaluatedKey = map[string]types.AttributeValue{
+			key:     &types.AttributeValueMemberS{Value: value},
+			sortKey: &types.AttributeValueMemberS{Value: items[len(items)-1].sortKey},
+		}
+	}
+	for _, item := range items {
+		av, err := attributevalue.MarshalMap(item.value)
+		if err != nil {
+			return nil, err
+		}
+		out.Items = append(out.Items, av)
+	}
+	return out, nil
+}
+
+func (f fakeDynamoDB) GetItem(ctx context.Context, in *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	date := in.Key["gmtDate"].(*types.AttributeValueMemberS).Value
+	id := in.Key["orgReportId"].(*types.AttributeValueMemberS).Value
+	for _, e := range f.reports {
+		if e.GMTDate == date && e.OrgReportID == id {
+			av, err := attributevalue.MarshalMap(e)
+			return &dynamodb.GetItemOutput{Item: av}, err
+		}
+	}
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+// testRouter returns the handler for a, reading from a fake store holding a
+// report and the totals of yesterday for example.com and example.org.
+func testRouter(t *testing.T, a *auth) http.Handler {
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(dateFormat)
+	db := fakeDynamoDB{
+		reports: []dbEntry{
+			{GMTDate: yesterday, OrgReportID: "google.com!com-1", Domain: "example.com", OrgName: "google.com", ReportID: "com-1", CountAccepted: 3},
+			{GMTDate: yesterday, OrgReportID: "google.com!org-1", Domain: "example.org", OrgName: "google.com", ReportID: "org-1", CountAccepted: 5},
+		},
+		aggregates: []aggRow{
+			{GMTDate: yesterday, AggregateKey: "example.com#google.com", Domain: "example.com", OrgName: "google.com", Reports: 1, CountAccepted: 3},
+			{GMTDate: yesterday, AggregateKey: "example.org#google.com", Domain: "example.org", OrgName: "google.com", Reports: 1, CountAccepted: 5},
+		},
+	}
+
+	useDB(t, db)
+
+	r := router{auth: a, location: time.UTC}
+	return r.handler()
+}
+
+// useDB makes the handlers read from db until the test ends.
+func useDB(t *testing.T, db fakeDynamoDB) {
+	old := newDynamoDB
+	newDynamoDB = func(context.Context) (dynamoDB, error) { return db, nil }
+	t.Cleanup(func() { newDynamoDB = old })
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func hashPassword(t *testing.T, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestBasicAuth(t *testing.T) {
+	h := testRouter(t, &auth{method: authBasic, users: []user{{Name: "alice", PasswordHash: hashPassword(t, "secret")}}})
+
+	tests := []struct {
+		name, user, password string
+		status               int
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized},
+		{"wrong password", "alice", "guess", http.StatusUnauthorized},
+		{"unknown user", "bob", "secret", http.StatusUnauthorized},
+		{"right password", "alice", "secret", http.StatusOK},
+	}
+	for _, test := range tests {
+		for _, path := range []string{"/reporters/", "/api/v1/summary"} {
+			r := httptest.NewRequest("GET", path, nil)
+			if test.user != "" {
+				r.SetBasicAuth(test.user, test.password)
+			}
+			if w := serve(h, r); w.Code != test.status {
+				t.Errorf("%v: Expected %v from %v but got %v", test.name, test.status, path, w.Code)
+			}
+		}
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	sum := sha256.Sum256([]byte("token"))
+	h := testRouter(t, &auth{method: authBasic, tokens: []apiToken{{Name: "ci", SHA256: hex.EncodeToString(sum[:])}}})
+
+	tests := []struct {
+		header string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"token", http.StatusUnauthorized},
+		{"Bearer token", http.StatusOK},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/api/v1/summary", nil)
+		if test.header != "" {
+			r.Header.Set("Authorization", test.header)
+		}
+		if w := serve(h, r); w.Code != test.status {
+			t.Errorf("Expected %v for %q but got %v", test.status, test.header, w.Code)
+		}
+	}
+
+	// Tokens are for the API, not the pages.
+	r := httptest.NewRequest("GET", "/reporters/", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	if w := serve(h, r); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected %v for a page but got %v", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestSessionCookie(t *testing.T) {
+	a := &auth{method: authOIDC, users: []user{{Email: "alice@example.com"}}, sessionKey: []byte(strings.Repeat("k", 32))}
+	h := testRouter(t, a)
+
+	sign := func(a *auth, s session) string {
+		value, err := a.sign(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	valid := sign(a, session{Email: "alice@example.com", Expires: time.Now().Add(time.Hour).Unix()})
+	payload, sig, _ := strings.Cut(valid, ".")
+	other := sign(&auth{sessionKey: []byte(strings.Repeat("x", 32))}, session{Email: "alice@example.com", Expires: time.Now().Add(time.Hour).Unix()})
+	forged := sign(a, session{Email: "mallory@example.com", Expires: time.Now().Add(time.Hour).Unix()})
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	tests := []struct {
+		name, cookie string
+		status       int
+	}{
+		{"valid", valid, http.StatusOK},
+		{"expired", sign(a, session{Email: "alice@example.com", Expires: time.Now().Add(-time.Minute).Unix()}), http.StatusUnauthorized},
+		{"other key", other, http.StatusUnauthorized},
+		{"tampered payload", forgedPayload + "." + sig, http.StatusUnauthorized},
+		{"tampered signature", payload + "." + sig[1:], http.StatusUnauthorized},
+		{"unsigned", payload, http.StatusUnauthorized},
+		{"unknown user", forged, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/api/v1/summary", nil)
+		r.AddCookie(&http.Cookie{Name: sessionCookie, Value: test.cookie})
+		if w := serve(h, r); w.Code != test.status {
+			t.Errorf("%v: Expected %v but got %v", test.name, test.status, w.Code)
+		}
+	}
+}
+
+func TestScopedUser(t *testing.T) {
+	h := testRouter(t, &auth{method: authBasic, users: []user{
+		{Name: "alice", PasswordHash: hashPassword(t, "secret"), Domains: []string{"example.com"}},
+	}})
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/report/google.com!com-1/", http.StatusOK},
+		{"/report/google.com!org-1/", http.StatusNotFound},
+		{"/report/google.com!org-1/xml", http.StatusNotFound},
+		{"/?domain=example.org", http.StatusNotFound},
+		{"/feeds/example.com/failures.atom", http.StatusOK},
+		{"/feeds/example.org/failures.atom", http.StatusNotFound},
+		{"/export/summary?domain=example.com", http.StatusOK},
+		{"/export/summary?domain=example.org", http.StatusNotFound},
+		{"/export/records?domain=example.org", http.StatusNotFound},
+		{"/api/v1/summary?domain=example.com", http.StatusOK},
+		{"/api/v1/summary?domain=example.org", http.StatusNotFound},
+		{"/api/v1/reports?date=2024-01-01&domain=example.org", http.StatusNotFound},
+		{"/api/v1/records?domain=example.org", http.StatusNotFound},
+		{"/api/v1/search?domain=example.org&ip=192.0.2.1", http.StatusNotFound},
+		{"/api/v1/reports/google.com!com-1", http.StatusOK},
+		{"/api/v1/reports/google.com!org-1", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.SetBasicAuth("alice", "secret")
+		if w := serve(h, r); w.Code != test.status {
+			t.Errorf("Expected %v from %v but got %v", test.status, test.path, w.Code)
+		}
+	}
+
+	// Without a domain only the reports of the user's domains are listed.
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(dateFormat)
+	for _, path := range []string{"/export/summary", "/api/v1/reports?date=" + yesterday} {
+		r := httptest.NewRequest("GET", path, nil)
+		r.SetBasicAuth("alice", "secret")
+		w := serve(h, r)
+		if !strings.Contains(w.Body.String(), "example.com") || strings.Contains(w.Body.String(), "example.org") {
+			t.Errorf("Expected %v to only include example.com but got %q", path, w.Body.String())
+		}
+	}
+}
+
+func TestScopedMetrics(t *testing.T) {
+	sum := sha256.Sum256([]byte("token"))
+	h := testRouter(t, &auth{method: authBasic, tokens: []apiToken{
+		{Name: "prometheus", SHA256: hex.EncodeToString(sum[:]), Domains: []string{"example.com"}},
+	}})
+
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	w := serve(h, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %v but got %v", http.StatusOK, w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `dmarc_reports{domain="example.com"} 1`) {
+		t.Errorf("Expected the reports of example.com but got %q", body)
+	}
+	if strings.Contains(body, "example.org") {
+		t.Errorf("Expected no metrics for example.org but got %q", body)
+	}
+}
+
+func TestLoadAuth(t *testing.T) {
+	for _, test := range []struct {
+		method string
+		ok     bool
+	}{
+		{"", false},
+		{"public", false},
+		{"none", true},
+		{"basic", true},
+	} {
+		t.Setenv("AUTH", test.method)
+		a, err := loadAuth(false)
+		if (err == nil) != test.ok {
+			t.Errorf("Expected AUTH=%q to be accepted %v but got %v", test.method, test.ok, err)
+		}
+		if test.method == "none" && a != nil {
+			t.Errorf("Expected no authentication for AUTH=none but got %v", a.method)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"strings"
+)
+
+// Charts are drawn as inline SVG so the pages need no scripts.
+
+const (
+	chartWidth  = 720
+	chartHeight = 220
+	chartLeft   = 48
+	chartRight  = 8
+	chartTop    = 24
+	chartBottom = 24
+	// chartLabels is the most x axis labels drawn, so they do not overlap.
+	chartLabels = 8
+)
+
+// chartSeries is a named set of values, one for each label of a chart. A
+// NaN value is missing and left out of the chart.
+type chartSeries struct {
+	Name   string
+	Color  string
+	Values []float64
+}
+
+// chartBar is a bar of a horizontal bar chart, linking to URL if it is set.
+type chartBar struct {
+	Label string
+	Value int
+	URL   string
+}
+
+// stackedBarChart draws a bar for each label with the values of every series
+// stacked on top of each other.
+func stackedBarChart(labels []string, series []chartSeries) template.HTML {
+	var max float64
+	for i := range labels {
+		var total float64
+		for _, s := range series {
+			if v := value(s.Values, i); !math.IsNaN(v) {
+				total += v
+			}
+		}
+		max = math.Max(max, total)
+	}
+
+	var b strings.Builder
+	startChart(&b, chartHeight)
+	drawLegend(&b, series)
+	drawYAxis(&b, max, func(v float64) string { return fmt.Sprintf("%.0f", v) })
+	drawXLabels(&b, labels)
+
+	slot := plotWidth() / float64(len(labels))
+	for i, label := range labels {
+		y := float64(chartHeight - chartBottom)
+		for _, s := range series {
+			v := value(s.Values, i)
+			if v == 0 || math.IsNaN(v) {
+				continue
+			}
+			h := v / max * plotHeight()
+			y -= h
+			fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%v"><title>%v %v: %.0f</title></rect>`,
+				chartLeft+float64(i)*slot+slot*0.1, y, slot*0.8, h, s.Color, escape(label), escape(s.Name), v)
+		}
+	}
+
+	b.WriteString("</svg>")
+	return template.HTML(b.String())
+}
+
+// lineChart draws a line for each series of percentages.
+func lineChart(labels []string, series []chartSeries) template.HTML {
+	var b strings.Builder
+	startChart(&b, chartHeight)
+	drawLegend(&b, series)
+	drawYAxis(&b, 100, func(v float64) string { return fmt.Sprintf("%.0f%%", v) })
+	drawXLabels(&b, labels)
+
+	slot := plotWidth() / float64(len(labels))
+	for _, s := range series {
+		var path strings.Builder
+		move := true
+		for i, label := range labels {
+			v := value(s.Values, i)
+			if math.IsNaN(v) {
+				move = true
+				continue
+			}
+			x := chartLeft + float64(i)*slot + slot/2
+			y := chartHeight - chartBottom - v/100*plotHeight()
+			if move {
+				fmt.Fprintf(&path, "M%.1f %.1f ", x, y)
+			} else {
+				fmt.Fprintf(&path, "L%.1f %.1f ", x, y)
+			}
+			move = false
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="2.5" fill="%v"><title>%v %v: %.1f%%</title></circle>`,
+				x, y, s.Color, escape(label), escape(s.Name), v)
+		}
+		if path.Len() > 0 {
+			fmt.Fprintf(&b, `<path d="%v" fill="none" stroke="%v" stroke-width="2"/>`, strings.TrimSpace(path.String()), s.Color)
+		}
+	}
+
+	b.WriteString("</svg>")
+	return template.HTML(b.String())
+}
+
+// barChart draws a horizontal bar for each of bars, in order.
+func barChart(bars []chartBar, color string) template.HTML {
+	const rowHeight, labelWidth = 22, 160
+
+	var max int
+	for _, bar := range bars {
+		if bar.Value > max {
+			max = bar.Value
+		}
+	}
+
+	var b strings.Builder
+	startChart(&b, len(bars)*rowHeight+4)
+	width := float64(chartWidth - labelWidth - 64)
+	for i, bar := range bars {
+		y := i*rowHeight + 2
+		if bar.URL != "" {
+			fmt.Fprintf(&b, `<a href="%v">`, escape(bar.URL))
+		}
+		fmt.Fprintf(&b, `<text x="%v" y="%v" text-anchor="end" font-size="12">%v</text>`,
+			labelWidth-6, y+rowHeight/2+4, escape(bar.Label))
+		if bar.URL != "" {
+			b.WriteString("</a>")
+		}
+		// Every bar is empty if every value is 0.
+		var w float64
+		if max > 0 {
+			w = float64(bar.Value) / float64(max) * width
+		}
+		fmt.Fprintf(&b, `<rect x="%v" y="%v" width="%.1f" height="%v" fill="%v"/>`, labelWidth, y+2, w, rowHeight-4, color)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%v" font-size="12">%v</text>`, labelWidth+w+4, y+rowHeight/2+4, bar.Value)
+	}
+
+	b.WriteString("</svg>")
+	return template.HTML(b.String())
+}
+
+func startChart(b *strings.Builder, height int) {
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%v" height="%v" viewBox="0 0 %v %v" font-family="sans-serif">`,
+		chartWidth, height, chartWidth, height)
+}
+
+func drawLegend(b *strings.Builder, series []chartSeries) {
+	x := chartLeft
+	for _, s := range series {
+		fmt.Fprintf(b, `<rect x="%v" y="4" width="12" height="12" fill="%v"/><text x="%v" y="14" font-size="12">%v</text>`,
+			x, s.Color, x+16, escape(s.Name))
+		x += 16 + 8*len(s.Name) + 16
+	}
+}
+
+// drawYAxis draws grid lines at zero, half of max and max.
+func drawYAxis(b *strings.Builder, max float64, format func(float64) string) {
+	for _, f := range []float64{0, 0.5, 1} {
+		y := chartHeight - chartBottom - f*plotHeight()
+		fmt.Fprintf(b, `<line x1="%v" y1="%.1f" x2="%v" y2="%.1f" stroke="#ddd"/>`, chartLeft, y, chartWidth-chartRight, y)
+		fmt.Fprintf(b, `<text x="%v" y="%.1f" text-anchor="end" font-size="11">%v</text>`, chartLeft-4, y+4, format(f*max))
+	}
+}
+
+func drawXLabels(b *strings.Builder, labels []string) {
+	slot := plotWidth() / float64(len(labels))
+	step := (len(labels) + chartLabels - 1) / chartLabels
+	for i := 0; i < len(labels); i += step {
+		fmt.Fprintf(b, `<text x="%.1f" y="%v" text-anchor="middle" font-size="11">%v</text>`,
+			chartLeft+float64(i)*slot+slot/2, chartHeight-6, escape(labels[i]))
+	}
+}
+
+func plotWidth() float64 {
+	return chartWidth - chartLeft - chartRight
+}
+
+func plotHeight() float64 {
+	return chartHeight - chartTop - chartBottom
+}
+
+// value returns values[i], or NaN if there is no such value.
+func value(values []float64, i int) float64 {
+	if i >= len(values) {
+		return math.NaN()
+	}
+	return values[i]
+}
+
+func escape(s string) string {
+	return template.HTMLEscapeString(s)
+}
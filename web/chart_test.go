@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBarChart(t *testing.T) {
+	tests := []struct {
+		values []int
+		widths []string
+	}{
+		{[]int{0}, []string{`width="0.0"`}},
+		{[]int{0, 0}, []string{`width="0.0"`, `width="0.0"`}},
+		{[]int{4, 2, 0}, []string{`width="496.0"`, `width="248.0"`, `width="0.0"`}},
+	}
+	for _, test := range tests {
+		var bars []chartBar
+		for _, v := range test.values {
+			bars = append(bars, chartBar{Label: "192.0.2.1", Value: v})
+		}
+		svg := string(barChart(bars, failColor))
+		if strings.Contains(svg, "NaN") {
+			t.Errorf("Expected no NaN for %v but got %v", test.values, svg)
+		}
+		rects := strings.Split(svg, "<rect ")[1:]
+		if len(rects) != len(test.widths) {
+			t.Fatalf("Expected %v bars for %v but got %v", len(test.widths), test.values, len(rects))
+		}
+		for i, rect := range rects {
+			if !strings.Contains(rect, test.widths[i]) {
+				t.Errorf("Expected bar %v of %v to have %v but got %v", i, test.values, test.widths[i], rect)
+			}
+		}
+	}
+}
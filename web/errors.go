@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// httpError is an error that is shown to the user with its HTTP status.
+// Message is safe to show, the underlying Err is only logged.
+type httpError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *httpError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%v: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *httpError) Unwrap() error {
+	return e.Err
+}
+
+// badRequest returns an error for a request with invalid parameters.
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{Status: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+// notFound returns an error for something that does not exist, or that the
+// user may not see.
+func notFound(format string, args ...interface{}) error {
+	return &httpError{Status: http.StatusNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+// upstreamError returns an error for a failure reading from DynamoDB or
+// another service the page depends on.
+func upstreamError(err error) error {
+	return &httpError{Status: http.StatusBadGateway, Message: "The report store could not be read, please try again", Err: err}
+}
+
+// statusOf returns the status and message to respond to err with. Errors
+// that are not an httpError are internal errors, and their message is not
+// shown.
+func statusOf(err error) (status int, message string) {
+	var e *httpError
+	if errors.As(err, &e) {
+		return e.Status, e.Message
+	}
+	return http.StatusInternalServerError, "Internal server error"
+}
+
+// handle adapts a page handler that returns an error, rendering the error
+// page if it fails.
+func (web *web) handle(h func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h(w, r); err != nil {
+			web.errorHandler(w, r, err)
+		}
+	}
+}
+
+// handleAPI adapts an API handler that returns an error, responding with a
+// JSON error body if it fails.
+func (web *web) handleAPI(h func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h(w, r); err != nil {
+			logError(r, err)
+			status, message := statusOf(err)
+			writeAPIError(w, status, message)
+		}
+	}
+}
+
+// errorHandler renders the error page for err.
+func (web *web) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	logError(r, err)
+	status, message := statusOf(err)
+
+	web.initTemplates()
+	templateData := make(map[string]interface{})
+	templateData["status"] = status
+	templateData["title"] = http.StatusText(status)
+	templateData["message"] = message
+
+	var buf bytes.Buffer
+	if t, ok := web.template("error"); ok {
+		if err = web.executeLayout(&buf, r, t, templateData); err != nil {
+			fmt.Printf("Error rendering the error page. %v\n", err)
+		}
+	}
+	if buf.Len() == 0 {
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// logError logs errors that are not caused by the request.
+func logError(r *http.Request, err error) {
+	if status, _ := statusOf(err); status >= http.StatusInternalServerError {
+		fmt.Printf("Error handling %v. %v\n", r.URL.Path, err)
+	}
+}
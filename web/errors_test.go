@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// errorTests are errors handlers return and the status and message they are
+// responded to with.
+var errorTests = []struct {
+	err     error
+	status  int
+	message string
+}{
+	{badRequest("days must be between %v and %v", 1, 366), http.StatusBadRequest, "days must be between 1 and 366"},
+	{notFound("report %q not found", "x"), http.StatusNotFound, `report "x" not found`},
+	{upstreamError(errors.New("connection reset")), http.StatusBadGateway, "The report store could not be read, please try again"},
+	{fmt.Errorf("loading: %w", notFound("no such report")), http.StatusNotFound, "no such report"},
+	{errors.New("connection reset"), http.StatusInternalServerError, "Internal server error"},
+}
+
+func TestStatusOf(t *testing.T) {
+	for _, test := range errorTests {
+		status, message := statusOf(test.err)
+		if status != test.status || message != test.message {
+			t.Errorf("Expected %v %q for %v but got %v %q", test.status, test.message, test.err, status, message)
+		}
+	}
+}
+
+func TestHandleAPI(t *testing.T) {
+	web := &web{}
+	for _, test := range errorTests {
+		h := web.handleAPI(func(w http.ResponseWriter, r *http.Request) error { return test.err })
+		w := serve(h, httptest.NewRequest("GET", "/api/v1/summary", nil))
+		if w.Code != test.status {
+			t.Errorf("Expected %v for %v but got %v", test.status, test.err, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Expected a JSON error but got %v", ct)
+		}
+
+		// The body is the error and nothing else.
+		var body apiError
+		dec := json.NewDecoder(w.Body)
+		if err := dec.Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Error.Status != test.status || body.Error.Message != test.message {
+			t.Errorf("Expected %v %q but got %+v", test.status, test.message, body.Error)
+		}
+		if err := dec.Decode(&json.RawMessage{}); err != io.EOF {
+			t.Errorf("Expected nothing after the error for %v but got %v", test.err, err)
+		}
+	}
+
+	// A handler that succeeds is left to write its own response.
+	h := web.handleAPI(func(w http.ResponseWriter, r *http.Request) error {
+		writeJSON(w, http.StatusOK, apiPage{Items: []int{}})
+		return nil
+	})
+	w := serve(h, httptest.NewRequest("GET", "/api/v1/reports", nil))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "error") {
+		t.Errorf("Expected %v and no error but got %v: %v", http.StatusOK, w.Code, w.Body.String())
+	}
+}
+
+func TestHandle(t *testing.T) {
+	web := &web{assets: assets(false)}
+	for _, test := range errorTests {
+		h := web.handle(func(w http.ResponseWriter, r *http.Request) error { return test.err })
+		w := serve(h, httptest.NewRequest("GET", "/reporters/", nil))
+		if w.Code != test.status {
+			t.Errorf("Expected %v for %v but got %v", test.status, test.err, w.Code)
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, template.HTMLEscapeString(test.message)) {
+			t.Errorf("Expected %q in the error page but got %v", test.message, body)
+		}
+		if strings.Contains(body, "connection reset") {
+			t.Errorf("Expected the underlying error of %v not to be shown but got %v", test.err, body)
+		}
+		if strings.Count(body, "</html>") != 1 {
+			t.Errorf("Expected a single page for %v but got %v", test.err, body)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// recordExportColumns and summaryExportColumns are the CSV columns of the
+// exports. New columns are only ever added at the end, so spreadsheets built
+// on an export keep working.
+var recordExportColumns = []string{"date", "org_report_id", "domain", "org_name", "report_id", "source_ip", "count",
+	"disposition", "dkim", "spf", "header_from", "envelope_from", "reasons", "dkim_domains", "dkim_results",
+	"spf_domains", "spf_results"}
+
+var summaryExportColumns = []string{"date", "domain", "org_name", "reports", "messages", "accepted", "quarantined",
+	"rejected", "pass", "dkim_pass", "spf_pass"}
+
+// exportSummary is a summary line of the NDJSON export, totalling the reports
+// for one day, domain and reporting organization.
+type exportSummary struct {
+	Date    string `json:"date"`
+	Domain  string `json:"domain"`
+	OrgName string `json:"orgName"`
+	apiCounts
+}
+
+// exportErrorTrailer is the trailer a CSV export that fails part way
+// through ends with, holding the error.
+const exportErrorTrailer = "X-Export-Error"
+
+// exportWriter writes the rows of an export as CSV or NDJSON. Rows are kept
+// until they are flushed, and the response headers are only sent with the
+// first flush, so an error before then can still get an error response.
+type exportWriter struct {
+	w       http.ResponseWriter
+	header  http.Header
+	buf     bytes.Buffer
+	csv     *csv.Writer
+	enc     *json.Encoder
+	flushed bool
+}
+
+// newExportWriter returns an exportWriter sending header with the first
+// flush.
+func newExportWriter(w http.ResponseWriter, format string, header http.Header) *exportWriter {
+	e := &exportWriter{w: w, header: header}
+	if format == "ndjson" {
+		e.enc = json.NewEncoder(&e.buf)
+	} else {
+		e.csv = csv.NewWriter(&e.buf)
+	}
+	return e
+}
+
+// write writes v as an NDJSON line, or fields as a CSV line.
+func (e *exportWriter) write(v interface{}, fields []string) error {
+	if e.enc != nil {
+		return e.enc.Encode(v)
+	}
+	return e.csv.Write(fields)
+}
+
+// writeHeader writes the CSV header line. NDJSON has none.
+func (e *exportWriter) writeHeader(columns []string) error {
+	if e.csv == nil {
+		return nil
+	}
+	return e.csv.Write(columns)
+}
+
+// flush sends what has been written so far to the client.
+func (e *exportWriter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if !e.flushed {
+		for name, values := range e.header {
+			e.w.Header()[name] = values
+		}
+		e.flushed = true
+	}
+	if _, err := e.buf.WriteTo(e.w); err != nil {
+		return err
+	}
+	if f, ok := e.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// fail ends an export that has already been partly sent with message, as a
+// trailer for CSV and as a last line in the form of an API error for
+// NDJSON, so the client can tell it is incomplete. Rows not yet flushed are
+// sent first.
+func (e *exportWriter) fail(message string) {
+	if e.enc != nil {
+		e.enc.Encode(apiError{Error: apiErrorDetail{Status: http.StatusBadGateway, Message: message}})
+	} else {
+		e.w.Header().Set(exportErrorTrailer, message)
+	}
+	e.flush()
+}
+
+func (web *web) export(w http.ResponseWriter, r *http.Request) error {
+	kind := chi.URLParam(r, "kind")
+	if kind != "records" && kind != "summary" {
+		return notFound("no export named %q", kind)
+	}
+
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "ndjson" {
+		return badRequest("format must be csv or ndjson")
+	}
+
+	filter, err := parseFilter(r)
+	if err != nil {
+		return err
+	}
+
+	var ip net.IP
+	if s := q.Get("source"); s != "" && kind == "records" {
+		if ip = net.ParseIP(s); ip == nil {
+			return badRequest("invalid source IP %q", s)
+		}
+	}
+
+	header := http.Header{}
+	if format == "csv" {
+		header.Set("Content-Type", "text/csv; charset=utf-8")
+		header.Set("Trailer", exportErrorTrailer)
+	} else {
+		header.Set("Content-Type", "application/x-ndjson")
+	}
+	header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"dmarc-%v-%v-%v.%v\"", kind, filter.From, filter.To, format))
+
+	out := newExportWriter(w, format, header)
+	if q.Get("header") != "false" {
+		columns := recordExportColumns
+		if kind == "summary" {
+			columns = summaryExportColumns
+		}
+		if err = out.writeHeader(columns); err != nil {
+			return err
+		}
+	}
+
+	if kind == "records" {
+		err = web.exportRecords(r, out, filter, ip)
+	} else {
+		err = web.exportSummary(r, out, filter)
+	}
+	if err == nil {
+		err = out.flush()
+	}
+	if err != nil && !out.flushed {
+		return upstreamError(err)
+	}
+	if err != nil {
+		// The status has already been sent, so the error can only follow
+		// the rows sent so far.
+		fmt.Printf("Error exporting %v. %v\n", kind, err)
+		out.fail("The report store could not be read, the export is incomplete")
+	}
+	return nil
+}
+
+// exportRecords writes every record matching filter as it is read, flushing
+// every 500 records. Run locally the export streams, but under Lambda
+// the gateway adapter buffers the whole response, which API Gateway limits
+// to 6 MB, so large ranges are exported with dmarc export -chunk.
+func (web *web) exportRecords(r *http.Request, out *exportWriter, filter reportFilter, ip net.IP) error {
+	pending := 0
+	return web.eachRecord(r.Context(), filter, ip, func(s sourceRecord) error {
+		rec := newAPIRecord(s.Record)
+		rec.Report = &apiReportRef{OrgReportID: s.Entry.OrgReportID, Date: s.Entry.GMTDate, Domain: s.Entry.Domain, OrgName: s.Entry.OrgName}
+
+		var reasons, dkimDomains, dkimResults, spfDomains, spfResults []string
+		for _, reason := range rec.Reasons {
+			reasons = append(reasons, reason.Type)
+		}
+		for _, a := range rec.DKIMResults {
+			dkimDomains = append(dkimDomains, a.Domain)
+			dkimResults = append(dkimResults, a.Result)
+		}
+		for _, a := range rec.SPFResults {
+			spfDomains = append(spfDomains, a.Domain)
+			spfResults = append(spfResults, a.Result)
+		}
+
+		err := out.write(rec, []string{s.Entry.GMTDate, s.Entry.OrgReportID, s.Entry.Domain, s.Entry.OrgName,
+			s.Entry.ReportID, rec.SourceIP, strconv.Itoa(rec.Count), rec.Disposition, rec.DKIM, rec.SPF,
+			rec.HeaderFrom, rec.EnvelopeFrom, strings.Join(reasons, " "), strings.Join(dkimDomains, " "),
+			strings.Join(dkimResults, " "), strings.Join(spfDomains, " "), strings.Join(spfResults, " ")})
+		if err != nil {
+			return err
+		}
+
+		if pending++; pending >= 500 {
+			pending = 0
+			return out.flush()
+		}
+		return nil
+	})
+}
+
+// exportSummary writes the totals for each day, domain and reporting
+// organization matching filter, one day at a time.
+func (web *web) exportSummary(r *http.Request, out *exportWriter, filter reportFilter) error {
+	return web.eachDay(r.Context(), filter, func(date string, rows []aggRow) error {
+		sort.Slice(rows, func(i, j int) bool { return rows[i].AggregateKey < rows[j].AggregateKey })
+
+		for _, row := range rows {
+			if !filter.Matches(row.Domain, row.OrgName) {
+				continue
+			}
+
+			s := exportSummary{Date: date, Domain: row.Domain, OrgName: row.OrgName}
+			d := apiDay{}
+			d.add(row)
+			s.apiCounts = d.apiCounts
+
+			err := out.write(s, []string{s.Date, s.Domain, s.OrgName, strconv.Itoa(s.Reports), strconv.Itoa(s.Messages),
+				strconv.Itoa(s.Accepted), strconv.Itoa(s.Quarantined), strconv.Itoa(s.Rejected), strconv.Itoa(s.Pass),
+				strconv.Itoa(s.DKIMPass), strconv.Itoa(s.SPFPass)})
+			if err != nil {
+				return err
+			}
+		}
+
+		return out.flush()
+	})
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ericdaugherty/dmarc/report"
+	"github.com/go-chi/chi/v5"
+)
+
+// Feeds are Atom documents for a domain, at /feeds/{domain}/{kind}.atom.
+// Entry IDs are built from the report and record they describe, so an entry
+// keeps its ID however often the feed is read.
+
+// feedEntries is the most entries in a feed, newest first.
+const feedEntries = 100
+
+// maxFeedDays is the longest range a feed covers, since it reads every
+// report in the range.
+const maxFeedDays = 30
+
+// senderLookback is the number of days before the range of the senders feed
+// that a source must not have sent in for it to be new.
+const senderLookback = 30
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+
+	updated time.Time
+}
+
+// feedKinds are the feeds available for each domain, with their titles.
+var feedKinds = map[string]string{
+	"failures": "DMARC failures",
+	"senders":  "New senders",
+	"policy":   "DMARC policy changes",
+}
+
+func (web *web) feed(w http.ResponseWriter, r *http.Request) error {
+	domain := chi.URLParam(r, "domain")
+	kind := strings.TrimSuffix(chi.URLParam(r, "kind"), ".atom")
+	title, ok := feedKinds[kind]
+	if !ok || !strings.HasSuffix(chi.URLParam(r, "kind"), ".atom") {
+		return notFound("no feed named %v", chi.URLParam(r, "kind"))
+	}
+
+	filter, err := parseFilter(r)
+	if err == nil && filter.Days > maxFeedDays {
+		err = badRequest("feeds cover at most %v days", maxFeedDays)
+	}
+	if err != nil {
+		return err
+	}
+	if !filter.Allows(domain) {
+		return notFound("no reports for %v", domain)
+	}
+	filter.Domain = domain
+
+	root := rootPath(r)
+	self := kind + ".atom"
+	if r.URL.RawQuery != "" {
+		self += "?" + r.URL.RawQuery
+	}
+	var entries []atomEntry
+	switch kind {
+	case "failures":
+		entries, err = web.failureEntries(r, filter, root)
+	case "senders":
+		entries, err = web.senderEntries(r, filter, root)
+	case "policy":
+		entries, err = web.policyEntries(r, filter, root)
+	}
+	if err != nil {
+		return upstreamError(err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].updated.Equal(entries[j].updated) {
+			return entries[i].updated.After(entries[j].updated)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	if len(entries) > feedEntries {
+		entries = entries[:feedEntries]
+	}
+
+	feed := atomFeed{
+		ID:    feedID("feed", kind, domain),
+		Title: fmt.Sprintf("%v for %v", title, domain),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: self},
+			{Rel: "alternate", Type: "text/html", Href: root + "?" + string(filter.Query())},
+		},
+		Author:  atomAuthor{Name: "DMARC"},
+		Entries: entries,
+	}
+	// The feed was last updated with its newest entry, so a reader sees no
+	// change until there is a new one.
+	updated, _ := time.Parse(dateFormat, filter.From)
+	if len(entries) > 0 {
+		updated = entries[0].updated
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	fmt.Fprint(w, xml.Header)
+	return xml.NewEncoder(w).Encode(feed)
+}
+
+// failureEntries returns an entry for each record in the range whose
+// messages failed both DKIM and SPF.
+func (web *web) failureEntries(r *http.Request, filter reportFilter, root string) (entries []atomEntry, err error) {
+	err = web.eachReport(r.Context(), filter, func(e dbEntry, f report.Feedback) error {
+		if e.Quarantined {
+			return nil
+		}
+		for i, rec := range f.Record {
+			pe := rec.Row.PolicyEvaluated
+			if pe.Dkim == "pass" || pe.Spf == "pass" {
+				continue
+			}
+			entries = append(entries, newEntry(
+				feedID("failure", e.OrgReportID, strconv.Itoa(i)),
+				fmt.Sprintf("%v messages from %v failed DMARC", rec.Row.Count, rec.Row.SourceIP),
+				fmt.Sprintf("%v reported %v messages from %v with header from %v failing DKIM and SPF. The disposition was %v.",
+					e.OrgName, rec.Row.Count, rec.Row.SourceIP, rec.Identifiers.HeaderFrom, pe.Disposition),
+				root+"report/"+url.PathEscape(e.OrgReportID)+"/",
+				e.EndTime))
+		}
+		return nil
+	})
+	return
+}
+
+// senderEntries returns an entry for each source that sent messages in the
+// range but not in the senderLookback days before it.
+func (web *web) senderEntries(r *http.Request, filter reportFilter, root string) (entries []atomEntry, err error) {
+	from, _ := time.Parse(dateFormat, filter.From)
+	before := filter
+	before.From = from.AddDate(0, 0, -senderLookback).Format(dateFormat)
+	before.To = from.AddDate(0, 0, -1).Format(dateFormat)
+	before.Days = senderLookback
+
+	known := map[string]bool{}
+	err = web.eachRecord(r.Context(), before, nil, func(rec sourceRecord) error {
+		known[rec.Record.Row.SourceIP] = true
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// The first report of each new source becomes its entry.
+	first := map[string]atomEntry{}
+	err = web.eachReport(r.Context(), filter, func(e dbEntry, f report.Feedback) error {
+		for _, rec := range f.Record {
+			ip := rec.Row.SourceIP
+			if prev, ok := first[ip]; known[ip] || (ok && prev.updated.Unix() <= int64(e.BeginTime)) {
+				continue
+			}
+			first[ip] = newEntry(
+				feedID("sender", e.Domain, ip),
+				fmt.Sprintf("New sender %v", ip),
+				fmt.Sprintf("%v first reported %v messages from %v with header from %v, DKIM %v and SPF %v.",
+					e.OrgName, rec.Row.Count, ip, rec.Identifiers.HeaderFrom, rec.Row.PolicyEvaluated.Dkim, rec.Row.PolicyEvaluated.Spf),
+				root+"source/"+ip+"/?"+string(filter.RangeQuery()),
+				e.BeginTime)
+		}
+		return nil
+	})
+	for _, entry := range first {
+		entries = append(entries, entry)
+	}
+	return
+}
+
+// policyEntries returns an entry for each report that found a different
+// published policy than the report before it.
+func (web *web) policyEntries(r *http.Request, filter reportFilter, root string) (entries []atomEntry, err error) {
+	type published struct {
+		entry  dbEntry
+		policy report.PolicyPublished
+	}
+	var reports []published
+	err = web.eachReport(r.Context(), filter, func(e dbEntry, f report.Feedback) error {
+		reports = append(reports, published{e, f.PolicyPublished.Effective()})
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Each report is compared with the one before it from the same
+	// reporter, since reporters differ in when they fetch the policy.
+	sort.SliceStable(reports, func(i, j int) bool { return reports[i].entry.BeginTime < reports[j].entry.BeginTime })
+	last := map[string]published{}
+	for _, cur := range reports {
+		prev, ok := last[cur.entry.OrgName]
+		last[cur.entry.OrgName] = cur
+		if !ok || cur.policy == prev.policy {
+			continue
+		}
+		entries = append(entries, newEntry(
+			feedID("policy", cur.entry.OrgReportID),
+			fmt.Sprintf("Policy for %v changed", cur.entry.Domain),
+			fmt.Sprintf("%v found %v, where its report of %v had found %v.", cur.entry.OrgName, cur.policy.Tags(),
+				prev.entry.GMTDate, prev.policy.Tags()),
+			root+"report/"+url.PathEscape(cur.entry.OrgReportID)+"/",
+			cur.entry.BeginTime))
+	}
+	return
+}
+
+func newEntry(id, title, summary, link string, updated int) atomEntry {
+	t := time.Unix(int64(updated), 0).UTC()
+	return atomEntry{
+		ID:      id,
+		Title:   title,
+		Updated: t.Format(time.RFC3339),
+		Link:    atomLink{Rel: "alternate", Type: "text/html", Href: link},
+		Summary: summary,
+		updated: t,
+	}
+}
+
+// feedID returns a URN built from parts, each escaped so the ID of one entry
+// can not collide with another's.
+func feedID(parts ...string) string {
+	escaped := []string{"urn", "dmarc"}
+	for _, p := range parts {
+		escaped = append(escaped, url.QueryEscape(p))
+	}
+	return strings.Join(escaped, ":")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// policyReport returns a stored report from org for example.com beginning on
+// date that found policy.
+func policyReport(org, date, policy string) dbEntry {
+	begin, _ := time.Parse(dateFormat, date)
+	return dbEntry{
+		GMTDate:     date,
+		OrgReportID: org + ":" + date,
+		Domain:      "example.com",
+		OrgName:     org,
+		BeginTime:   int(begin.Unix()),
+		EndTime:     int(begin.Add(24*time.Hour - time.Second).Unix()),
+		XML:         fmt.Sprintf("<feedback><policy_published><domain>example.com</domain>%v</policy_published></feedback>", policy),
+	}
+}
+
+func TestPolicyFeed(t *testing.T) {
+	useDB(t, fakeDynamoDB{reports: []dbEntry{
+		policyReport("google.com", "2024-03-01", "<p>reject</p><pct>100</pct>"),
+		policyReport("yahoo.com", "2024-03-01", "<p>reject</p><sp>reject</sp><adkim>r</adkim><fo>0</fo>"),
+		policyReport("google.com", "2024-03-02", "<p>reject</p>"),
+		policyReport("yahoo.com", "2024-03-02", "<p>reject</p>"),
+		policyReport("google.com", "2024-03-03", "<p>quarantine</p><pct>100</pct>"),
+	}})
+	h := (&router{location: time.UTC}).handler()
+
+	w := serve(h, httptest.NewRequest("GET", "/feeds/example.com/policy.atom?from=2024-03-01&to=2024-03-05", nil))
+	body := w.Body.String()
+	if n := strings.Count(body, "<entry>"); n != 1 {
+		t.Fatalf("Expected %v entry but got %v in %v", 1, n, body)
+	}
+	expected := "google.com found p=quarantine; sp=quarantine; pct=100; adkim=r; aspf=r; fo=0, where its report of 2024-03-02 had found p=reject; sp=reject"
+	if !strings.Contains(body, expected) {
+		t.Errorf("Expected %q in %v", expected, body)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dateFormat = "2006-01-02"
+
+// maxDays is the longest range of dates that can be shown at once.
+const maxDays = 366
+
+// maxProratedDays is the longest range counted by the days of a time zone
+// other than UTC. Longer ranges are shown in UTC.
+const maxProratedDays = 31
+
+// presetDays are the ranges offered as links on the home page.
+var presetDays = []int{7, 30, 90, 365}
+
+// reportFilter selects the reports shown on a page: those between From and
+// To inclusive, optionally for a single domain and reporting organization.
+// Reports for domains outside Allowed are never shown, unless it is empty.
+// The dates are days in Location, and TZ is the tz query parameter that
+// chose it, if any.
+type reportFilter struct {
+	From     string
+	To       string
+	Days     int
+	Domain   string
+	Org      string
+	Allowed  []string
+	Location *time.Location
+	TZ       string
+}
+
+// newFilter returns a filter for the domain, org and tz query parameters and
+// the domains the user may see, without a date range. A domain the user may
+// not see is not found, as its reports are.
+func newFilter(r *http.Request) (f reportFilter, err error) {
+	q := r.URL.Query()
+	f = reportFilter{Domain: q.Get("domain"), Org: q.Get("org"), Allowed: allowedDomains(r), TZ: q.Get("tz")}
+	if f.Domain != "" && !f.Allows(f.Domain) {
+		return f, notFound("no reports for %v", f.Domain)
+	}
+	f.Location, err = requestLocation(r)
+	return
+}
+
+// parseFilter reads a filter from the from, to, days, domain, org and tz
+// query parameters. Without from and to it covers the last days days, 7 by
+// default, up to yesterday.
+func parseFilter(r *http.Request) (f reportFilter, err error) {
+	q := r.URL.Query()
+	if f, err = newFilter(r); err != nil {
+		return
+	}
+
+	now := time.Now().In(f.Location)
+	to := time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, time.UTC)
+	if s := q.Get("to"); s != "" {
+		if to, err = time.Parse(dateFormat, s); err != nil {
+			return f, badRequest("invalid to date %q", s)
+		}
+	}
+
+	f.Days = 7
+	if s := q.Get("days"); s != "" {
+		if f.Days, err = strconv.Atoi(s); err != nil || f.Days < 1 || f.Days > maxDays {
+			return f, badRequest("days must be between 1 and %v", maxDays)
+		}
+	}
+	from := to.AddDate(0, 0, 1-f.Days)
+
+	if s := q.Get("from"); s != "" {
+		if from, err = time.Parse(dateFormat, s); err != nil {
+			return f, badRequest("invalid from date %q", s)
+		}
+		f.Days = int(to.Sub(from).Hours()/24) + 1
+		if f.Days < 1 || f.Days > maxDays {
+			return f, badRequest("from must be before to and at most %v days earlier", maxDays)
+		}
+	}
+
+	f.From = from.Format(dateFormat)
+	f.To = to.Format(dateFormat)
+
+	// Prorating reads every report in the range, so longer ranges are
+	// counted by GMT date from the aggregates instead.
+	if f.Days > maxProratedDays && prorated(f.Location) {
+		f.Location = time.UTC
+	}
+	return
+}
+
+// Dates returns every date in the filter, oldest first.
+func (f reportFilter) Dates() (dates []string) {
+	d, _ := time.Parse(dateFormat, f.From)
+	for i := 0; i < f.Days; i++ {
+		dates = append(dates, d.AddDate(0, 0, i).Format(dateFormat))
+	}
+	return
+}
+
+// Matches returns true if a report for domain from org passes the filter.
+func (f reportFilter) Matches(domain, org string) bool {
+	return f.Allows(domain) && (f.Domain == "" || f.Domain == domain) && (f.Org == "" || f.Org == org)
+}
+
+// Allows returns true if reports for domain may be shown at all.
+func (f reportFilter) Allows(domain string) bool {
+	if len(f.Allowed) == 0 {
+		return true
+	}
+	for _, d := range f.Allowed {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Query returns the domain, org and tz parameters of the filter, for links
+// that keep the selection.
+func (f reportFilter) Query() template.URL {
+	return template.URL(f.values().Encode())
+}
+
+// PresetQuery returns the query for the last days days with the same domain,
+// org and time zone.
+func (f reportFilter) PresetQuery(days int) template.URL {
+	q := f.values()
+	q.Set("days", strconv.Itoa(days))
+	return template.URL(q.Encode())
+}
+
+// RangeQuery returns the query for the same dates, domain, org and time zone.
+func (f reportFilter) RangeQuery() template.URL {
+	return template.URL(f.rangeValues().Encode())
+}
+
+// OrgQuery returns the query for the same dates and domain from org.
+func (f reportFilter) OrgQuery(org string) template.URL {
+	q := f.rangeValues()
+	q.Set("org", org)
+	return template.URL(q.Encode())
+}
+
+func (f reportFilter) rangeValues() url.Values {
+	q := f.values()
+	q.Set("from", f.From)
+	q.Set("to", f.To)
+	return q
+}
+
+func (f reportFilter) values() url.Values {
+	q := url.Values{}
+	if f.Domain != "" {
+		q.Set("domain", f.Domain)
+	}
+	if f.Org != "" {
+		q.Set("org", f.Org)
+	}
+	if f.TZ != "" {
+		q.Set("tz", f.TZ)
+	}
+	return q
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	yesterday := time.Now().UTC().AddDate(0, 0, -1)
+	tests := []struct {
+		query    string
+		from, to string
+		days     int
+		status   int
+	}{
+		{"", yesterday.AddDate(0, 0, -6).Format(dateFormat), yesterday.Format(dateFormat), 7, 0},
+		{"days=30", yesterday.AddDate(0, 0, -29).Format(dateFormat), yesterday.Format(dateFormat), 30, 0},
+		{"to=2024-03-10", "2024-03-04", "2024-03-10", 7, 0},
+		{"to=2024-03-10&days=1", "2024-03-10", "2024-03-10", 1, 0},
+		{"from=2024-02-28&to=2024-03-01", "2024-02-28", "2024-03-01", 3, 0},
+		{"from=2023-03-01&to=2024-02-29", "2023-03-01", "2024-02-29", 366, 0},
+		{"from=2024-03-01&to=2024-03-01&days=30", "2024-03-01", "2024-03-01", 1, 0},
+		{"from=2023-02-28&to=2024-02-29", "", "", 0, 400},
+		{"from=2024-03-02&to=2024-03-01", "", "", 0, 400},
+		{"from=2024-3-1", "", "", 0, 400},
+		{"to=tomorrow", "", "", 0, 400},
+		{"days=0", "", "", 0, 400},
+		{"days=367", "", "", 0, 400},
+		{"days=week", "", "", 0, 400},
+		{"tz=Mars/Olympus", "", "", 0, 400},
+	}
+	for _, test := range tests {
+		f, err := parseFilter(httptest.NewRequest("GET", "/?"+test.query, nil))
+		if status, _ := statusOf(err); err != nil && status != test.status || err == nil && test.status != 0 {
+			t.Errorf("Expected %v for %q but got %v", test.status, test.query, err)
+			continue
+		}
+		if err == nil && (f.From != test.from || f.To != test.to || f.Days != test.days) {
+			t.Errorf("Expected %v to %v, %v days, for %q but got %v to %v, %v days", test.from, test.to, test.days, test.query, f.From, f.To, f.Days)
+		}
+	}
+}
+
+func TestFilterDomains(t *testing.T) {
+	scoped := &user{Name: "alice", Domains: []string{"Example.com"}}
+	tests := []struct {
+		query  string
+		user   *user
+		status int
+		match  map[[2]string]bool
+	}{
+		{"", nil, 0, map[[2]string]bool{
+			{"example.com", "google.com"}: true,
+			{"example.org", "yahoo.com"}:  true,
+		}},
+		{"domain=example.com", nil, 0, map[[2]string]bool{
+			{"example.com", "google.com"}: true,
+			{"example.org", "google.com"}: false,
+		}},
+		{"org=google.com", nil, 0, map[[2]string]bool{
+			{"example.com", "google.com"}: true,
+			{"example.com", "yahoo.com"}:  false,
+		}},
+		{"domain=example.com&org=google.com", nil, 0, map[[2]string]bool{
+			{"example.com", "google.com"}: true,
+			{"example.com", "yahoo.com"}:  false,
+			{"example.org", "google.com"}: false,
+		}},
+		{"", scoped, 0, map[[2]string]bool{
+			{"example.com", "google.com"}: true,
+			{"example.org", "google.com"}: false,
+		}},
+		{"domain=example.com", scoped, 0, map[[2]string]bool{
+			{"example.com", "google.com"}: true,
+		}},
+		{"domain=example.org", scoped, 404, nil},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/?"+test.query, nil)
+		if test.user != nil {
+			r = r.WithContext(context.WithValue(r.Context(), userKey{}, test.user))
+		}
+		f, err := parseFilter(r)
+		if status, _ := statusOf(err); err != nil && status != test.status || err == nil && test.status != 0 {
+			t.Errorf("Expected %v for %q but got %v", test.status, test.query, err)
+			continue
+		}
+		for report, expected := range test.match {
+			if got := f.Matches(report[0], report[1]); got != expected {
+				t.Errorf("Expected a report for %v from %v to match %q %v but got %v", report[0], report[1], test.query, expected, got)
+			}
+		}
+	}
+}
@@ -8,11 +8,17 @@ require (
 	github.com/aws/aws-sdk-go-v2/config v1.15.9
 	github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue v1.9.2
 	github.com/aws/aws-sdk-go-v2/service/dynamodb v1.15.5
+	github.com/coreos/go-oidc/v3 v3.5.0
+	github.com/ericdaugherty/dmarc/dnscheck v0.0.0-00010101000000-000000000000
+	github.com/ericdaugherty/dmarc/report v0.0.0-00010101000000-000000000000
 	github.com/go-chi/chi/v5 v5.0.7
-	github.com/markbates/pkger v0.17.1
+	github.com/prometheus/client_golang v1.14.0
+	golang.org/x/crypto v0.6.0
+	golang.org/x/oauth2 v0.5.0
 )
 
 require (
+	github.com/DusanKasan/parsemail v1.2.0 // indirect
 	github.com/aws/aws-lambda-go v1.17.0 // indirect
 	github.com/aws/aws-sdk-go-v2 v1.16.4 // indirect
 	github.com/aws/aws-sdk-go-v2/credentials v1.12.4 // indirect
@@ -27,8 +33,26 @@ require (
 	github.com/aws/aws-sdk-go-v2/service/sso v1.11.7 // indirect
 	github.com/aws/aws-sdk-go-v2/service/sts v1.16.6 // indirect
 	github.com/aws/smithy-go v1.11.2 // indirect
-	github.com/gobuffalo/here v0.6.0 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/ericdaugherty/dmarc/dmarcrecord v0.0.0-00010101000000-000000000000 // indirect
+	github.com/go-jose/go-jose/v3 v3.0.0 // indirect
+	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
+	github.com/prometheus/client_model v0.3.0 // indirect
+	github.com/prometheus/common v0.37.0 // indirect
+	github.com/prometheus/procfs v0.8.0 // indirect
+	golang.org/x/net v0.7.0 // indirect
+	golang.org/x/sys v0.5.0 // indirect
+	google.golang.org/appengine v1.6.7 // indirect
+	google.golang.org/protobuf v1.28.1 // indirect
 	gopkg.in/yaml.v3 v3.0.0 // indirect
 )
+
+replace (
+	github.com/ericdaugherty/dmarc/dmarcrecord => ../dmarcrecord
+	github.com/ericdaugherty/dmarc/dnscheck => ../dnscheck
+	github.com/ericdaugherty/dmarc/report => ../report
+)
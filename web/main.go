@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	_ "time/tzdata"
 
 	"github.com/apex/gateway"
 )
@@ -12,7 +13,20 @@ func main() {
 
 	aws := len(os.Getenv("AWS_REGION")) > 0
 
-	r := router{devMode: !aws}
+	auth, err := loadAuth(aws)
+	if err != nil {
+		log.Fatal("Error loading authentication configuration. ", err)
+	}
+	if auth == nil {
+		log.Println("AUTH is none, every page is public")
+	}
+
+	location, err := loadLocation()
+	if err != nil {
+		log.Fatal("Error loading time zone. ", err)
+	}
+
+	r := router{devMode: !aws, auth: auth, location: location}
 	http.Handle("/", r.handler())
 
 	if aws {
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics serves the totals for the last day in the Prometheus text format.
+// The last day is yesterday in the request's time zone, or the to date, and
+// the domain and org parameters narrow the totals as they do elsewhere. The
+// gauges are read from the aggregates on every scrape.
+func (web *web) metrics(w http.ResponseWriter, r *http.Request) error {
+	filter, err := parseFilter(r)
+	if err != nil {
+		return err
+	}
+	filter.From, filter.Days = filter.To, 1
+
+	messages := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dmarc_messages",
+		Help: "Messages reported for the last day, by domain and disposition.",
+	}, []string{"domain", "disposition"})
+	results := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dmarc_message_results",
+		Help: "Messages reported for the last day, by domain and whether they passed DMARC, DKIM and SPF.",
+	}, []string{"domain", "check", "result"})
+	reports := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dmarc_reports",
+		Help: "Reports received for the last day, by domain.",
+	}, []string{"domain"})
+
+	err = web.eachDay(r.Context(), filter, func(date string, rows []aggRow) error {
+		for _, row := range rows {
+			if !filter.Matches(row.Domain, row.OrgName) {
+				continue
+			}
+			messages.WithLabelValues(row.Domain, "none").Add(float64(row.CountAccepted))
+			messages.WithLabelValues(row.Domain, "quarantine").Add(float64(row.CountQuarantined))
+			messages.WithLabelValues(row.Domain, "reject").Add(float64(row.CountRejected))
+			for _, c := range []struct {
+				check string
+				pass  int
+			}{{"dmarc", row.CountPass}, {"dkim", row.CountDKIMPass}, {"spf", row.CountSPFPass}} {
+				results.WithLabelValues(row.Domain, c.check, "pass").Add(float64(c.pass))
+				results.WithLabelValues(row.Domain, c.check, "fail").Add(float64(row.Total() - c.pass))
+			}
+			reports.WithLabelValues(row.Domain).Add(float64(row.Reports))
+		}
+		return nil
+	})
+	if err != nil {
+		return upstreamError(err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(messages, results, reports)
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const loginCookie = "dmarc_login"
+
+// oidcLogin logs users in with an OpenID Connect provider using the
+// authorization code flow.
+type oidcLogin struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	// base is the URL the web module is served from, found by removing
+	// /auth/callback from the redirect URL.
+	base string
+
+	mu       sync.Mutex
+	config   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// loginState is the content of the cookie that carries the state of a login
+// to the callback.
+type loginState struct {
+	State   string `json:"state"`
+	Nonce   string `json:"nonce"`
+	Next    string `json:"next"`
+	Expires int64  `json:"expires"`
+}
+
+func newOIDCLogin(issuer, clientID, clientSecret, redirectURL string) (*oidcLogin, error) {
+	if issuer == "" || clientID == "" || redirectURL == "" {
+		return nil, fmt.Errorf("OIDC_ISSUER, OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required")
+	}
+	if !strings.HasSuffix(redirectURL, "/auth/callback") {
+		return nil, fmt.Errorf("OIDC_REDIRECT_URL must end with /auth/callback")
+	}
+	return &oidcLogin{
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		base:         strings.TrimSuffix(redirectURL, "/auth/callback"),
+	}, nil
+}
+
+// provider fetches the provider configuration the first time it is needed,
+// so a cold start does not wait for it.
+func (o *oidcLogin) provider(r *http.Request) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.config == nil {
+		p, err := oidc.NewProvider(r.Context(), o.issuer)
+		if err != nil {
+			return nil, nil, err
+		}
+		o.config = &oauth2.Config{
+			ClientID:     o.clientID,
+			ClientSecret: o.clientSecret,
+			RedirectURL:  o.redirectURL,
+			Endpoint:     p.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "email"},
+		}
+		o.verifier = p.Verifier(&oidc.Config{ClientID: o.clientID})
+	}
+	return o.config, o.verifier, nil
+}
+
+// loginURL returns the URL that logs in and then returns to next, a path
+// relative to the web module.
+func (o *oidcLogin) loginURL(next string) string {
+	return o.base + "/auth/login?" + url.Values{"next": {next}}.Encode()
+}
+
+func (a *auth) login(w http.ResponseWriter, r *http.Request) error {
+	config, _, err := a.oidc.provider(r)
+	if err != nil {
+		return &httpError{Status: http.StatusBadGateway, Message: "Login is unavailable", Err: err}
+	}
+
+	next := r.URL.Query().Get("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = "/"
+	}
+	state, err := randomString()
+	if err != nil {
+		return upstreamError(err)
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return upstreamError(err)
+	}
+	s := loginState{State: state, Nonce: nonce, Next: next, Expires: time.Now().Add(10 * time.Minute).Unix()}
+	value, err := a.sign(s)
+	if err != nil {
+		return err
+	}
+	a.setCookie(w, loginCookie, value, 10*time.Minute)
+
+	http.Redirect(w, r, config.AuthCodeURL(s.State, oidc.Nonce(s.Nonce)), http.StatusFound)
+	return nil
+}
+
+func (a *auth) callback(w http.ResponseWriter, r *http.Request) error {
+	config, verifier, err := a.oidc.provider(r)
+	if err != nil {
+		return &httpError{Status: http.StatusBadGateway, Message: "Login is unavailable", Err: err}
+	}
+
+	var s loginState
+	c, err := r.Cookie(loginCookie)
+	if err != nil || !a.verify(c.Value, &s) || time.Now().Unix() > s.Expires || r.URL.Query().Get("state") != s.State {
+		return badRequest("Login expired, please try again")
+	}
+	a.clearCookie(w, loginCookie)
+
+	token, err := config.Exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		fmt.Printf("Error exchanging OIDC code. %v\n", err)
+		return &httpError{Status: http.StatusUnauthorized, Message: "Login failed"}
+	}
+	raw, _ := token.Extra("id_token").(string)
+	idToken, err := verifier.Verify(r.Context(), raw)
+	if err != nil || idToken.Nonce != s.Nonce {
+		fmt.Printf("Error verifying OIDC ID token. %v\n", err)
+		return &httpError{Status: http.StatusUnauthorized, Message: "Login failed"}
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified *bool  `json:"email_verified"`
+	}
+	if err = idToken.Claims(&claims); err != nil || (claims.EmailVerified != nil && !*claims.EmailVerified) {
+		return &httpError{Status: http.StatusUnauthorized, Message: "Login failed, the provider did not return a verified email address"}
+	}
+	if a.userByEmail(claims.Email) == nil {
+		return &httpError{Status: http.StatusForbidden, Message: fmt.Sprintf("%v is not allowed to use this site", claims.Email)}
+	}
+
+	value, err := a.sign(session{Email: claims.Email, Expires: time.Now().Add(sessionLifetime).Unix()})
+	if err != nil {
+		return err
+	}
+	a.setCookie(w, sessionCookie, value, sessionLifetime)
+
+	http.Redirect(w, r, a.oidc.base+s.Next, http.StatusFound)
+	return nil
+}
+
+func (a *auth) logout(w http.ResponseWriter, r *http.Request) {
+	a.clearCookie(w, sessionCookie)
+	http.Redirect(w, r, a.oidc.base+"/", http.StatusFound)
+}
+
+// randomString returns a random string for the state and nonce of a login.
+func randomString() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/ericdaugherty/dmarc/report"
+	"github.com/go-chi/chi/v5"
+)
+
+// recordColumn is a sortable column of the records table on the report page.
+type recordColumn struct {
+	Key   string
+	Title string
+	less  func(a, b report.Record) bool
+}
+
+var recordColumns = []recordColumn{
+	{"ip", "Source IP", func(a, b report.Record) bool { return lessIP(a.Row.SourceIP, b.Row.SourceIP) }},
+	{"count", "Count", func(a, b report.Record) bool { return atoi(a.Row.Count) < atoi(b.Row.Count) }},
+	{"disposition", "Disposition", func(a, b report.Record) bool {
+		return a.Row.PolicyEvaluated.Disposition < b.Row.PolicyEvaluated.Disposition
+	}},
+	{"dkim", "DKIM", func(a, b report.Record) bool { return a.Row.PolicyEvaluated.Dkim < b.Row.PolicyEvaluated.Dkim }},
+	{"spf", "SPF", func(a, b report.Record) bool { return a.Row.PolicyEvaluated.Spf < b.Row.PolicyEvaluated.Spf }},
+	{"header_from", "Header From", func(a, b report.Record) bool {
+		return a.Identifiers.HeaderFrom < b.Identifiers.HeaderFrom
+	}},
+	{"envelope_from", "Envelope From", func(a, b report.Record) bool {
+		return a.Identifiers.EnvelopeFrom < b.Identifiers.EnvelopeFrom
+	}},
+}
+
+// columnHeader is a records table heading linking to the table sorted by
+// that column.
+type columnHeader struct {
+	Title  string
+	Query  template.URL
+	Sorted string
+}
+
+func (web *web) reportDetail(w http.ResponseWriter, r *http.Request) error {
+	web.initTemplates()
+
+	entry, err := web.loadReport(r)
+	if err != nil {
+		return err
+	}
+
+	f := entry.Feedback()
+	sortKey, desc := r.URL.Query().Get("sort"), r.URL.Query().Get("order") == "desc"
+	if sortKey == "" {
+		sortKey, desc = "count", true
+	}
+
+	var headers []columnHeader
+	for _, c := range recordColumns {
+		h := columnHeader{Title: c.Title, Query: template.URL("sort=" + c.Key)}
+		if c.Key == sortKey {
+			less := c.less
+			sort.SliceStable(f.Record, func(i, j int) bool {
+				if desc {
+					return less(f.Record[j], f.Record[i])
+				}
+				return less(f.Record[i], f.Record[j])
+			})
+			h.Sorted = "asc"
+			if desc {
+				h.Sorted = "desc"
+			} else {
+				h.Query += "&order=desc"
+			}
+		}
+		headers = append(headers, h)
+	}
+
+	templateData := make(map[string]interface{})
+	templateData["entry"] = entry
+	templateData["feedback"] = f
+	templateData["headers"] = headers
+
+	return web.renderTemplate(w, r, "report", templateData)
+}
+
+func (web *web) reportXML(w http.ResponseWriter, r *http.Request) error {
+	entry, err := web.loadReport(r)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", reportFilename(entry)))
+	_, err = fmt.Fprint(w, entry.XML)
+	return err
+}
+
+// loadReport fetches the report named in the URL.
+func (web *web) loadReport(r *http.Request) (entry dbEntry, err error) {
+	id := chi.URLParam(r, "orgReportId")
+	if r.URL.RawPath != "" {
+		// The ID was escaped in the URL, so chi matched against the raw path.
+		if id, err = url.PathUnescape(id); err != nil {
+			return entry, notFound("invalid report ID")
+		}
+	}
+
+	entry, ok, err := web.getReport(r.Context(), id)
+	if err != nil {
+		return entry, upstreamError(err)
+	}
+	// Reports the user may not see are treated as missing so their IDs are
+	// not revealed.
+	if !ok || !(reportFilter{Allowed: allowedDomains(r)}).Allows(entry.Domain) {
+		return entry, notFound("report %v not found", id)
+	}
+	return
+}
+
+var unsafeFilename = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func reportFilename(e dbEntry) string {
+	return unsafeFilename.ReplaceAllString(e.OrgName+"-"+e.ReportID, "_") + ".xml"
+}
+
+// lessIP orders IPv4 addresses before IPv6 and numerically within each,
+// falling back to a string comparison for anything that is not an address.
+func lessIP(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return a < b
+	}
+	v4A, v4B := ipA.To4(), ipB.To4()
+	if (v4A == nil) != (v4B == nil) {
+		return v4A != nil
+	}
+	return bytes.Compare(ipA.To16(), ipB.To16()) < 0
+}
+
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+func (web *web) reporters(w http.ResponseWriter, r *http.Request) error {
+	web.initTemplates()
+
+	filter, err := parseFilter(r)
+	if err != nil {
+		return err
+	}
+
+	rows, err := web.queryAggregates(r.Context(), filter)
+	if err != nil {
+		return upstreamError(err)
+	}
+
+	orgs, days, domains := reporterTotals(filter, rows)
+
+	templateData := make(map[string]interface{})
+	templateData["filter"] = filter
+	templateData["presets"] = presetDays
+	templateData["domains"] = domains
+	templateData["orgs"] = orgs
+	templateData["days"] = days
+
+	return web.renderTemplate(w, r, "reporters", templateData)
+}
+
+// reporterTotals totals rows per reporter across the range of filter, and
+// per day for the reporter it selects, if any. The reporters are sorted by
+// the messages they saw, most first. domains are the domains of every row
+// the user may see, for choosing between them.
+func reporterTotals(filter reportFilter, rows []aggRow) (orgs, days []aggRow, domains []string) {
+	byOrg := map[string]*aggRow{}
+	byDate := map[string]*aggRow{}
+	seenDomains := map[string]bool{}
+	for _, row := range rows {
+		if !filter.Allows(row.Domain) {
+			continue
+		}
+		if row.Domain != "" && !seenDomains[row.Domain] {
+			seenDomains[row.Domain] = true
+			domains = append(domains, row.Domain)
+		}
+		// Every reporter is listed, whichever one is selected.
+		if filter.Domain != "" && filter.Domain != row.Domain {
+			continue
+		}
+
+		org, ok := byOrg[row.OrgName]
+		if !ok {
+			org = &aggRow{OrgName: row.OrgName}
+			byOrg[row.OrgName] = org
+		}
+		org.add(row)
+
+		if filter.Org != "" && row.OrgName == filter.Org {
+			day, ok := byDate[row.GMTDate]
+			if !ok {
+				day = &aggRow{GMTDate: row.GMTDate, OrgName: row.OrgName}
+				byDate[row.GMTDate] = day
+			}
+			day.add(row)
+		}
+	}
+
+	for _, org := range byOrg {
+		orgs = append(orgs, *org)
+	}
+	sort.Slice(orgs, func(i, j int) bool {
+		if orgs[i].Total() != orgs[j].Total() {
+			return orgs[i].Total() > orgs[j].Total()
+		}
+		return orgs[i].OrgName < orgs[j].OrgName
+	})
+	for _, day := range byDate {
+		days = append(days, *day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].GMTDate < days[j].GMTDate })
+	sort.Strings(domains)
+	return
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReporterTotals(t *testing.T) {
+	rows := []aggRow{
+		aggregate("2024-03-01", "example.com", "google.com", 3, 0),
+		aggregate("2024-03-01", "example.com", "outlook.com", 0, 4),
+		aggregate("2024-03-01", "example.org", "google.com", 5, 0),
+		aggregate("2024-03-02", "example.com", "google.com", 1, 1),
+		aggregate("2024-03-02", "example.org", "yahoo.com", 2, 0),
+		aggregate("2024-03-03", "example.net", "yahoo.com", 9, 0),
+	}
+
+	tests := []struct {
+		query   string
+		user    *user
+		orgs    string
+		days    string
+		domains string
+	}{
+		{"", nil, "[yahoo.com 2 11/0 google.com 3 9/1 outlook.com 1 0/4]", "[]", "[example.com example.net example.org]"},
+		{"domain=example.com", nil, "[google.com 2 4/1 outlook.com 1 0/4]", "[]", "[example.com example.net example.org]"},
+		{"org=google.com", nil, "[yahoo.com 2 11/0 google.com 3 9/1 outlook.com 1 0/4]", "[2024-03-01 2 8/0 2024-03-02 1 1/1]", "[example.com example.net example.org]"},
+		{"domain=example.com&org=google.com", nil, "[google.com 2 4/1 outlook.com 1 0/4]", "[2024-03-01 1 3/0 2024-03-02 1 1/1]", "[example.com example.net example.org]"},
+		{"org=aol.com", nil, "[yahoo.com 2 11/0 google.com 3 9/1 outlook.com 1 0/4]", "[]", "[example.com example.net example.org]"},
+		{"", &user{Domains: []string{"example.org"}}, "[google.com 1 5/0 yahoo.com 1 2/0]", "[]", "[example.org]"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/reporters/?from=2024-03-01&to=2024-03-03&"+test.query, nil)
+		if test.user != nil {
+			r = r.WithContext(context.WithValue(r.Context(), userKey{}, test.user))
+		}
+		filter, err := parseFilter(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		orgs, days, domains := reporterTotals(filter, rows)
+		if s := formatTotals(orgs, func(row aggRow) string { return row.OrgName }); s != test.orgs {
+			t.Errorf("Expected the reporters %v for %q but got %v", test.orgs, test.query, s)
+		}
+		if s := formatTotals(days, func(row aggRow) string { return row.GMTDate }); s != test.days {
+			t.Errorf("Expected the days %v for %q but got %v", test.days, test.query, s)
+		}
+		if s := fmt.Sprint(domains); s != test.domains {
+			t.Errorf("Expected the domains %v for %q but got %v", test.domains, test.query, s)
+		}
+	}
+}
+
+// formatTotals formats each of rows as its name, reports and
+// accepted/rejected messages.
+func formatTotals(rows []aggRow, name func(aggRow) string) string {
+	var totals []string
+	for _, row := range rows {
+		totals = append(totals, fmt.Sprintf("%v %v %v/%v", name(row), row.Reports, row.CountAccepted, row.CountRejected))
+	}
+	return fmt.Sprint(totals)
+}
@@ -1,29 +1,30 @@
 package main
 
 import (
+	"io/fs"
 	"log"
 	"net/http"
-	"os"
+	"time"
 
+	"github.com/ericdaugherty/dmarc/dnscheck"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/markbates/pkger"
 )
 
 type router struct {
-	devMode bool
+	devMode  bool
+	auth     *auth
+	location *time.Location
 }
 
 func (a *router) handler() http.Handler {
-	web := web{devMode: a.devMode}
+	web := web{devMode: a.devMode, checker: dnscheck.New(nil), logout: a.auth != nil && a.auth.method == authOIDC, assets: assets(a.devMode)}
 
-	var dir http.FileSystem = pkger.Dir("/public")
-	if a.devMode {
-		d, _ := os.Getwd()
-		log.Println("Pwd:", d)
-		dir = http.Dir("./public")
+	dir, err := fs.Sub(web.assets, "public")
+	if err != nil {
+		log.Fatal("Error opening public files", err)
 	}
-	public := http.FileServer(dir)
+	public := http.FileServer(http.FS(dir))
 
 	r := chi.NewRouter()
 
@@ -31,9 +32,40 @@ func (a *router) handler() http.Handler {
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
+	r.Use(withLocation(a.location))
 
-	r.Get("/", web.home)
-	r.Get("/date/{date}/", web.date)
+	r.Group(func(r chi.Router) {
+		r.Use(a.auth.pages)
+		r.Get("/", web.handle(web.home))
+		r.Get("/date/{date}/", web.handle(web.date))
+		r.Get("/report/{orgReportId}/", web.handle(web.reportDetail))
+		r.Get("/report/{orgReportId}/xml", web.handle(web.reportXML))
+		r.Get("/source/{ip}/", web.handle(web.source))
+		r.Get("/sources/", web.handle(web.sources))
+		r.Get("/reporters/", web.handle(web.reporters))
+		r.Get("/search/", web.handle(web.search))
+		r.Get("/domain/", web.handle(web.domain))
+		r.Get("/spf/", web.handle(web.spf))
+	})
+	r.With(a.auth.api).Get("/export/{kind}", web.handleAPI(web.export))
+	r.With(a.auth.api).Get("/feeds/{domain}/{kind}", web.handle(web.feed))
+	r.With(a.auth.api).Get("/metrics", web.handleAPI(web.metrics))
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(a.auth.api)
+		r.Get("/summary", web.handleAPI(web.apiSummary))
+		r.Get("/reports", web.handleAPI(web.apiReports))
+		r.Get("/reports/{orgReportId}", web.handleAPI(web.apiReport))
+		r.Get("/records", web.handleAPI(web.apiRecords))
+		r.Get("/search", web.handleAPI(web.apiSearch))
+		r.Get("/openapi.json", public.ServeHTTP)
+		r.NotFound(web.apiNotFound)
+		r.MethodNotAllowed(web.apiMethodNotAllowed)
+	})
+	if a.auth != nil && a.auth.method == authOIDC {
+		r.Get("/auth/login", web.handle(a.auth.login))
+		r.Get("/auth/callback", web.handle(a.auth.callback))
+		r.Get("/auth/logout", a.auth.logout)
+	}
 	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {
 		public.ServeHTTP(w, r)
 	})
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"html/template"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ericdaugherty/dmarc/report"
+)
+
+// searchResults is the most records the search page shows. The API pages
+// through all of them.
+const searchResults = 500
+
+// searchQuery is what to search the records in a range of reports for. Empty
+// fields match everything. Report and Org match part of the report ID and
+// reporting organization, Source an IP address or CIDR network, and the
+// domains and selector match in full, ignoring case. Result is pass or fail
+// for the DMARC result, or a disposition.
+type searchQuery struct {
+	Report       string
+	Org          string
+	Source       string
+	HeaderFrom   string
+	EnvelopeFrom string
+	DKIMDomain   string
+	DKIMSelector string
+	Result       string
+
+	network *net.IPNet
+}
+
+// searchResultValues are the values Result can take.
+var searchResultValues = []string{"pass", "fail", "none", "quarantine", "reject"}
+
+// parseSearch reads a search from the report, org, source, header_from,
+// envelope_from, dkim_domain, dkim_selector and result query parameters.
+func parseSearch(r *http.Request) (s searchQuery, err error) {
+	q := r.URL.Query()
+	s = searchQuery{
+		Report:       strings.TrimSpace(q.Get("report")),
+		Org:          strings.TrimSpace(q.Get("org")),
+		Source:       strings.TrimSpace(q.Get("source")),
+		HeaderFrom:   strings.TrimSpace(q.Get("header_from")),
+		EnvelopeFrom: strings.TrimSpace(q.Get("envelope_from")),
+		DKIMDomain:   strings.TrimSpace(q.Get("dkim_domain")),
+		DKIMSelector: strings.TrimSpace(q.Get("dkim_selector")),
+		Result:       strings.ToLower(strings.TrimSpace(q.Get("result"))),
+	}
+
+	if s.Source != "" {
+		if s.network, err = parseNetwork(s.Source); err != nil {
+			return s, badRequest("source must be an IP address or CIDR network, not %q", s.Source)
+		}
+	}
+	if s.Result != "" && !contains(searchResultValues, s.Result) {
+		return s, badRequest("result must be one of %v", strings.Join(searchResultValues, ", "))
+	}
+	return
+}
+
+// parseNetwork parses an IP address, as a network of just that address, or a
+// CIDR network.
+func parseNetwork(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		return network, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, &net.ParseError{Type: "IP address", Text: s}
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// Empty returns true if the search has nothing to search for.
+func (s searchQuery) Empty() bool {
+	return s.Report == "" && s.Org == "" && s.Source == "" && s.HeaderFrom == "" && s.EnvelopeFrom == "" &&
+		s.DKIMDomain == "" && s.DKIMSelector == "" && s.Result == ""
+}
+
+// matchesReport returns true if records in e can match the search.
+func (s searchQuery) matchesReport(e dbEntry) bool {
+	return containsFold(e.OrgReportID, s.Report) && containsFold(e.OrgName, s.Org)
+}
+
+// matchesRecord returns true if rec matches the search.
+func (s searchQuery) matchesRecord(rec report.Record) bool {
+	if s.network != nil {
+		ip := net.ParseIP(rec.Row.SourceIP)
+		if ip == nil || !s.network.Contains(ip) {
+			return false
+		}
+	}
+	if !equalFold(rec.Identifiers.HeaderFrom, s.HeaderFrom) || !equalFold(rec.Identifiers.EnvelopeFrom, s.EnvelopeFrom) {
+		return false
+	}
+	if s.DKIMDomain != "" || s.DKIMSelector != "" {
+		found := false
+		for _, d := range rec.AuthResults.Dkim {
+			if equalFold(d.Domain, s.DKIMDomain) && equalFold(d.Selector, s.DKIMSelector) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	pe := rec.Row.PolicyEvaluated
+	switch s.Result {
+	case "pass":
+		return pe.Dkim == "pass" || pe.Spf == "pass"
+	case "fail":
+		return pe.Dkim != "pass" && pe.Spf != "pass"
+	case "":
+		return true
+	default:
+		return pe.Disposition == s.Result
+	}
+}
+
+// containsFold returns true if s contains substr, ignoring case.
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// equalFold returns true if want is empty or s equals it, ignoring case.
+func equalFold(s, want string) bool {
+	return want == "" || strings.EqualFold(s, want)
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// eachMatch calls fn with every record matching s in the reports matching
+// filter after the record at after, oldest day first. It stops at the first
+// error from fn.
+func (web *web) eachMatch(r *http.Request, filter reportFilter, s searchQuery, after pageKey, fn func(sourceRecord) error) error {
+	return web.eachRecordAfter(r.Context(), filter, nil, after, func(rec sourceRecord) error {
+		if !s.matchesReport(rec.Entry) || !s.matchesRecord(rec.Record) {
+			return nil
+		}
+		return fn(rec)
+	})
+}
+
+// parseSearchFilter reads the date range and domain of a search. The org
+// parameter is part of the search rather than the filter, since it matches
+// part of the name.
+func parseSearchFilter(r *http.Request) (filter reportFilter, err error) {
+	filter, err = parseFilter(r)
+	if err == nil && filter.Days > maxSourceDays {
+		err = badRequest("search covers at most %v days", maxSourceDays)
+	}
+	filter.Org = ""
+	return
+}
+
+func (web *web) search(w http.ResponseWriter, r *http.Request) error {
+	web.initTemplates()
+
+	filter, err := parseSearchFilter(r)
+	if err != nil {
+		return err
+	}
+	s, err := parseSearch(r)
+	if err != nil {
+		return err
+	}
+
+	templateData := make(map[string]interface{})
+	templateData["filter"] = filter
+	templateData["search"] = s
+	templateData["results"] = searchResultValues
+
+	// A full report ID is found through the index, whatever its date.
+	if strings.Contains(s.Report, ":") {
+		entry, ok, err := web.getReport(r.Context(), s.Report)
+		if err != nil {
+			return upstreamError(err)
+		}
+		if ok && filter.Allows(entry.Domain) {
+			templateData["report"] = entry
+		}
+	}
+
+	if !s.Empty() {
+		var records []sourceRecord
+		total, messages := 0, 0
+		err = web.eachMatch(r, filter, s, pageKey{}, func(rec sourceRecord) error {
+			total++
+			messages += atoi(rec.Record.Row.Count)
+			if len(records) < searchResults {
+				records = append(records, rec)
+			}
+			return nil
+		})
+		if err != nil {
+			return upstreamError(err)
+		}
+		templateData["records"] = records
+		templateData["total"] = total
+		templateData["messages"] = messages
+		templateData["truncated"] = total > len(records)
+		templateData["query"] = template.URL(r.URL.Query().Encode())
+	}
+
+	return web.renderTemplate(w, r, "search", templateData)
+}
+
+func (web *web) apiSearch(w http.ResponseWriter, r *http.Request) error {
+	filter, err := parseSearchFilter(r)
+	if err != nil {
+		return err
+	}
+	s, err := parseSearch(r)
+	if err != nil {
+		return err
+	}
+	if s.Empty() {
+		return badRequest("nothing to search for, give at least one of report, org, source, header_from, envelope_from, dkim_domain, dkim_selector or result")
+	}
+
+	after, limit, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	p := pager[apiRecord]{limit: limit}
+	page, err := p.page(web.eachMatch(r, filter, s, after, func(f sourceRecord) error {
+		return p.add(newAPISourceRecord(f), f.key())
+	}))
+	if err != nil {
+		return upstreamError(err)
+	}
+
+	writeJSON(w, http.StatusOK, page)
+	return nil
+}
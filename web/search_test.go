@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ericdaugherty/dmarc/report"
+)
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		query   string
+		network string
+		ok      bool
+	}{
+		{"", "", true},
+		{"source=192.0.2.1", "192.0.2.1/32", true},
+		{"source=192.0.2.0/24", "192.0.2.0/24", true},
+		{"source=192.0.2.77/24", "192.0.2.0/24", true},
+		{"source=2001:db8::1", "2001:db8::1/128", true},
+		{"source=2001:db8::/32", "2001:db8::/32", true},
+		{"source=+192.0.2.1+", "192.0.2.1/32", true},
+		{"source=192.0.2.256", "", false},
+		{"source=192.0.2.0/33", "", false},
+		{"source=2001:db8::/129", "", false},
+		{"source=192.0.2.0/", "", false},
+		{"source=example.com", "", false},
+		{"result=Pass", "", true},
+		{"result=reject", "", true},
+		{"result=softfail", "", false},
+	}
+	for _, test := range tests {
+		s, err := parseSearch(httptest.NewRequest("GET", "/search?"+test.query, nil))
+		if (err == nil) != test.ok {
+			t.Errorf("Expected %q to be accepted %v but got %v", test.query, test.ok, err)
+			continue
+		}
+		network := ""
+		if s.network != nil {
+			network = s.network.String()
+		}
+		if err == nil && network != test.network {
+			t.Errorf("Expected the network %q for %q but got %q", test.network, test.query, network)
+		}
+	}
+}
+
+func TestMatchesRecord(t *testing.T) {
+	record := func(ip, dkim, spf, disposition string, signatures ...report.DKIMAuthResult) (rec report.Record) {
+		rec.Row.SourceIP = ip
+		rec.Row.PolicyEvaluated.Dkim = dkim
+		rec.Row.PolicyEvaluated.Spf = spf
+		rec.Row.PolicyEvaluated.Disposition = disposition
+		rec.Identifiers.HeaderFrom = "example.com"
+		rec.AuthResults.Dkim = signatures
+		return
+	}
+	signed := record("192.0.2.1", "pass", "fail", "none",
+		report.DKIMAuthResult{Domain: "example.com", Selector: "s1", Result: "pass"},
+		report.DKIMAuthResult{Domain: "mailer.example.net", Selector: "s2", Result: "fail"})
+	unsigned := record("2001:db8::25", "fail", "fail", "reject")
+
+	tests := []struct {
+		query  string
+		rec    report.Record
+		expect bool
+	}{
+		{"", signed, true},
+		{"source=192.0.2.1", signed, true},
+		{"source=192.0.2.2", signed, false},
+		{"source=192.0.2.0/24", signed, true},
+		{"source=192.0.3.0/24", signed, false},
+		{"source=192.0.2.0/24", record("::ffff:192.0.2.1", "pass", "pass", "none"), true},
+		{"source=192.0.2.0/24", record("not an ip", "pass", "pass", "none"), false},
+		{"source=2001:db8::/32", unsigned, true},
+		{"source=2001:db8::25", unsigned, true},
+		{"source=2001:db9::/32", unsigned, false},
+		{"source=2001:db8::/32", signed, false},
+		{"header_from=EXAMPLE.com", signed, true},
+		{"header_from=example.org", signed, false},
+		{"dkim_domain=example.com", signed, true},
+		{"dkim_selector=S2", signed, true},
+		{"dkim_selector=s3", signed, false},
+		{"dkim_domain=example.com&dkim_selector=s1", signed, true},
+		// The domain and selector have to be of the same signature.
+		{"dkim_domain=example.com&dkim_selector=s2", signed, false},
+		{"dkim_selector=s1", unsigned, false},
+		{"result=pass", signed, true},
+		{"result=pass", unsigned, false},
+		{"result=pass", record("192.0.2.1", "fail", "pass", "none"), true},
+		{"result=fail", signed, false},
+		{"result=fail", unsigned, true},
+		{"result=none", signed, true},
+		{"result=reject", signed, false},
+		{"result=reject", unsigned, true},
+		{"source=2001:db8::/32&result=quarantine", unsigned, false},
+	}
+	for _, test := range tests {
+		s, err := parseSearch(httptest.NewRequest("GET", "/search?"+test.query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.matchesRecord(test.rec); got != test.expect {
+			t.Errorf("Expected %q to match %v %v but got %v", test.query, test.rec.Row.SourceIP, test.expect, got)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/ericdaugherty/dmarc/report"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxSourceDays is the longest range the source page searches, since it has
+// to read every report in the range.
+const maxSourceDays = 90
+
+// sourceRecord is a record for the source IP along with the report it was in.
+type sourceRecord struct {
+	Entry  dbEntry
+	Record report.Record
+	// Index is the index of Record in its report.
+	Index int
+}
+
+// key returns where the record is in the order records are read.
+func (rec sourceRecord) key() pageKey {
+	return pageKey{Date: rec.Entry.GMTDate, ID: rec.Entry.OrgReportID, Record: rec.Index}
+}
+
+// sourceDay totals the messages from the source IP on one day.
+type sourceDay struct {
+	Date        string
+	Count       int
+	DKIMPass    int
+	SPFPass     int
+	Quarantined int
+	Rejected    int
+}
+
+// nameCount is a reporter or domain and the number of messages it saw.
+type nameCount struct {
+	Name  string
+	Count int
+}
+
+func (web *web) source(w http.ResponseWriter, r *http.Request) error {
+	web.initTemplates()
+
+	ip := net.ParseIP(chi.URLParam(r, "ip"))
+	if ip == nil {
+		return notFound("%q is not an IP address", chi.URLParam(r, "ip"))
+	}
+
+	filter, err := parseFilter(r)
+	if err == nil && filter.Days > maxSourceDays {
+		err = badRequest("the source page can search at most %v days", maxSourceDays)
+	}
+	if err != nil {
+		return err
+	}
+
+	records, err := web.queryRecords(r.Context(), filter, ip)
+	if err != nil {
+		return upstreamError(err)
+	}
+
+	days := map[string]*sourceDay{}
+	reporters := map[string]int{}
+	fromDomains := map[string]int{}
+	for _, rec := range records {
+		count := atoi(rec.Record.Row.Count)
+		date := rec.Entry.Date(filter.Location)
+		d, ok := days[date]
+		if !ok {
+			d = &sourceDay{Date: date}
+			days[date] = d
+		}
+		d.Count += count
+		if rec.Record.Row.PolicyEvaluated.Dkim == "pass" {
+			d.DKIMPass += count
+		}
+		if rec.Record.Row.PolicyEvaluated.Spf == "pass" {
+			d.SPFPass += count
+		}
+		switch rec.Record.Row.PolicyEvaluated.Disposition {
+		case "quarantine":
+			d.Quarantined += count
+		case "reject":
+			d.Rejected += count
+		}
+		reporters[rec.Entry.OrgName] += count
+		fromDomains[rec.Record.Identifiers.HeaderFrom] += count
+	}
+
+	var trend []sourceDay
+	for _, d := range days {
+		trend = append(trend, *d)
+	}
+	sort.Slice(trend, func(i, j int) bool { return trend[i].Date < trend[j].Date })
+
+	templateData := make(map[string]interface{})
+	templateData["ip"] = ip.String()
+	templateData["filter"] = filter
+	templateData["presets"] = []int{7, 30, maxSourceDays}
+	templateData["info"] = web.checker.LookupSource(r.Context(), ip)
+	templateData["days"] = trend
+	templateData["reporters"] = sortedCounts(reporters)
+	templateData["fromDomains"] = sortedCounts(fromDomains)
+	templateData["records"] = records
+
+	return web.renderTemplate(w, r, "source", templateData)
+}
+
+// queryRecords returns every record in the reports matching filter, or only
+// those for ip if it is not nil.
+func (web *web) queryRecords(ctx context.Context, filter reportFilter, ip net.IP) (records []sourceRecord, err error) {
+	err = web.eachRecord(ctx, filter, ip, func(rec sourceRecord) error {
+		records = append(records, rec)
+		return nil
+	})
+	return
+}
+
+// eachRecord calls fn with every record in the reports matching filter, or
+// only those for ip if it is not nil, oldest day first. It stops at the
+// first error from fn.
+func (web *web) eachRecord(ctx context.Context, filter reportFilter, ip net.IP, fn func(sourceRecord) error) error {
+	return web.eachRecordAfter(ctx, filter, ip, pageKey{}, fn)
+}
+
+// eachRecordAfter is eachRecord starting after the record at after. The
+// report it is in is read again for the records after it, and the others
+// are read from the one stored after it on.
+func (web *web) eachRecordAfter(ctx context.Context, filter reportFilter, ip net.IP, after pageKey, fn func(sourceRecord) error) error {
+	each := func(e dbEntry, f report.Feedback, first int) (err error) {
+		e.XML = ""
+		for i := first; i < len(f.Record); i++ {
+			rec := f.Record[i]
+			if ip == nil || ip.Equal(net.ParseIP(rec.Row.SourceIP)) {
+				if err = fn(sourceRecord{Entry: e, Record: rec, Index: i}); err != nil {
+					return
+				}
+			}
+		}
+		return
+	}
+
+	if after.ID != "" {
+		e, found, err := web.getStoredReport(ctx, after.Date, after.ID)
+		if err != nil {
+			return err
+		}
+		if found && filter.includes(e) {
+			if err = each(e, e.Feedback(), after.Record+1); err != nil {
+				return err
+			}
+		}
+	}
+	return web.eachReportAfter(ctx, filter, after, func(e dbEntry, f report.Feedback) error {
+		return each(e, f, 0)
+	})
+}
+
+// eachReport calls fn with every report matching filter and its parsed XML,
+// oldest day first. A report is in the range if the day it is listed under
+// is, see dbEntry.Date. Reports are read a page at a time so any range can
+// be streamed. It stops at the first error from fn.
+func (web *web) eachReport(ctx context.Context, filter reportFilter, fn func(dbEntry, report.Feedback) error) error {
+	return web.eachReportAfter(ctx, filter, pageKey{}, fn)
+}
+
+// eachReportAfter is eachReport starting after the report at after.
+func (web *web) eachReportAfter(ctx context.Context, filter reportFilter, after pageKey, fn func(dbEntry, report.Feedback) error) error {
+	return web.eachStored(ctx, storedDates(filter.From, filter.To, filter.Location), after, nil, func(e dbEntry) error {
+		if !filter.includes(e) {
+			return nil
+		}
+		return fn(e, e.Feedback())
+	})
+}
+
+// includes returns true if the report is listed under a day in the range of
+// f and matches its domain and org.
+func (f reportFilter) includes(e dbEntry) bool {
+	day := e.Date(f.Location)
+	return day >= f.From && day <= f.To && f.Matches(e.Domain, e.OrgName)
+}
+
+// sources charts the sources sending the most messages failing DMARC. It
+// reads every report in the range, so it is a page of its own rather than
+// part of the home page.
+func (web *web) sources(w http.ResponseWriter, r *http.Request) error {
+	web.initTemplates()
+
+	filter, err := parseFilter(r)
+	if err == nil && filter.Days > maxSourceDays {
+		err = badRequest("failing sources can be charted for at most %v days", maxSourceDays)
+	}
+	if err != nil {
+		return err
+	}
+
+	sources, err := web.failingSources(r.Context(), filter, topFailingSources)
+	if err != nil {
+		return upstreamError(err)
+	}
+	var bars []chartBar
+	for _, s := range sources {
+		bars = append(bars, chartBar{Label: s.Name, Value: s.Count, URL: "../source/" + s.Name + "/?" + string(filter.RangeQuery())})
+	}
+
+	templateData := make(map[string]interface{})
+	templateData["filter"] = filter
+	templateData["presets"] = []int{7, 30, maxSourceDays}
+	templateData["sources"] = sources
+	if len(bars) > 0 {
+		templateData["failingChart"] = barChart(bars, failColor)
+	}
+
+	return web.renderTemplate(w, r, "sources", templateData)
+}
+
+// failingSources returns the n source IPs that sent the most messages
+// failing both DKIM and SPF in the reports matching filter. Reports
+// quarantined by the inbound function are left out.
+func (web *web) failingSources(ctx context.Context, filter reportFilter, n int) (sources []nameCount, err error) {
+	counts := map[string]int{}
+	err = web.eachRecord(ctx, filter, nil, func(rec sourceRecord) error {
+		pe := rec.Record.Row.PolicyEvaluated
+		if !rec.Entry.Quarantined && pe.Dkim != "pass" && pe.Spf != "pass" {
+			counts[rec.Record.Row.SourceIP] += atoi(rec.Record.Row.Count)
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	sources = sortedCounts(counts)
+	if len(sources) > n {
+		sources = sources[:n]
+	}
+	return
+}
+
+// sortedCounts returns counts with the largest first.
+func sortedCounts(counts map[string]int) (sorted []nameCount) {
+	for name, count := range counts {
+		sorted = append(sorted, nameCount{name, count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/ericdaugherty/dmarc/report"
+)
+
+// Times are shown, and reports counted by day, in a time zone taken from the
+// tz query parameter, the user's timezone, or the TIMEZONE environment
+// variable, in that order. Without any of them it is UTC.
+//
+// In UTC the daily totals are read from the aggregates table, which counts
+// each report on the GMT date it begins. In any other time zone the reports
+// are read instead and the counts of each one are prorated across the local
+// days its date range covers.
+
+type locationKey struct{}
+
+// loadLocation reads the default time zone from TIMEZONE.
+func loadLocation() (*time.Location, error) {
+	name := os.Getenv("TIMEZONE")
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIMEZONE %q. %v", name, err)
+	}
+	return loc, nil
+}
+
+// withLocation makes loc the default time zone of every request.
+func withLocation(loc *time.Location) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), locationKey{}, loc)))
+		})
+	}
+}
+
+// requestLocation returns the time zone to show r in.
+func requestLocation(r *http.Request) (*time.Location, error) {
+	if name := r.URL.Query().Get("tz"); name != "" {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, badRequest("unknown time zone %q", name)
+		}
+		return loc, nil
+	}
+	if u := currentUser(r); u != nil && u.location != nil {
+		return u.location, nil
+	}
+	if loc, ok := r.Context().Value(locationKey{}).(*time.Location); ok {
+		return loc, nil
+	}
+	return time.UTC, nil
+}
+
+// prorated returns true if reports are split across the days of loc, rather
+// than counted on the GMT date they begin. Zones that are UTC under another
+// name, such as Etc/UTC, have no offset in winter or summer and are not.
+func prorated(loc *time.Location) bool {
+	year := time.Now().Year()
+	for _, month := range []time.Month{time.January, time.July} {
+		if _, offset := time.Date(year, month, 1, 0, 0, 0, 0, loc).Zone(); offset != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// storedDates returns the GMT dates reports covering the days from to to in
+// loc can be stored under. Reports are stored under the date they begin, so
+// when prorating this starts as long before the first day begins in GMT as a
+// report can cover.
+func storedDates(from, to string, loc *time.Location) (dates []string) {
+	first, _ := time.ParseInLocation(dateFormat, from, loc)
+	last, _ := time.ParseInLocation(dateFormat, to, loc)
+	start := first.UTC()
+	if prorated(loc) {
+		start = start.Add(-report.MaxSpan)
+	}
+	end := last.AddDate(0, 0, 1).Add(-time.Second).UTC().Format(dateFormat)
+	for d := start; d.Format(dateFormat) <= end; d = d.AddDate(0, 0, 1) {
+		dates = append(dates, d.Format(dateFormat))
+	}
+	return
+}
+
+// shares returns the days of loc the report covers and the fraction of it on
+// each day. A report longer than report.MaxSpan is split across the
+// report.MaxSpan from its start, so it is found by storedDates.
+func (e dbEntry) shares(loc *time.Location) []report.DayShare {
+	if !prorated(loc) {
+		return []report.DayShare{{Date: e.GMTDate, Fraction: 1}}
+	}
+	begin, end := time.Unix(int64(e.BeginTime), 0), time.Unix(int64(e.EndTime), 0)
+	if end.Sub(begin) > report.MaxSpan {
+		end = begin.Add(report.MaxSpan)
+	}
+	return report.SplitDays(begin, end, loc)
+}
+
+// Date returns the day of loc the report is listed under: the GMT date it
+// begins in UTC, otherwise the day most of its date range falls on.
+func (e dbEntry) Date(loc *time.Location) string {
+	shares := e.shares(loc)
+	for i, n := range report.Prorate(1, shares) {
+		if n == 1 {
+			return shares[i].Date
+		}
+	}
+	return e.GMTDate
+}
+
+// prorate splits the counts of a report across the days of loc it covers. The
+// report itself is counted on the day it is listed under.
+func prorate(e dbEntry, loc *time.Location) (rows []aggRow) {
+	shares := e.shares(loc)
+	reports := report.Prorate(1, shares)
+	accepted := report.Prorate(e.CountAccepted, shares)
+	quarantined := report.Prorate(e.CountQuarantined, shares)
+	rejected := report.Prorate(e.CountRejected, shares)
+	pass := report.Prorate(e.CountPass, shares)
+	dkimPass := report.Prorate(e.CountDKIMPass, shares)
+	spfPass := report.Prorate(e.CountSPFPass, shares)
+	for i, s := range shares {
+		rows = append(rows, aggRow{
+			GMTDate:          s.Date,
+			AggregateKey:     e.Domain + "#" + e.OrgName,
+			Domain:           e.Domain,
+			OrgName:          e.OrgName,
+			Reports:          reports[i],
+			CountAccepted:    accepted[i],
+			CountQuarantined: quarantined[i],
+			CountRejected:    rejected[i],
+			CountPass:        pass[i],
+			CountDKIMPass:    dkimPass[i],
+			CountSPFPass:     spfPass[i],
+		})
+	}
+	return
+}
+
+// formatTime formats a unix time in loc.
+func formatTime(loc *time.Location, t int) string {
+	return time.Unix(int64(t), 0).In(loc).Format("2006-01-02 15:04 MST")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProrated(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		prorated bool
+	}{
+		{"UTC", false},
+		{"Etc/UTC", false},
+		{"Etc/GMT", false},
+		{"Europe/London", true},
+		{"America/New_York", true},
+		{"Asia/Tokyo", true},
+	} {
+		loc, err := time.LoadLocation(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := prorated(loc); p != test.prorated {
+			t.Errorf("Expected %v for %v but got %v", test.prorated, test.name, p)
+		}
+	}
+}
+
+func TestStoredDates(t *testing.T) {
+	etc, _ := time.LoadLocation("Etc/UTC")
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+
+	dates := storedDates("2024-03-10", "2024-03-11", etc)
+	if expected := "[2024-03-10 2024-03-11]"; fmt.Sprint(dates) != expected {
+		t.Errorf("Expected %v but got %v", expected, dates)
+	}
+
+	// A day in Tokyo begins the day before in GMT, and a report covering it
+	// can have begun a week before that.
+	dates = storedDates("2024-03-10", "2024-03-11", tokyo)
+	if dates[0] != "2024-03-02" || dates[len(dates)-1] != "2024-03-11" {
+		t.Errorf("Expected 2024-03-02 to 2024-03-11 but got %v", dates)
+	}
+}
+
+func TestSharesLongReport(t *testing.T) {
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	begin := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	e := dbEntry{GMTDate: "2024-03-01", BeginTime: int(begin.Unix()), EndTime: int(begin.AddDate(0, 0, 30).Unix())}
+
+	shares := e.shares(tokyo)
+	last := shares[len(shares)-1].Date
+	if shares[0].Date != "2024-03-01" || last != "2024-03-08" {
+		t.Errorf("Expected a month long report to be split from 2024-03-01 to 2024-03-08 but got %v to %v", shares[0].Date, last)
+	}
+	var total float64
+	for _, s := range shares {
+		total += s.Fraction
+	}
+	if total < 0.999 || total > 1.001 {
+		t.Errorf("Expected the shares to add up to 1 but got %v", total)
+	}
+}
+
+func TestProratedRange(t *testing.T) {
+	for _, test := range []struct {
+		query    string
+		location string
+	}{
+		{"tz=Asia/Tokyo", "Asia/Tokyo"},
+		{"tz=Asia/Tokyo&days=31", "Asia/Tokyo"},
+		{"tz=Asia/Tokyo&days=90", "UTC"},
+		{"tz=Asia/Tokyo&from=2024-01-01&to=2024-12-31", "UTC"},
+		{"tz=Etc/UTC&days=90", "Etc/UTC"},
+	} {
+		f, err := parseFilter(httptest.NewRequest("GET", "/?"+test.query, nil))
+		if err != nil {
+			t.Fatalf("Error parsing %v: %v", test.query, err)
+		}
+		if f.Location.String() != test.location {
+			t.Errorf("Expected %v for %v but got %v", test.location, test.query, f.Location)
+		}
+	}
+}
@@ -4,8 +4,13 @@ import (
 	"context"
 	"fmt"
 	"html/template"
+	"io/fs"
+	"math"
+	"net"
 	"net/http"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -13,12 +18,15 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/ericdaugherty/dmarc/dnscheck"
+	"github.com/ericdaugherty/dmarc/report"
 	"github.com/go-chi/chi/v5"
 )
 
 type dbEntry struct {
 	GMTDate          string `json:"gmtDate"`
 	OrgReportID      string `json:"orgReportId"`
+	Domain           string `json:"domain"`
 	S3Bucket         string `json:"s3bucket"`
 	S3Key            string `json:"s3key"`
 	OrgName          string `json:"orgName"`
@@ -28,155 +36,665 @@ type dbEntry struct {
 	CountAccepted    int    `json:"countAccepted"`
 	CountQuarantined int    `json:"countQuarantined"`
 	CountRejected    int    `json:"countRejected"`
+	CountPass        int    `json:"countPass"`
+	CountDKIMPass    int    `json:"countDkimPass"`
+	CountSPFPass     int    `json:"countSpfPass"`
 	XML              string `json:"xml"`
+	AuthResult       string `json:"authResult"`
+	AuthDetail       string `json:"authDetail"`
+	Quarantined      bool   `json:"quarantined"`
 }
 
 func (e dbEntry) BeginTimeFormatted() string {
 	return time.Unix(int64(e.BeginTime), 0).UTC().Format(time.RFC3339)
 }
 
-type aggEntry struct {
-	GMTDate          string
-	CountAccepted    int
-	CountQuarantined int
-	CountRejected    int
+// Feedback returns the parsed XML report, or an empty report if it cannot be
+// parsed.
+func (e dbEntry) Feedback() report.Feedback {
+	f, err := report.Parse([]byte(e.XML))
+	if err != nil {
+		fmt.Printf("Error parsing report %v. %v\n", e.OrgReportID, err)
+	}
+	return f
+}
+
+// aggRow is a row of the aggregates table, holding the totals of every
+// report for one day, domain and reporting organization. Rows prorated from
+// the reports for another time zone hold a local date in GMTDate.
+type aggRow struct {
+	GMTDate          string `json:"gmtDate"`
+	AggregateKey     string `json:"aggregateKey"`
+	Domain           string `json:"domain"`
+	OrgName          string `json:"orgName"`
+	Reports          int    `json:"reports"`
+	CountAccepted    int    `json:"countAccepted"`
+	CountQuarantined int    `json:"countQuarantined"`
+	CountRejected    int    `json:"countRejected"`
+	CountPass        int    `json:"countPass"`
+	CountDKIMPass    int    `json:"countDkimPass"`
+	CountSPFPass     int    `json:"countSpfPass"`
+}
+
+// add adds the counts of o to row.
+func (row *aggRow) add(o aggRow) {
+	row.Reports += o.Reports
+	row.CountAccepted += o.CountAccepted
+	row.CountQuarantined += o.CountQuarantined
+	row.CountRejected += o.CountRejected
+	row.CountPass += o.CountPass
+	row.CountDKIMPass += o.CountDKIMPass
+	row.CountSPFPass += o.CountSPFPass
+}
+
+// Total returns the number of messages counted in row.
+func (row aggRow) Total() int {
+	return row.CountAccepted + row.CountQuarantined + row.CountRejected
 }
 
 type web struct {
-	devMode   bool
-	tmpl      *template.Template
+	devMode bool
+	logout  bool
+	checker *dnscheck.Checker
+	assets  fs.FS
+
+	// authorizations caches the unauthorized destinations of each domain.
+	authMu         sync.Mutex
+	authorizations map[string]cachedAuthorization
+
+	mu        sync.Mutex
 	templates map[string]*template.Template
+	// loaded is when the templates were last modified as of parsing them.
+	loaded time.Time
 }
 
-func (web *web) home(w http.ResponseWriter, r *http.Request) {
+func (web *web) home(w http.ResponseWriter, r *http.Request) error {
 	web.initTemplates()
 
-	entries, err := web.queryRecentReports(7)
+	filter, err := parseFilter(r)
 	if err != nil {
-		web.errorHandler(w, r, err.Error())
+		return err
+	}
+
+	entries, domains, orgs, err := web.queryReports(r.Context(), filter)
+	if err != nil {
+		return upstreamError(err)
+	}
+
+	checkDomains := domains
+	if filter.Domain != "" {
+		checkDomains = []string{filter.Domain}
 	}
 
 	templateData := make(map[string]interface{})
+	templateData["filter"] = filter
+	templateData["presets"] = presetDays
+	templateData["domains"] = domains
+	templateData["orgs"] = orgs
 	templateData["entries"] = entries
+	templateData["unauthorized"] = web.unauthorizedDestinations(r.Context(), checkDomains)
+	templateData["volumeChart"], templateData["passChart"] = dailyCharts(filter, entries)
+
+	return web.renderTemplate(w, r, "home", templateData)
+}
 
-	web.renderTemplate(w, r, "home", templateData)
+const (
+	acceptColor     = "#4caf50"
+	quarantineColor = "#ff9800"
+	failColor       = "#f44336"
+	dkimColor       = "#1f77b4"
+	spfColor        = "#9467bd"
+)
+
+// topFailingSources is the number of sources in the chart of sources
+// failing DMARC.
+const topFailingSources = 10
+
+// dailyCharts draws the messages by disposition and the DKIM and SPF pass
+// rates for each day of filter, from the daily totals in rows.
+func dailyCharts(filter reportFilter, rows []aggRow) (volume, pass template.HTML) {
+	byDate := map[string]aggRow{}
+	for _, row := range rows {
+		byDate[row.GMTDate] = row
+	}
+
+	dates := filter.Dates()
+	accepted := chartSeries{Name: "Accepted", Color: acceptColor}
+	quarantined := chartSeries{Name: "Quarantine", Color: quarantineColor}
+	rejected := chartSeries{Name: "Reject", Color: failColor}
+	dkim := chartSeries{Name: "DKIM pass", Color: dkimColor}
+	spf := chartSeries{Name: "SPF pass", Color: spfColor}
+	for _, date := range dates {
+		row := byDate[date]
+		accepted.Values = append(accepted.Values, float64(row.CountAccepted))
+		quarantined.Values = append(quarantined.Values, float64(row.CountQuarantined))
+		rejected.Values = append(rejected.Values, float64(row.CountRejected))
+
+		// Rates are left out for days without messages.
+		rate := func(n int) float64 {
+			if row.Total() == 0 {
+				return math.NaN()
+			}
+			return float64(n) * 100 / float64(row.Total())
+		}
+		dkim.Values = append(dkim.Values, rate(row.CountDKIMPass))
+		spf.Values = append(spf.Values, rate(row.CountSPFPass))
+	}
+
+	volume = stackedBarChart(dates, []chartSeries{accepted, quarantined, rejected})
+	pass = lineChart(dates, []chartSeries{dkim, spf})
+	return
 }
 
-func (web *web) date(w http.ResponseWriter, r *http.Request) {
+func (web *web) date(w http.ResponseWriter, r *http.Request) error {
 	web.initTemplates()
 
 	date := chi.URLParam(r, "date")
-
-	entries, err := web.getReports(date)
+	day, err := time.Parse(dateFormat, date)
+	if err != nil {
+		return badRequest("invalid date %q, dates are in the form 2006-01-02", date)
+	}
+	filter, err := newFilter(r)
 	if err != nil {
-		web.errorHandler(w, r, err.Error())
+		return err
+	}
+
+	// When prorating, a report is listed on every day its date range covers,
+	// along with the share of it counted on this day.
+	var entries []dbEntry
+	shares := map[string]string{}
+	for _, stored := range storedDates(date, date, filter.Location) {
+		reports, err := web.getReports(r.Context(), stored)
+		if err != nil {
+			return upstreamError(err)
+		}
+		for _, e := range reports {
+			if !filter.Matches(e.Domain, e.OrgName) {
+				continue
+			}
+			for _, s := range e.shares(filter.Location) {
+				if s.Date != date {
+					continue
+				}
+				entries = append(entries, e)
+				if s.Fraction < 1 {
+					shares[e.OrgReportID] = fmt.Sprintf("%.0f%%", s.Fraction*100)
+				}
+			}
+		}
 	}
 
 	templateData := make(map[string]interface{})
 	templateData["date"] = date
+	templateData["prev"] = day.AddDate(0, 0, -1).Format(dateFormat)
+	templateData["next"] = day.AddDate(0, 0, 1).Format(dateFormat)
+	templateData["filter"] = filter
 	templateData["entries"] = entries
+	templateData["shares"] = shares
 
-	web.renderTemplate(w, r, "date", templateData)
+	return web.renderTemplate(w, r, "date", templateData)
 }
 
-func (*web) errorHandler(w http.ResponseWriter, r *http.Request, errorDesc string) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(http.StatusInternalServerError)
-	fmt.Fprintf(w, "Server Error: %v", errorDesc)
+func (web *web) domain(w http.ResponseWriter, r *http.Request) error {
+	web.initTemplates()
+
+	domain := r.URL.Query().Get("name")
+
+	templateData := make(map[string]interface{})
+	templateData["domain"] = domain
+
+	if domain != "" {
+		in := dnscheck.Input{Domain: domain}
+		for _, s := range r.URL.Query()["selector"] {
+			in.Selectors = append(in.Selectors, dnscheck.Selector{Domain: domain, Selector: s})
+		}
+		templateData["result"] = web.checker.Check(r.Context(), in)
+	}
+
+	return web.renderTemplate(w, r, "domain", templateData)
+}
+
+func (web *web) spf(w http.ResponseWriter, r *http.Request) error {
+	web.initTemplates()
+
+	domain := r.URL.Query().Get("domain")
+	ip := r.URL.Query().Get("ip")
+
+	templateData := make(map[string]interface{})
+	templateData["domain"] = domain
+	templateData["ip"] = ip
+
+	if domain != "" {
+		exp := web.checker.ExpandSPF(r.Context(), domain)
+		templateData["expansion"] = exp
+		if exp.Tree != nil {
+			templateData["flattened"], templateData["flattenError"] = exp.Flatten()
+		}
+		if parsed := net.ParseIP(ip); parsed != nil {
+			templateData["verdict"] = exp.Evaluate(parsed)
+		}
+	}
+
+	return web.renderTemplate(w, r, "spf", templateData)
 }
 
-func (*web) queryRecentReports(days int) (entries []aggEntry, err error) {
+// authorizationTTL is how long the external destination checks of a domain
+// are kept before they are made again. They take several lookups each, and
+// the records they read rarely change.
+const authorizationTTL = 15 * time.Minute
+
+// cachedAuthorization holds the unauthorized destinations of a domain and
+// when they were checked.
+type cachedAuthorization struct {
+	results []dnscheck.AuthorizationResult
+	checked time.Time
+}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+// unauthorizedDestinations returns the external report destinations of each
+// domain that have not published a record authorizing them to receive its
+// reports. Domains not checked within authorizationTTL are checked at once.
+func (web *web) unauthorizedDestinations(ctx context.Context, domains []string) (results []dnscheck.AuthorizationResult) {
+	now := time.Now()
+	found := make([][]dnscheck.AuthorizationResult, len(domains))
+	checked := make([]bool, len(domains))
+	var wg sync.WaitGroup
+	web.authMu.Lock()
+	for i, domain := range domains {
+		if c, ok := web.authorizations[domain]; ok && now.Sub(c.checked) < authorizationTTL {
+			found[i] = c.results
+			continue
+		}
+		checked[i] = true
+		wg.Add(1)
+		go func(i int, domain string) {
+			defer wg.Done()
+			found[i] = web.checkAuthorization(ctx, domain)
+		}(i, domain)
+	}
+	web.authMu.Unlock()
+	wg.Wait()
+
+	web.authMu.Lock()
+	defer web.authMu.Unlock()
+	if web.authorizations == nil {
+		web.authorizations = map[string]cachedAuthorization{}
+	}
+	for i, domain := range domains {
+		// A cancelled request leaves lookups unfinished, so keep nothing from it.
+		if checked[i] && ctx.Err() == nil {
+			web.authorizations[domain] = cachedAuthorization{results: found[i], checked: now}
+		}
+		results = append(results, found[i]...)
+	}
+	return
+}
+
+// checkAuthorization returns the unauthorized external report destinations
+// of domain.
+func (web *web) checkAuthorization(ctx context.Context, domain string) (results []dnscheck.AuthorizationResult) {
+	dmarc := web.checker.CheckDMARC(ctx, domain)
+	if dmarc.Parsed == nil {
+		return
+	}
+	for _, a := range web.checker.CheckReportAuthorization(ctx, domain, dmarc.Parsed) {
+		if !a.Authorized {
+			results = append(results, a)
+		}
+	}
+	return
+}
+
+// reportsTable is the DynamoDB table the inbound function stores reports in,
+// reportIDIndex its index on orgReportId, and aggregatesTable the table it
+// maintains daily totals in.
+const (
+	reportsTable    = "dmarcReports"
+	reportIDIndex   = "orgReportId-index"
+	aggregatesTable = "dmarcAggregates"
+)
+
+// listAttributes are the attributes shown when listing reports, leaving out
+// the XML.
+var listAttributes = []string{"gmtDate", "orgReportId", "domain", "orgName", "reportId", "beginTime", "endTime",
+	"countAccepted", "countQuarantined", "countRejected", "countPass", "countDkimPass", "countSpfPass",
+	"authResult", "authDetail", "quarantined"}
+
+// summaryAttributes are the attributes needed to total reports, leaving out
+// the large XML attribute.
+var summaryAttributes = []string{"gmtDate", "domain", "orgName", "beginTime", "endTime", "countAccepted", "countQuarantined",
+	"countRejected", "countPass", "countDkimPass", "countSpfPass", "quarantined"}
+
+// queryReports returns the daily totals of the reports matching filter,
+// along with every domain and reporting organization seen in the date range
+// so they can be offered as filters.
+func (web *web) queryReports(ctx context.Context, filter reportFilter) (entries []aggRow, domains, orgs []string, err error) {
+	rows, err := web.queryAggregates(ctx, filter)
 	if err != nil {
 		return
 	}
 
-	svc := dynamodb.NewFromConfig(cfg)
+	seenDomains := map[string]bool{}
+	seenOrgs := map[string]bool{}
+	byDate := map[string]int{}
 
-	attributeValues := map[string]types.AttributeValue{}
-	filterExpression := ""
+	for _, row := range rows {
+		if !filter.Allows(row.Domain) {
+			continue
+		}
+		if row.Domain != "" && !seenDomains[row.Domain] {
+			seenDomains[row.Domain] = true
+			domains = append(domains, row.Domain)
+		}
+		if row.OrgName != "" && !seenOrgs[row.OrgName] {
+			seenOrgs[row.OrgName] = true
+			orgs = append(orgs, row.OrgName)
+		}
+		if !filter.Matches(row.Domain, row.OrgName) {
+			continue
+		}
 
-	current := time.Now().UTC()
-	for i := 0; i < days; i++ {
-		current = current.AddDate(0, 0, -1)
-		vName := fmt.Sprintf(":v%v", i)
-		attributeValues[vName] = &types.AttributeValueMemberS{Value: current.Format("2006-01-02")}
-		or := ""
-		if i > 0 {
-			or = " OR "
+		i, ok := byDate[row.GMTDate]
+		if !ok {
+			i = len(entries)
+			byDate[row.GMTDate] = i
+			entries = append(entries, aggRow{GMTDate: row.GMTDate})
 		}
-		filterExpression += fmt.Sprintf("%vgmtDate = %v", or, vName)
+		entries[i].add(row)
 	}
 
-	input := &dynamodb.ScanInput{
-		ExpressionAttributeValues: attributeValues,
-		FilterExpression:          aws.String(filterExpression),
-		TableName:                 aws.String("dmarcReports"),
-	}
-	result, err := svc.Scan(context.TODO(), input)
+	sort.Strings(domains)
+	sort.Strings(orgs)
+
+	return
+}
+
+// queryAggregates returns the aggregate rows for every date in filter,
+// oldest first. The rows are not filtered by domain or org.
+func (web *web) queryAggregates(ctx context.Context, filter reportFilter) (rows []aggRow, err error) {
+	err = web.eachDay(ctx, filter, func(date string, day []aggRow) error {
+		rows = append(rows, day...)
+		return nil
+	})
+	return
+}
+
+// eachDay calls fn with the aggregate rows for each date in filter in turn,
+// oldest first. When prorating, the rows are totalled from the reports,
+// which are all read before fn is first called.
+func (*web) eachDay(ctx context.Context, filter reportFilter, fn func(date string, rows []aggRow) error) (err error) {
+
+	svc, err := newDynamoDB(ctx)
 	if err != nil {
 		return
 	}
 
-	aggEntries := map[string]aggEntry{}
+	var byDate map[string][]aggRow
+	if prorated(filter.Location) {
+		if byDate, err = proratedAggregates(ctx, svc, filter); err != nil {
+			return
+		}
+	}
 
-	for _, r := range result.Items {
-		var entry dbEntry
-		err = attributevalue.UnmarshalMap(r, &entry)
-		if err != nil {
+	for _, date := range filter.Dates() {
+		rows := byDate[date]
+		if byDate == nil {
+			if rows, err = queryDayAggregates(ctx, svc, date); err != nil {
+				return
+			}
+		}
+		if err = fn(date, rows); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// proratedAggregates totals the reports covering the dates of filter into
+// rows for each day of its location, domain and reporting organization,
+// prorating the counts of reports that span several days.
+func proratedAggregates(ctx context.Context, svc dynamodb.QueryAPIClient, filter reportFilter) (byDate map[string][]aggRow, err error) {
+	byDate = map[string][]aggRow{}
+	index := map[string]int{}
+	for _, date := range storedDates(filter.From, filter.To, filter.Location) {
+		var reports []dbEntry
+		if reports, err = queryDate(ctx, svc, date, summaryAttributes...); err != nil {
 			return
 		}
-		e, ok := aggEntries[entry.GMTDate]
+		for _, entry := range reports {
+			if entry.Quarantined {
+				continue
+			}
+			for _, row := range prorate(entry, filter.Location) {
+				if row.GMTDate < filter.From || row.GMTDate > filter.To {
+					continue
+				}
+				key := row.GMTDate + "#" + row.AggregateKey
+				i, ok := index[key]
+				if !ok {
+					i = len(byDate[row.GMTDate])
+					index[key] = i
+					byDate[row.GMTDate] = append(byDate[row.GMTDate], aggRow{GMTDate: row.GMTDate, AggregateKey: row.AggregateKey, Domain: row.Domain, OrgName: row.OrgName})
+				}
+				byDate[row.GMTDate][i].add(row)
+			}
+		}
+	}
+	return
+}
+
+// queryDayAggregates returns the aggregate rows for date. Days stored before
+// the aggregates table existed have no rows, so their totals are computed
+// from the reports instead.
+func queryDayAggregates(ctx context.Context, svc dynamodb.QueryAPIClient, date string) (rows []aggRow, err error) {
+	err = queryPages(ctx, svc, keyQuery(aggregatesTable, "gmtDate", date, nil), &rows)
+	if err != nil || len(rows) > 0 {
+		return
+	}
+
+	var reports []dbEntry
+	err = queryPages(ctx, svc, keyQuery(reportsTable, "gmtDate", date, summaryAttributes), &reports)
+	if err != nil {
+		return
+	}
+
+	byKey := map[string]int{}
+	for _, entry := range reports {
+		if entry.Quarantined {
+			continue
+		}
+		key := entry.Domain + "#" + entry.OrgName
+		i, ok := byKey[key]
 		if !ok {
-			e = aggEntry{GMTDate: entry.GMTDate}
+			i = len(rows)
+			byKey[key] = i
+			rows = append(rows, aggRow{GMTDate: entry.GMTDate, AggregateKey: key, Domain: entry.Domain, OrgName: entry.OrgName})
 		}
-		e.CountAccepted += entry.CountAccepted
-		e.CountQuarantined += entry.CountQuarantined
-		e.CountRejected += entry.CountRejected
-		aggEntries[e.GMTDate] = e
+		rows[i].add(aggRow{
+			Reports:          1,
+			CountAccepted:    entry.CountAccepted,
+			CountQuarantined: entry.CountQuarantined,
+			CountRejected:    entry.CountRejected,
+			CountPass:        entry.CountPass,
+			CountDKIMPass:    entry.CountDKIMPass,
+			CountSPFPass:     entry.CountSPFPass,
+		})
+	}
+	return
+}
+
+// dynamoDB is the part of the DynamoDB API the web module uses.
+type dynamoDB interface {
+	dynamodb.QueryAPIClient
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+}
+
+// newDynamoDB returns a client for the report tables. Tests replace it.
+var newDynamoDB = func(ctx context.Context) (dynamoDB, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return dynamodb.NewFromConfig(cfg), nil
+}
+
+func (*web) getReports(ctx context.Context, date string) (entries []dbEntry, err error) {
+
+	svc, err := newDynamoDB(ctx)
+	if err != nil {
+		return
 	}
 
-	for _, v := range aggEntries {
-		entries = append(entries, v)
+	return queryDate(ctx, svc, date, listAttributes...)
+}
+
+// getReport returns the report with the orgReportId id, using the index on
+// orgReportId since the date is not known.
+func (*web) getReport(ctx context.Context, id string) (entry dbEntry, found bool, err error) {
+
+	svc, err := newDynamoDB(ctx)
+	if err != nil {
+		return
+	}
+
+	input := keyQuery(reportsTable, "orgReportId", id, nil)
+	input.IndexName = aws.String(reportIDIndex)
+
+	var entries []dbEntry
+	err = queryPages(ctx, svc, input, &entries)
+	if err != nil || len(entries) == 0 {
+		return
+	}
+
+	return entries[0], true, nil
+}
+
+// getStoredReport returns the report stored under date with the orgReportId
+// id.
+func (*web) getStoredReport(ctx context.Context, date, id string) (entry dbEntry, found bool, err error) {
+
+	svc, err := newDynamoDB(ctx)
+	if err != nil {
+		return
 	}
 
-	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].GMTDate < entries[j].GMTDate
+	out, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(reportsTable),
+		Key: map[string]types.AttributeValue{
+			"gmtDate":     &types.AttributeValueMemberS{Value: date},
+			"orgReportId": &types.AttributeValueMemberS{Value: id},
+		},
 	})
+	if err != nil || out.Item == nil {
+		return
+	}
 
-	return
+	err = attributevalue.UnmarshalMap(out.Item, &entry)
+	return entry, err == nil, err
 }
 
-func (*web) getReports(date string) (entries []dbEntry, err error) {
+// eachStored calls fn with every report stored under each of dates in turn,
+// in order of orgReportId, starting after the report at after. If
+// attributes are given only those are fetched. Reports are read a page at a
+// time, and it stops at the first error from fn.
+func (*web) eachStored(ctx context.Context, dates []string, after pageKey, attributes []string, fn func(dbEntry) error) (err error) {
 
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	svc, err := newDynamoDB(ctx)
 	if err != nil {
 		return
 	}
 
-	svc := dynamodb.NewFromConfig(cfg)
+	for _, date := range dates {
+		if date < after.Date {
+			continue
+		}
+		input := keyQuery(reportsTable, "gmtDate", date, attributes)
+		if date == after.Date {
+			input.ExclusiveStartKey = map[string]types.AttributeValue{
+				"gmtDate":     &types.AttributeValueMemberS{Value: after.Date},
+				"orgReportId": &types.AttributeValueMemberS{Value: after.ID},
+			}
+		}
+		err = eachPage(ctx, svc, input, func(items []map[string]types.AttributeValue) (err error) {
+			var entries []dbEntry
+			if err = attributevalue.UnmarshalListOfMaps(items, &entries); err != nil {
+				return
+			}
+			for _, e := range entries {
+				if err = fn(e); err != nil {
+					return
+				}
+			}
+			return
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// queryDate returns every report stored for date. If attributes are given
+// only those are fetched.
+func queryDate(ctx context.Context, svc dynamodb.QueryAPIClient, date string, attributes ...string) (entries []dbEntry, err error) {
+	err = queryPages(ctx, svc, keyQuery(reportsTable, "gmtDate", date, attributes), &entries)
+	return
+}
+
+// keyQuery returns a query for every item in table whose key attribute is
+// value. If attributes are given only those are fetched.
+func keyQuery(table, key, value string, attributes []string) *dynamodb.QueryInput {
 	input := &dynamodb.QueryInput{
+		ExpressionAttributeNames: map[string]string{"#k": key},
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":d": &types.AttributeValueMemberS{Value: date},
+			":v": &types.AttributeValueMemberS{Value: value},
 		},
-		KeyConditionExpression: aws.String("gmtDate = :d"),
-		TableName:              aws.String("dmarcReports"),
+		KeyConditionExpression: aws.String("#k = :v"),
+		TableName:              aws.String(table),
+	}
+	if len(attributes) > 0 {
+		// Attribute names such as domain are reserved words, so every name
+		// goes through a placeholder.
+		var projection []string
+		for i, a := range attributes {
+			name := fmt.Sprintf("#a%v", i)
+			input.ExpressionAttributeNames[name] = a
+			projection = append(projection, name)
+		}
+		input.ProjectionExpression = aws.String(strings.Join(projection, ", "))
 	}
+	return input
+}
 
-	result, err := svc.Query(context.TODO(), input)
+// queryPages runs input, following pagination, and decodes every item into
+// out, which must point to a slice.
+func queryPages(ctx context.Context, svc dynamodb.QueryAPIClient, input *dynamodb.QueryInput, out interface{}) (err error) {
+	var items []map[string]types.AttributeValue
+	err = eachPage(ctx, svc, input, func(page []map[string]types.AttributeValue) error {
+		items = append(items, page...)
+		return nil
+	})
 	if err != nil {
 		return
 	}
 
-	for _, r := range result.Items {
-		var entry dbEntry
-		err = attributevalue.UnmarshalMap(r, &entry)
+	return attributevalue.UnmarshalListOfMaps(items, out)
+}
+
+// eachPage runs input, following pagination, and calls fn with the items of
+// each page in turn. It stops at the first error from fn.
+func eachPage(ctx context.Context, svc dynamodb.QueryAPIClient, input *dynamodb.QueryInput, fn func(items []map[string]types.AttributeValue) error) (err error) {
+	p := dynamodb.NewQueryPaginator(svc, input)
+	for p.HasMorePages() {
+		var page *dynamodb.QueryOutput
+		page, err = p.NextPage(ctx)
 		if err != nil {
 			return
 		}
-		entries = append(entries, entry)
+		if err = fn(page.Items); err != nil {
+			return
+		}
 	}
-
 	return
 }
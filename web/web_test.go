@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// aggregate returns the aggregate row of date for domain from org.
+func aggregate(date, domain, org string, accepted, rejected int) aggRow {
+	return aggRow{GMTDate: date, AggregateKey: domain + "#" + org, Domain: domain, OrgName: org, Reports: 1, CountAccepted: accepted, CountRejected: rejected}
+}
+
+func TestQueryReports(t *testing.T) {
+	useDB(t, fakeDynamoDB{pageSize: 1, aggregates: []aggRow{
+		aggregate("2024-03-01", "example.com", "google.com", 3, 0),
+		aggregate("2024-03-01", "example.com", "yahoo.com", 2, 1),
+		aggregate("2024-03-01", "example.org", "google.com", 5, 0),
+		aggregate("2024-03-03", "example.org", "outlook.com", 1, 4),
+		aggregate("2024-03-04", "example.net", "google.com", 7, 0),
+	}})
+
+	tests := []struct {
+		query    string
+		accepted map[string]int
+		domains  []string
+		orgs     []string
+	}{
+		{"", map[string]int{"2024-03-01": 10, "2024-03-03": 1}, []string{"example.com", "example.org"}, []string{"google.com", "outlook.com", "yahoo.com"}},
+		{"&domain=example.com", map[string]int{"2024-03-01": 5}, []string{"example.com", "example.org"}, []string{"google.com", "outlook.com", "yahoo.com"}},
+		{"&org=google.com", map[string]int{"2024-03-01": 8}, []string{"example.com", "example.org"}, []string{"google.com", "outlook.com", "yahoo.com"}},
+		{"&domain=example.org&org=outlook.com", map[string]int{"2024-03-03": 1}, []string{"example.com", "example.org"}, []string{"google.com", "outlook.com", "yahoo.com"}},
+	}
+	for _, test := range tests {
+		filter, err := parseFilter(httptest.NewRequest("GET", "/?from=2024-03-01&to=2024-03-03"+test.query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, domains, orgs, err := (&web{}).queryReports(context.Background(), filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		accepted := map[string]int{}
+		for _, e := range entries {
+			accepted[e.GMTDate] = e.CountAccepted
+		}
+		if !reflect.DeepEqual(accepted, test.accepted) {
+			t.Errorf("Expected %v accepted for %q but got %v", test.accepted, test.query, accepted)
+		}
+		if !reflect.DeepEqual(domains, test.domains) || !reflect.DeepEqual(orgs, test.orgs) {
+			t.Errorf("Expected %v and %v for %q but got %v and %v", test.domains, test.orgs, test.query, domains, orgs)
+		}
+	}
+}
+
+func TestQueryDatePages(t *testing.T) {
+	var reports []dbEntry
+	for _, id := range []string{"e", "c", "a", "d", "b"} {
+		reports = append(reports, recordReport(id, "2024-03-01", "192.0.2.1"))
+	}
+	reports = append(reports, recordReport("f", "2024-03-02", "192.0.2.1"))
+
+	for _, pageSize := range []int{0, 1, 2, 5} {
+		entries, err := queryDate(context.Background(), fakeDynamoDB{reports: reports, pageSize: pageSize}, "2024-03-01")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, e := range entries {
+			ids = append(ids, e.OrgReportID)
+		}
+		if expected := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(ids, expected) {
+			t.Errorf("Expected %v with pages of %v but got %v", expected, pageSize, ids)
+		}
+	}
+}
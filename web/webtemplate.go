@@ -5,180 +5,141 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"path"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
-
-	"github.com/markbates/pkger"
 )
 
 // template related methods for web struct.
 
+// initTemplates parses the page templates the first time it is called. In
+// dev mode they are parsed again whenever a template file changes.
 func (web *web) initTemplates() {
+	web.mu.Lock()
+	defer web.mu.Unlock()
+
 	if web.templates != nil && !web.devMode {
 		return
 	}
 
-	if web.templates == nil {
-		web.templates = make(map[string]*template.Template)
+	modified, err := lastModified(web.assets, "templates")
+	if err != nil {
+		log.Fatal("Error initializing HTML Templates", err)
+	}
+	if web.templates != nil && !modified.After(web.loaded) {
+		return
 	}
 
-	// Force the include.
-	pkger.Include("/templates")
-
-	templatePaths, err := web.glob("/templates", "*.html")
+	templatePaths, err := fs.Glob(web.assets, "templates/*.html")
 	if err != nil {
 		log.Fatal("Error initializing HTML Templates", err)
 	}
-	// templateHelperPath := path.Join("templates", "helper.html")
-
-	funcMap := template.FuncMap{
-		"FormatUnixDate": func(date int) string { return time.Unix(int64(date), 0).UTC().Format(time.RFC3339) },
-		"CleanupXML":     func(xml string) string { return strings.ReplaceAll(xml, "&#xA;", "\n") },
+	// The layout and helpers shared by every page. They are parsed before the
+	// page, so blocks the page defines replace the layout's defaults.
+	partialPaths, err := fs.Glob(web.assets, "templates/partials/*.html")
+	if err != nil {
+		log.Fatal("Error initializing HTML Templates", err)
 	}
-	_ = uint64(34)
-
-	log.Printf("Loading %d templates from %v", len(templatePaths), "/templates")
 
+	funcMap := template.FuncMap{
+		"FormatUnixDate": func(loc *time.Location, date int) string {
+			return time.Unix(int64(date), 0).In(loc).Format(time.RFC3339)
+		},
+		"FormatTime": formatTime,
+		"CleanupXML": func(xml string) string { return strings.ReplaceAll(xml, "&#xA;", "\n") },
+		"Number":     formatNumber,
+		"Percent": func(n, total int) string {
+			if total == 0 {
+				return "-"
+			}
+			return fmt.Sprintf("%.1f%%", float64(n)*100/float64(total))
+		},
+	}
+
+	log.Printf("Loading %d templates from %v", len(templatePaths), "templates")
+
+	templates := make(map[string]*template.Template)
 	for _, filePath := range templatePaths {
 		name := strings.TrimSuffix(path.Base(filePath), ".html")
-		t := template.New(name).Funcs(funcMap)
-		// TODO: Generalize the abstraction of Pkger?
-		if web.devMode {
-			web.templates[name] = template.Must(t.ParseFiles("./" + filePath /*, "./"+templateHelperPath*/))
-		} else {
-			web.templates[name] = template.Must(web.parseFiles(t, filePath /*, templateHelperPath*/))
+		files := append(append([]string{}, partialPaths...), filePath)
+		t, err := template.New(name).Funcs(funcMap).ParseFS(web.assets, files...)
+		if err != nil {
+			if web.templates != nil {
+				// Keep serving the last good templates while a file is being edited.
+				log.Printf("Error reloading template %v. %v", filePath, err)
+				return
+			}
+			log.Fatal("Error initializing HTML Templates", err)
 		}
+		templates[name] = t
 	}
+	web.templates = templates
+	web.loaded = modified
+}
+
+// template returns the named page template.
+func (web *web) template(name string) (t *template.Template, ok bool) {
+	web.mu.Lock()
+	defer web.mu.Unlock()
+	t, ok = web.templates[name]
+	return
 }
 
-func (web *web) renderTemplate(w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}) {
+// renderTemplate renders the named template. It is rendered in full before
+// anything is written, so a failure can still be reported as an error.
+func (web *web) renderTemplate(w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}) error {
 
-	tmpl, ok := web.templates[name]
+	tmpl, ok := web.template(name)
 	if !ok {
-		web.errorHandler(w, r, fmt.Sprintf("No template found for name: %s", name))
-		return
+		return fmt.Errorf("no template found for name: %s", name)
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	err := tmpl.ExecuteTemplate(w, name+".html", data)
-	if err != nil {
-		web.errorHandler(w, r, fmt.Sprintf("Unable to Excecute Template %v. Error: %v", name, err))
+	var buf bytes.Buffer
+	if err := web.executeLayout(&buf, r, tmpl, data); err != nil {
+		return fmt.Errorf("unable to execute template %v. %v", name, err)
 	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err := buf.WriteTo(w)
+	return err
 }
 
-func (*web) glob(dir, pattern string) (m []string, e error) {
-	m = []string{}
-	fi, err := pkger.Stat(dir)
+// executeLayout renders a page within the layout, adding the data the layout
+// uses, and the time zone to show times in, to data.
+func (web *web) executeLayout(w io.Writer, r *http.Request, page *template.Template, data map[string]interface{}) error {
+	loc, err := requestLocation(r)
 	if err != nil {
-		return
-	}
-	if !fi.IsDir() {
-		return
+		loc = time.UTC
 	}
-	d, err := pkger.Open(dir)
-	if err != nil {
-		return
-	}
-	defer d.Close()
-
-	names, _ := d.Readdir(-1)
-
-	for _, n := range names {
-		matched, err := filepath.Match(pattern, n.Name())
-		if err != nil {
-			return m, err
-		}
-		if matched {
-			m = append(m, path.Join(dir, n.Name()))
-		}
-	}
-	return
+	data["loc"] = loc
+	data["root"] = rootPath(r)
+	data["user"] = currentUser(r)
+	data["logout"] = web.logout
+	return page.ExecuteTemplate(w, "layout.html", data)
 }
 
-// parseFiles is a copy of template.ParseFiles modified to use Pkger
-func (web *web) parseFiles(t *template.Template, filenames ...string) (*template.Template, error) {
-	if len(filenames) == 0 {
-		// Not really a problem, but be consistent.
-		return nil, fmt.Errorf("template: no files named in call to ParseFiles")
+// rootPath returns the relative path from the page requested by r to the
+// root of the site, so links work wherever the site is served from.
+func rootPath(r *http.Request) string {
+	depth := strings.Count(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	if depth == 0 {
+		return "./"
 	}
-	for _, filename := range filenames {
-		b, err := web.readFile(filename)
-		if err != nil {
-			return nil, err
-		}
-		s := string(b)
-		name := filepath.Base(filename)
-		// First template becomes return value if not already defined,
-		// and we use that one for subsequent New calls to associate
-		// all the templates together. Also, if this file has the same name
-		// as t, this file becomes the contents of t, so
-		//  t, err := New(name).Funcs(xxx).ParseFiles(name)
-		// works. Otherwise we create a new template associated with t.
-		var tmpl *template.Template
-		if t == nil {
-			t = template.New(name)
-		}
-		if name == t.Name() {
-			tmpl = t
-		} else {
-			tmpl = t.New(name)
-		}
-		_, err = tmpl.Parse(s)
-		if err != nil {
-			return nil, err
-		}
-	}
-	return t, nil
+	return strings.Repeat("../", depth)
 }
 
-// ReadFile is a copy of os.ReadFile modified to use Pkger
-func (web *web) readFile(filename string) ([]byte, error) {
-	f, err := pkger.Open(filename)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	// It's a good but not certain bet that FileInfo will tell us exactly how much to
-	// read, so let's try it but be prepared for the answer to be wrong.
-	var n int64 = bytes.MinRead
-
-	if fi, err := f.Stat(); err == nil {
-		// As initial capacity for readAll, use Size + a little extra in case Size
-		// is zero, and to avoid another allocation after Read has filled the
-		// buffer. The readAll call will read into its allocated internal buffer
-		// cheaply. If the size was wrong, we'll either waste some space off the end
-		// or reallocate as needed, but in the overwhelmingly common case we'll get
-		// it just right.
-		if size := fi.Size() + bytes.MinRead; size > n {
-			n = size
-		}
+// formatNumber formats n with thousands separators.
+func formatNumber(n int) string {
+	if n < 0 {
+		return "-" + formatNumber(-n)
 	}
-	return web.readAll(f, n)
-}
-
-// readAll is a copy of os.readAll needed because of use of
-func (*web) readAll(r io.Reader, capacity int64) (b []byte, err error) {
-	var buf bytes.Buffer
-	// If the buffer overflows, we will get bytes.ErrTooLarge.
-	// Return that as an error. Any other panic remains.
-	defer func() {
-		e := recover()
-		if e == nil {
-			return
-		}
-		if panicErr, ok := e.(error); ok && panicErr == bytes.ErrTooLarge {
-			err = panicErr
-		} else {
-			panic(e)
-		}
-	}()
-	if int64(int(capacity)) == capacity {
-		buf.Grow(int(capacity))
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
 	}
-	_, err = buf.ReadFrom(r)
-	return buf.Bytes(), err
+	return s
 }